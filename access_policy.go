@@ -0,0 +1,63 @@
+package mcp_golang
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metoro-io/mcp-golang/internal/protocol"
+)
+
+// FeatureKind is the kind of feature an AccessPolicy is asked about
+type FeatureKind string
+
+const (
+	FeatureKindTool     FeatureKind = "tool"
+	FeatureKindPrompt   FeatureKind = "prompt"
+	FeatureKindResource FeatureKind = "resource"
+)
+
+// Feature identifies the tool, prompt or resource an AccessPolicy is asked about
+type Feature struct {
+	Kind FeatureKind
+	// The name of the tool or prompt, or the URI of the resource
+	Name string
+}
+
+// AccessPolicy decides whether the client of a session may see and use a feature.
+// ctx belongs to the request being handled, get the client's session with SessionFromContext to look at its client
+// info or attributes. Features the policy rejects are left out of list results, and requests for them fail as if they
+// weren't registered.
+type AccessPolicy func(ctx context.Context, feature Feature) bool
+
+// WithAccessPolicy makes the server ask policy which tools, prompts and resources each session can see and use,
+// e.g. to offer different tools to different customer tiers. Every session sees everything otherwise.
+func WithAccessPolicy(policy AccessPolicy) ServerOptions {
+	return func(s *Server) {
+		s.accessPolicy = policy
+	}
+}
+
+func (s *Server) allowed(ctx context.Context, kind FeatureKind, name string) bool {
+	if s.accessPolicy == nil {
+		return true
+	}
+	return s.accessPolicy(ctx, Feature{Kind: kind, Name: name})
+}
+
+// JSON-RPC error codes for requests about features that don't exist, or that the session isn't allowed to see
+const (
+	errorCodeInvalidParams    = -32602
+	errorCodeResourceNotFound = -32002
+)
+
+func unknownToolError(name string) error {
+	return &protocol.RequestError{Code: errorCodeInvalidParams, Message: fmt.Sprintf("unknown tool: %s", name)}
+}
+
+func unknownPromptError(name string) error {
+	return &protocol.RequestError{Code: errorCodeInvalidParams, Message: fmt.Sprintf("unknown prompt: %s", name)}
+}
+
+func unknownResourceError(uri string) error {
+	return &protocol.RequestError{Code: errorCodeResourceNotFound, Message: fmt.Sprintf("unknown resource: %s", uri)}
+}
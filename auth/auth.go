@@ -0,0 +1,328 @@
+// Package auth protects MCP servers served over HTTP with OAuth 2.1 bearer tokens, as the MCP authorization spec
+// requires.
+//
+// An Authenticator validates JWT access tokens against the keys of the authorization server, checks their audience and
+// scopes, answers requests without a valid token with a WWW-Authenticate challenge, and serves the protected resource
+// metadata (RFC 9728) that tells clients where to get a token. The claims of the token reach tool, prompt and resource
+// handlers as the identity of their session.
+//
+// Usage:
+//
+//	keys := auth.NewRemoteKeySet("https://auth.example.com/.well-known/jwks.json")
+//	authenticator := auth.NewAuthenticator(keys, "https://mcp.example.com/mcp",
+//		auth.WithIssuer("https://auth.example.com"),
+//		auth.WithRequiredScopes("mcp:tools"),
+//	)
+//	httpTransport := streamablehttp.NewServerTransport()
+//	http.Handle("/", authenticator.Handler(httpTransport))
+//
+// Handlers then read the claims with SessionFromContext(ctx).Identity().
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// The path protected resource metadata is served at, followed by the path of the resource
+const metadataPathPrefix = "/.well-known/oauth-protected-resource"
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed, expired, badly signed or meant for someone else
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrInsufficientScope is returned for valid tokens that lack a required scope
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// Authenticator validates the bearer tokens of requests to a protected resource, the MCP server
+type Authenticator struct {
+	keys                 KeySet
+	resource             string
+	issuer               string
+	audiences            []string
+	requiredScopes       []string
+	scopesSupported      []string
+	authorizationServers []string
+	leeway               time.Duration
+	now                  func() time.Time
+}
+
+type AuthenticatorOptions func(*Authenticator)
+
+// WithIssuer only accepts tokens issued by issuer. The issuer is also advertised as the authorization server in the
+// protected resource metadata unless WithAuthorizationServers is given.
+func WithIssuer(issuer string) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.issuer = issuer
+	}
+}
+
+// WithAudience accepts tokens for any of audiences. By default tokens must be issued for the resource itself, so that
+// tokens meant for other services can't be passed on to the server.
+func WithAudience(audiences ...string) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.audiences = audiences
+	}
+}
+
+// WithRequiredScopes only accepts tokens that grant every one of scopes
+func WithRequiredScopes(scopes ...string) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.requiredScopes = scopes
+	}
+}
+
+// WithScopesSupported sets the scopes advertised in the protected resource metadata, the required scopes by default
+func WithScopesSupported(scopes ...string) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.scopesSupported = scopes
+	}
+}
+
+// WithAuthorizationServers sets the issuer URLs of the authorization servers advertised in the protected resource metadata
+func WithAuthorizationServers(servers ...string) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.authorizationServers = servers
+	}
+}
+
+// WithLeeway sets how much clock skew is tolerated when checking when tokens expire, a minute by default
+func WithLeeway(leeway time.Duration) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.leeway = leeway
+	}
+}
+
+// WithClock sets the function that tells the time, for tests
+func WithClock(now func() time.Time) AuthenticatorOptions {
+	return func(a *Authenticator) {
+		a.now = now
+	}
+}
+
+// NewAuthenticator creates an authenticator for resource, the canonical URL of the MCP server, e.g.
+// "https://mcp.example.com/mcp", accepting tokens signed with keys
+func NewAuthenticator(keys KeySet, resource string, options ...AuthenticatorOptions) *Authenticator {
+	a := &Authenticator{
+		keys:     keys,
+		resource: resource,
+		leeway:   time.Minute,
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(a)
+	}
+	if len(a.audiences) == 0 {
+		a.audiences = []string{resource}
+	}
+	if len(a.scopesSupported) == 0 {
+		a.scopesSupported = a.requiredScopes
+	}
+	if len(a.authorizationServers) == 0 && a.issuer != "" {
+		a.authorizationServers = []string{a.issuer}
+	}
+	return a
+}
+
+// Validate checks an access token and returns its claims. Errors wrap ErrInvalidToken, or ErrInsufficientScope if the
+// token is valid but lacks a required scope.
+func (a *Authenticator) Validate(ctx context.Context, rawToken string) (*Claims, error) {
+	t, err := parseToken(rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	key, err := a.keys.Key(ctx, t.header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	err = t.verify(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims := t.claims
+	now := a.now()
+	if claims.ExpiresAt.IsZero() {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidToken)
+	}
+	if now.After(claims.ExpiresAt.Add(a.leeway)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if !claims.NotBefore.IsZero() && now.Add(a.leeway).Before(claims.NotBefore) {
+		return nil, fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !slices.ContainsFunc(claims.Audience, func(audience string) bool {
+		return slices.Contains(a.audiences, audience)
+	}) {
+		return nil, fmt.Errorf("%w: token is not meant for this server", ErrInvalidToken)
+	}
+	for _, scope := range a.requiredScopes {
+		if !claims.HasScope(scope) {
+			return nil, fmt.Errorf("%w: missing scope %q", ErrInsufficientScope, scope)
+		}
+	}
+	return claims, nil
+}
+
+// Middleware requires a valid bearer token on every request to next. Requests without one are answered with a
+// WWW-Authenticate challenge pointing at the protected resource metadata.
+// The token's claims are passed on to the transport as the identity of the client, and can be read by plain HTTP
+// handlers with ClaimsFromContext.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Browsers don't send credentials with CORS preflight requests, the transport answers them
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rawToken, ok := bearerToken(r)
+		if !ok {
+			a.challenge(w, http.StatusUnauthorized, "", "")
+			return
+		}
+		claims, err := a.Validate(r.Context(), rawToken)
+		if errors.Is(err, ErrInsufficientScope) {
+			a.challenge(w, http.StatusForbidden, "insufficient_scope", err.Error())
+			return
+		}
+		if err != nil {
+			a.challenge(w, http.StatusUnauthorized, "invalid_token", err.Error())
+			return
+		}
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		ctx = transport.ContextWithIdentity(ctx, &transport.Identity{
+			Subject: claims.Subject,
+			Scopes:  claims.Scopes,
+			Claims:  claims.Raw,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Handler serves the protected resource metadata and protects every other path of next with Middleware
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	metadata := a.MetadataHandler()
+	mux.Handle(metadataPathPrefix, metadata)
+	if path := a.MetadataPath(); path != metadataPathPrefix {
+		mux.Handle(path, metadata)
+	}
+	mux.Handle("/", a.Middleware(next))
+	return mux
+}
+
+// ProtectedResourceMetadata tells clients which authorization servers issue tokens for the server, see RFC 9728
+type ProtectedResourceMetadata struct {
+	Resource               string   `json:"resource"`
+	AuthorizationServers   []string `json:"authorization_servers,omitempty"`
+	ScopesSupported        []string `json:"scopes_supported,omitempty"`
+	BearerMethodsSupported []string `json:"bearer_methods_supported,omitempty"`
+}
+
+// Metadata returns the protected resource metadata of the server
+func (a *Authenticator) Metadata() ProtectedResourceMetadata {
+	return ProtectedResourceMetadata{
+		Resource:               a.resource,
+		AuthorizationServers:   a.authorizationServers,
+		ScopesSupported:        a.scopesSupported,
+		BearerMethodsSupported: []string{"header"},
+	}
+}
+
+// MetadataPath is the path the protected resource metadata is served at, the well-known prefix followed by the path of
+// the resource, e.g. "/.well-known/oauth-protected-resource/mcp"
+func (a *Authenticator) MetadataPath() string {
+	u, err := url.Parse(a.resource)
+	if err != nil {
+		return metadataPathPrefix
+	}
+	return metadataPathPrefix + strings.TrimSuffix(u.Path, "/")
+}
+
+// MetadataHandler serves the protected resource metadata. Handler mounts it at the well-known paths already.
+func (a *Authenticator) MetadataHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Browser based clients need to read the metadata too
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.Metadata())
+	})
+}
+
+// The URL of the protected resource metadata, sent to clients in challenges
+func (a *Authenticator) metadataURL() string {
+	u, err := url.Parse(a.resource)
+	if err != nil {
+		return ""
+	}
+	u.Path = a.MetadataPath()
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// Answers a request that can't be let through with a WWW-Authenticate challenge, see RFC 6750
+func (a *Authenticator) challenge(w http.ResponseWriter, status int, code string, description string) {
+	params := []string{fmt.Sprintf("resource_metadata=%q", a.metadataURL())}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+	}
+	if description != "" {
+		params = append(params, fmt.Sprintf("error_description=%q", sanitizeDescription(description)))
+	}
+	if len(a.requiredScopes) > 0 {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(a.requiredScopes, " ")))
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if code == "" {
+		code = "invalid_request"
+		description = "missing bearer token"
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// Error descriptions may only contain printable ASCII other than quotes and backslashes
+func sanitizeDescription(description string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, description)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type claimsContextKey struct{}
+
+// ClaimsFromContext returns the claims of the token of a request that went through Middleware, or nil
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsContextKey{}).(*Claims)
+	return claims
+}
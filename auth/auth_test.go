@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+const testResource = "https://mcp.example.com/mcp"
+
+// A key pair of an authorization server under test
+type testKey struct {
+	kid     string
+	alg     string
+	private crypto.Signer
+}
+
+func newRSAKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return &testKey{kid: kid, alg: "RS256", private: private}
+}
+
+func newECKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return &testKey{kid: kid, alg: "ES256", private: private}
+}
+
+func newEd25519Key(t *testing.T, kid string) *testKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return &testKey{kid: kid, alg: "EdDSA", private: private}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// The public key in JWK form
+func (k *testKey) jwk() map[string]string {
+	switch pub := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "alg": k.alg, "use": "sig",
+			"n": encode(pub.N.Bytes()), "e": encode(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256",
+			"x": encode(pub.X.FillBytes(make([]byte, 32))), "y": encode(pub.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": k.kid, "crv": "Ed25519", "x": encode(pub)}
+	}
+	panic("unexpected key type")
+}
+
+func jwks(t *testing.T, keys ...*testKey) []byte {
+	t.Helper()
+	set := map[string]interface{}{"keys": []map[string]string{
+		// Symmetric keys are skipped
+		{"kty": "oct", "kid": "secret", "k": encode([]byte("secret"))},
+	}}
+	for _, key := range keys {
+		set["keys"] = append(set["keys"].([]map[string]string), key.jwk())
+	}
+	data, err := json.Marshal(set)
+	assert.NoError(t, err)
+	return data
+}
+
+func (k *testKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "at+jwt"})
+	assert.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	input := encode(header) + "." + encode(payload)
+
+	var signature []byte
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, hashOf(crypto.SHA256, []byte(input)))
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, private, hashOf(crypto.SHA256, []byte(input)))
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(private, []byte(input))
+	}
+	assert.NoError(t, err)
+	return input + "." + encode(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":       "https://auth.example.com",
+		"sub":       "alice",
+		"aud":       testResource,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"iat":       time.Now().Unix(),
+		"scope":     "mcp:tools mcp:prompts",
+		"client_id": "client",
+	}
+}
+
+func with(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	claims[name] = value
+	if value == nil {
+		delete(claims, name)
+	}
+	return claims
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey, ecKey, edKey := newRSAKey(t, "rsa"), newECKey(t, "ec"), newEd25519Key(t, "ed")
+	keys, err := ParseJWKS(jwks(t, rsaKey, ecKey, edKey))
+	assert.NoError(t, err)
+	authenticator := NewAuthenticator(keys, testResource,
+		WithIssuer("https://auth.example.com"),
+		WithRequiredScopes("mcp:tools"),
+	)
+
+	for _, key := range []*testKey{rsaKey, ecKey, edKey} {
+		t.Run("accepts "+key.alg, func(t *testing.T) {
+			claims, err := authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", claims.Subject)
+			assert.Equal(t, []string{testResource}, claims.Audience)
+			assert.Equal(t, []string{"mcp:tools", "mcp:prompts"}, claims.Scopes)
+			assert.Equal(t, "client", claims.ClientID)
+		})
+	}
+
+	otherKey := newRSAKey(t, "rsa")
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"malformed", "not.a.token", ErrInvalidToken},
+		{"unknown key", newRSAKey(t, "other").sign(t, validClaims()), ErrInvalidToken},
+		{"bad signature", otherKey.sign(t, validClaims()), ErrInvalidToken},
+		{"expired", rsaKey.sign(t, with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix())), ErrInvalidToken},
+		{"no expiry", rsaKey.sign(t, with(validClaims(), "exp", nil)), ErrInvalidToken},
+		{"not valid yet", rsaKey.sign(t, with(validClaims(), "nbf", time.Now().Add(time.Hour).Unix())), ErrInvalidToken},
+		{"other issuer", rsaKey.sign(t, with(validClaims(), "iss", "https://evil.example.com")), ErrInvalidToken},
+		{"other audience", rsaKey.sign(t, with(validClaims(), "aud", []string{"https://other.example.com"})), ErrInvalidToken},
+		{"missing scope", rsaKey.sign(t, with(validClaims(), "scope", "mcp:prompts")), ErrInsufficientScope},
+	}
+	for _, test := range tests {
+		t.Run("rejects "+test.name, func(t *testing.T) {
+			_, err := authenticator.Validate(context.Background(), test.token)
+			assert.ErrorIs(t, err, test.want)
+		})
+	}
+
+	t.Run("rejects unsigned tokens", func(t *testing.T) {
+		header := encode([]byte(`{"alg":"none","kid":"rsa"}`))
+		payload, _ := json.Marshal(validClaims())
+		_, err := authenticator.Validate(context.Background(), header+"."+encode(payload)+".")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("tolerates clock skew", func(t *testing.T) {
+		token := rsaKey.sign(t, validClaims())
+		late := NewAuthenticator(keys, testResource, WithClock(func() time.Time {
+			return time.Now().Add(time.Hour + 30*time.Second)
+		}))
+		_, err := late.Validate(context.Background(), token)
+		assert.NoError(t, err)
+		later := NewAuthenticator(keys, testResource, WithLeeway(0), WithClock(func() time.Time {
+			return time.Now().Add(time.Hour + 30*time.Second)
+		}))
+		_, err = later.Validate(context.Background(), token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestKeySets(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		key := newECKey(t, "")
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		assert.NoError(t, os.WriteFile(path, jwks(t, key), 0o600))
+		keys, err := LoadJWKSFile(path)
+		assert.NoError(t, err)
+
+		// A token without a kid is checked with the only key
+		_, err = NewAuthenticator(keys, testResource).Validate(context.Background(), key.sign(t, validClaims()))
+		assert.NoError(t, err)
+	})
+
+	t.Run("remote key rotation", func(t *testing.T) {
+		first, second := newRSAKey(t, "first"), newRSAKey(t, "second")
+		published := jwks(t, first)
+		fetches := 0
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			w.Write(published)
+		}))
+		defer jwksServer.Close()
+
+		now := time.Now()
+		keys := NewRemoteKeySet(jwksServer.URL)
+		keys.now = func() time.Time { return now }
+		authenticator := NewAuthenticator(keys, testResource)
+
+		_, err := authenticator.Validate(context.Background(), first.sign(t, validClaims()))
+		assert.NoError(t, err)
+		_, err = authenticator.Validate(context.Background(), first.sign(t, validClaims()))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, fetches)
+
+		published = jwks(t, first, second)
+		// Unknown keys aren't fetched again right away
+		_, err = authenticator.Validate(context.Background(), second.sign(t, validClaims()))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		now = now.Add(time.Minute)
+		_, err = authenticator.Validate(context.Background(), second.sign(t, validClaims()))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, fetches)
+	})
+
+	t.Run("skips unusable keys", func(t *testing.T) {
+		key := newECKey(t, "good")
+		var set map[string][]map[string]string
+		assert.NoError(t, json.Unmarshal(jwks(t, key), &set))
+		set["keys"] = append(set["keys"],
+			map[string]string{"kty": "EC", "kid": "k1", "crv": "secp256k1", "x": encode([]byte{1}), "y": encode([]byte{1})},
+			map[string]string{"kty": "RSA", "kid": "even", "n": encode([]byte{1, 2, 3}), "e": encode([]byte{2})},
+			map[string]string{"kty": "RSA", "kid": "malformed", "n": "!", "e": "AQAB"},
+		)
+		data, err := json.Marshal(set)
+		assert.NoError(t, err)
+
+		keys, err := ParseJWKS(data)
+		assert.NoError(t, err)
+		_, err = keys.Key(context.Background(), "good")
+		assert.NoError(t, err)
+		_, err = keys.Key(context.Background(), "k1")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		_, err = parseJWK([]byte(`{"kty":"EC","crv":"secp256k1"}`))
+		assert.ErrorIs(t, err, errUnsupportedKey)
+		_, err = parseJWK([]byte(`{"kty":"RSA","n":"AQID","e":"Ag"}`))
+		assert.ErrorIs(t, err, errUnsupportedKey)
+	})
+
+	t.Run("remote fetch failures", func(t *testing.T) {
+		key := newRSAKey(t, "key")
+		failing := false
+		fetches := 0
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			if failing {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write(jwks(t, key))
+		}))
+		defer jwksServer.Close()
+
+		now := time.Now()
+		keys := NewRemoteKeySet(jwksServer.URL, WithKeySetCacheDuration(time.Minute))
+		keys.now = func() time.Time { return now }
+		authenticator := NewAuthenticator(keys, testResource)
+
+		// Without keys to fall back to the error is reported, and not retried right away
+		failing = true
+		_, err := authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+		assert.Error(t, err)
+		_, err = authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+		assert.Error(t, err)
+		assert.Equal(t, 1, fetches)
+
+		failing = false
+		now = now.Add(time.Minute)
+		_, err = authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, fetches)
+
+		// Once the cached keys expire they are still used while the server fails
+		failing = true
+		now = now.Add(2 * time.Minute)
+		_, err = authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+		assert.NoError(t, err)
+		_, err = authenticator.Validate(context.Background(), key.sign(t, validClaims()))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, fetches)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	key := newRSAKey(t, "rsa")
+	keys, err := ParseJWKS(jwks(t, key))
+	assert.NoError(t, err)
+	authenticator := NewAuthenticator(keys, testResource,
+		WithIssuer("https://auth.example.com"),
+		WithRequiredScopes("mcp:tools"),
+	)
+	var identity *transport.Identity
+	var claims *Claims
+	handler := authenticator.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = transport.IdentityFromContext(r.Context())
+		claims = ClaimsFromContext(r.Context())
+	}))
+
+	request := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+	const metadataURL = `resource_metadata="https://mcp.example.com/.well-known/oauth-protected-resource/mcp"`
+
+	t.Run("challenges requests without a token", func(t *testing.T) {
+		resp := request("/mcp", "")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		challenge := resp.Header().Get("WWW-Authenticate")
+		assert.True(t, strings.HasPrefix(challenge, "Bearer "+metadataURL), challenge)
+		assert.NotContains(t, challenge, "error=")
+	})
+
+	t.Run("rejects invalid tokens", func(t *testing.T) {
+		resp := request("/mcp", key.sign(t, with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix())))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		challenge := resp.Header().Get("WWW-Authenticate")
+		assert.Contains(t, challenge, metadataURL)
+		assert.Contains(t, challenge, `error="invalid_token"`)
+	})
+
+	t.Run("rejects tokens without the required scopes", func(t *testing.T) {
+		resp := request("/mcp", key.sign(t, with(validClaims(), "scope", "mcp:prompts")))
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+		challenge := resp.Header().Get("WWW-Authenticate")
+		assert.Contains(t, challenge, `error="insufficient_scope"`)
+		assert.Contains(t, challenge, `scope="mcp:tools"`)
+	})
+
+	t.Run("passes the identity on", func(t *testing.T) {
+		resp := request("/mcp", key.sign(t, validClaims()))
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "alice", identity.Subject)
+		assert.True(t, identity.HasScope("mcp:tools"))
+		assert.Equal(t, "client", identity.Claims["client_id"])
+		assert.Equal(t, "alice", claims.Subject)
+	})
+
+	t.Run("lets CORS preflight requests through", func(t *testing.T) {
+		identity = nil
+		req := httptest.NewRequest(http.MethodOptions, "/mcp", nil)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Nil(t, identity)
+	})
+
+	t.Run("serves the protected resource metadata", func(t *testing.T) {
+		for _, path := range []string{"/.well-known/oauth-protected-resource", "/.well-known/oauth-protected-resource/mcp"} {
+			resp := request(path, "")
+			assert.Equal(t, http.StatusOK, resp.Code)
+			var metadata ProtectedResourceMetadata
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&metadata))
+			assert.Equal(t, ProtectedResourceMetadata{
+				Resource:               testResource,
+				AuthorizationServers:   []string{"https://auth.example.com"},
+				ScopesSupported:        []string{"mcp:tools"},
+				BearerMethodsSupported: []string{"header"},
+			}, metadata)
+		}
+	})
+}
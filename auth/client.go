@@ -0,0 +1,519 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrAuthorizationRequired is returned when there is no usable token for a server and the user has to authorize the
+// client again
+var ErrAuthorizationRequired = errors.New("authorization required")
+
+// AuthorizationHandler sends the user to authorizationURL, e.g. by opening it in their browser, and returns the URL the
+// authorization server redirected them back to: the redirect URI with the authorization code and state in its query.
+type AuthorizationHandler func(ctx context.Context, authorizationURL string) (*url.URL, error)
+
+// Authorizer gets access tokens for a client of servers protected with OAuth 2.1, as the MCP authorization spec
+// describes.
+// When a server answers 401 it finds the server's authorization server from the challenge and the metadata documents,
+// registers the client with it if needed, and runs the authorization code flow with PKCE. Tokens are kept in a
+// TokenStore and refreshed when they expire.
+//
+// Usage:
+//
+//	authorizer := auth.NewAuthorizer(
+//		auth.WithRedirectURI("http://127.0.0.1:8085/callback"),
+//		auth.WithAuthorizationHandler(openBrowserAndWaitForCallback),
+//		auth.WithTokenStore(auth.NewFileTokenStore("tokens.json")),
+//	)
+//	client := mcp_golang.NewClient(streamablehttp.NewClientTransport("https://mcp.example.com/mcp",
+//		streamablehttp.WithHTTPClient(authorizer.HTTPClient())))
+type Authorizer struct {
+	store       TokenStore
+	httpClient  *http.Client
+	handler     AuthorizationHandler
+	redirectURI string
+	clientName  string
+	// Set for clients registered with the authorization server beforehand
+	clientID     string
+	clientSecret string
+	scopes       []string
+	now          func() time.Time
+
+	// Held while authorizing or refreshing, so concurrent requests don't each start a flow
+	mu sync.Mutex
+	// Clients registered dynamically, by issuer
+	registrations map[string]clientRegistration
+}
+
+type clientRegistration struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type AuthorizerOptions func(*Authorizer)
+
+// WithTokenStore sets where tokens are kept, in memory by default
+func WithTokenStore(store TokenStore) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.store = store
+	}
+}
+
+// WithAuthorizationHandler sets how the user is sent to the authorization server. Without one, requests that need the
+// user to authorize the client fail with ErrAuthorizationRequired.
+func WithAuthorizationHandler(handler AuthorizationHandler) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.handler = handler
+	}
+}
+
+// WithRedirectURI sets the URI the authorization server sends the user back to, e.g. a loopback address the client
+// listens on
+func WithRedirectURI(uri string) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.redirectURI = uri
+	}
+}
+
+// WithClientName sets the name the client registers with
+func WithClientName(name string) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.clientName = name
+	}
+}
+
+// WithClientCredentials uses a client registered with the authorization server beforehand instead of registering one.
+// secret is empty for public clients.
+func WithClientCredentials(id string, secret string) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.clientID = id
+		a.clientSecret = secret
+	}
+}
+
+// WithScopes sets the scopes requested when the server doesn't say which it needs. The scopes the server supports are
+// requested otherwise.
+func WithScopes(scopes ...string) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.scopes = scopes
+	}
+}
+
+// WithAuthorizerHTTPClient sets the client used to talk to servers and authorization servers, http.DefaultClient is
+// used otherwise
+func WithAuthorizerHTTPClient(client *http.Client) AuthorizerOptions {
+	return func(a *Authorizer) {
+		a.httpClient = client
+	}
+}
+
+// NewAuthorizer creates an authorizer
+func NewAuthorizer(options ...AuthorizerOptions) *Authorizer {
+	a := &Authorizer{
+		store:         NewMemoryTokenStore(),
+		httpClient:    http.DefaultClient,
+		clientName:    "mcp-golang",
+		now:           time.Now,
+		registrations: make(map[string]clientRegistration),
+	}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+// HTTPClient returns a client that authorizes its requests, to be passed to HTTP transports
+func (a *Authorizer) HTTPClient() *http.Client {
+	client := *a.httpClient
+	client.Transport = a.RoundTripper(a.httpClient.Transport)
+	return &client
+}
+
+// RoundTripper wraps base, http.DefaultTransport if nil, to send the access token of the server with every request.
+// When the server rejects a request the client is authorized again and the request retried.
+func (a *Authorizer) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &roundTripper{authorizer: a, base: base}
+}
+
+// Token returns a valid token for resource, the URL of a server, refreshing the saved one if it expired.
+// The error wraps ErrAuthorizationRequired if there is none.
+func (a *Authorizer) Token(ctx context.Context, resource string) (*Token, error) {
+	token, err := a.store.Token(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid(a.now()) {
+		return token, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.refresh(ctx, resource)
+}
+
+// Authorize runs the authorization flow for resource, the URL of a server. wwwAuthenticate holds the challenges the
+// server answered with, if any.
+func (a *Authorizer) Authorize(ctx context.Context, resource string, wwwAuthenticate ...string) (*Token, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.authorize(ctx, resource, wwwAuthenticate)
+}
+
+// Must be called with the lock held
+func (a *Authorizer) refresh(ctx context.Context, resource string) (*Token, error) {
+	// Another request may have refreshed it while we waited for the lock
+	token, err := a.store.Token(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid(a.now()) {
+		return token, nil
+	}
+	if token == nil || token.RefreshToken == "" || token.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w for %s", ErrAuthorizationRequired, resource)
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	}
+	if token.Resource != "" {
+		form.Set("resource", token.Resource)
+	}
+	refreshed, err := a.requestToken(ctx, token.TokenEndpoint, token.ClientID, token.ClientSecret, form)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: refreshing the token failed: %w", ErrAuthorizationRequired, resource, err)
+	}
+	// Authorization servers that don't rotate refresh tokens leave them out of the response
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	refreshed.TokenEndpoint = token.TokenEndpoint
+	refreshed.ClientID = token.ClientID
+	refreshed.ClientSecret = token.ClientSecret
+	refreshed.Resource = token.Resource
+	err = a.store.SaveToken(ctx, resource, refreshed)
+	if err != nil {
+		return nil, err
+	}
+	return refreshed, nil
+}
+
+// Must be called with the lock held
+func (a *Authorizer) authorize(ctx context.Context, resource string, wwwAuthenticate []string) (*Token, error) {
+	params, _ := bearerChallenge(wwwAuthenticate)
+
+	// Servers that don't publish protected resource metadata are their own authorization server
+	issuer := origin(resource)
+	resourceIndicator := resource
+	var scopesSupported []string
+	metadata, err := a.discoverResource(ctx, resource, params["resource_metadata"])
+	switch {
+	case err == nil:
+		if len(metadata.AuthorizationServers) == 0 {
+			return nil, fmt.Errorf("protected resource metadata of %s names no authorization server", resource)
+		}
+		issuer = metadata.AuthorizationServers[0]
+		resourceIndicator = metadata.Resource
+		scopesSupported = metadata.ScopesSupported
+	case !errors.Is(err, errNoMetadata):
+		return nil, err
+	}
+	server, err := a.discoverAuthorizationServer(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.handler == nil {
+		return nil, fmt.Errorf("%w for %s: no authorization handler", ErrAuthorizationRequired, resource)
+	}
+	if a.redirectURI == "" {
+		return nil, fmt.Errorf("%w for %s: no redirect URI", ErrAuthorizationRequired, resource)
+	}
+	client, err := a.register(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := params["scope"]
+	if scope == "" && len(a.scopes) > 0 {
+		scope = strings.Join(a.scopes, " ")
+	}
+	if scope == "" {
+		scope = strings.Join(scopesSupported, " ")
+	}
+	verifier := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+	state := randomString()
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientID},
+		"redirect_uri":          {a.redirectURI},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"state":                 {state},
+		"resource":              {resourceIndicator},
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	authorizationURL, err := url.Parse(server.AuthorizationEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+	authorizationURL.RawQuery = query.Encode()
+
+	redirect, err := a.handler(ctx, authorizationURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("authorization failed: %w", err)
+	}
+	result := redirect.Query()
+	if result.Get("state") != state {
+		return nil, errors.New("authorization failed: state doesn't match")
+	}
+	if code := result.Get("error"); code != "" {
+		return nil, fmt.Errorf("authorization failed: %w", &tokenError{Code: code, Description: result.Get("error_description")})
+	}
+	if result.Get("code") == "" {
+		return nil, errors.New("authorization failed: no code")
+	}
+
+	token, err := a.requestToken(ctx, server.TokenEndpoint, client.ClientID, client.ClientSecret, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.Get("code")},
+		"redirect_uri":  {a.redirectURI},
+		"code_verifier": {verifier},
+		"resource":      {resourceIndicator},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authorization failed: %w", err)
+	}
+	token.TokenEndpoint = server.TokenEndpoint
+	token.ClientID = client.ClientID
+	token.ClientSecret = client.ClientSecret
+	token.Resource = resourceIndicator
+	err = a.store.SaveToken(ctx, resource, token)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns the client to authorize as, registering one with the authorization server if we don't have one, see RFC 7591
+func (a *Authorizer) register(ctx context.Context, server *AuthorizationServerMetadata) (clientRegistration, error) {
+	if a.clientID != "" {
+		return clientRegistration{ClientID: a.clientID, ClientSecret: a.clientSecret}, nil
+	}
+	if registration, ok := a.registrations[server.Issuer]; ok {
+		return registration, nil
+	}
+	if server.RegistrationEndpoint == "" {
+		return clientRegistration{}, fmt.Errorf("authorization server %q doesn't support registering clients, set the client's credentials", server.Issuer)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"client_name":                a.clientName,
+		"redirect_uris":              []string{a.redirectURI},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "none",
+	})
+	if err != nil {
+		return clientRegistration{}, fmt.Errorf("failed to marshal registration: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.RegistrationEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return clientRegistration{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	var registration clientRegistration
+	err = a.doJSON(req, &registration)
+	if err != nil {
+		return clientRegistration{}, fmt.Errorf("failed to register client: %w", err)
+	}
+	if registration.ClientID == "" {
+		return clientRegistration{}, errors.New("failed to register client: no client id")
+	}
+	a.registrations[server.Issuer] = registration
+	return registration, nil
+}
+
+// Requests a token from the token endpoint, authenticating with the client secret if there is one
+func (a *Authorizer) requestToken(ctx context.Context, endpoint string, clientID string, clientSecret string, form url.Values) (*Token, error) {
+	if clientSecret == "" {
+		form.Set("client_id", clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	var response struct {
+		AccessToken  string      `json:"access_token"`
+		TokenType    string      `json:"token_type"`
+		ExpiresIn    json.Number `json:"expires_in"`
+		RefreshToken string      `json:"refresh_token"`
+		Scope        string      `json:"scope"`
+	}
+	err = a.doJSON(req, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.AccessToken == "" {
+		return nil, errors.New("no access token in response")
+	}
+	if !strings.EqualFold(response.TokenType, "Bearer") {
+		return nil, fmt.Errorf("unsupported token type %q", response.TokenType)
+	}
+	token := &Token{
+		AccessToken:  response.AccessToken,
+		TokenType:    response.TokenType,
+		RefreshToken: response.RefreshToken,
+		Scope:        response.Scope,
+	}
+	if seconds, err := response.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = a.now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
+
+// Sends a request to the authorization server and unmarshals its response, turning OAuth error responses into errors
+func (a *Authorizer) doJSON(req *http.Request, v interface{}) error {
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var oauthErr tokenError
+		if json.Unmarshal(data, &oauthErr) == nil && oauthErr.Code != "" {
+			return &oauthErr
+		}
+		return fmt.Errorf("authorization server returned %s", resp.Status)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// An OAuth error response, see RFC 6749
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// A random string for PKCE verifiers and states
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+type roundTripper struct {
+	authorizer *Authorizer
+	base       http.RoundTripper
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resource := resourceURL(req.URL)
+	token, err := t.authorizer.Token(ctx, resource)
+	if err != nil && !errors.Is(err, ErrAuthorizationRequired) {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withToken(req, token))
+	if err != nil || !needsAuthorization(resp) {
+		return resp, err
+	}
+	// The request is only retried if its body can be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	challenges := resp.Header.Values("WWW-Authenticate")
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	token, err = t.authorizer.reauthorize(ctx, resource, challenges, token)
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(ctx)
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(withToken(retry, token))
+}
+
+// Authorizes the client again after the server rejected the token it was sent, unless another request already did
+func (a *Authorizer) reauthorize(ctx context.Context, resource string, challenges []string, rejected *Token) (*Token, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, err := a.store.Token(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	if token.Valid(a.now()) && (rejected == nil || token.AccessToken != rejected.AccessToken) {
+		return token, nil
+	}
+	return a.authorize(ctx, resource, challenges)
+}
+
+// Servers answer 401 without a valid token, and 403 with an insufficient_scope challenge if the token doesn't grant
+// enough
+func needsAuthorization(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	params, ok := bearerChallenge(resp.Header.Values("WWW-Authenticate"))
+	return ok && params["error"] == "insufficient_scope"
+}
+
+func withToken(req *http.Request, token *Token) *http.Request {
+	if token == nil {
+		return req
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return req
+}
@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	mcp_golang "github.com/metoro-io/mcp-golang"
+	"github.com/metoro-io/mcp-golang/transport/streamablehttp"
+	"github.com/stretchr/testify/assert"
+)
+
+// An authorization server that registers any client and lets every user authorize it
+type fakeAuthorizationServer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *testKey
+
+	mu             sync.Mutex
+	clients        map[string][]string // redirect URIs by client id
+	codes          map[string]authorizationRequest
+	refreshTokens  map[string]authorizationRequest
+	registrations  int
+	authorizations int
+	refreshes      int
+	expiresIn      int
+}
+
+type authorizationRequest struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	resource      string
+	scope         string
+}
+
+func newFakeAuthorizationServer(t *testing.T) *fakeAuthorizationServer {
+	as := &fakeAuthorizationServer{
+		t:             t,
+		key:           newRSAKey(t, "as"),
+		clients:       make(map[string][]string),
+		codes:         make(map[string]authorizationRequest),
+		refreshTokens: make(map[string]authorizationRequest),
+		expiresIn:     3600,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", as.metadata)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwks(t, as.key))
+	})
+	mux.HandleFunc("/register", as.register)
+	mux.HandleFunc("/authorize", as.authorize)
+	mux.HandleFunc("/token", as.token)
+	as.server = httptest.NewServer(mux)
+	t.Cleanup(as.server.Close)
+	return as
+}
+
+func (as *fakeAuthorizationServer) metadata(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(AuthorizationServerMetadata{
+		Issuer:                        as.server.URL,
+		AuthorizationEndpoint:         as.server.URL + "/authorize",
+		TokenEndpoint:                 as.server.URL + "/token",
+		RegistrationEndpoint:          as.server.URL + "/register",
+		JWKSURI:                       as.server.URL + "/jwks",
+		CodeChallengeMethodsSupported: []string{"S256"},
+	})
+}
+
+func (as *fakeAuthorizationServer) register(w http.ResponseWriter, r *http.Request) {
+	var registration struct {
+		RedirectURIs            []string `json:"redirect_uris"`
+		TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	}
+	assert.NoError(as.t, json.NewDecoder(r.Body).Decode(&registration))
+	assert.Equal(as.t, "none", registration.TokenEndpointAuthMethod)
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	as.registrations++
+	clientID := fmt.Sprintf("client-%d", as.registrations)
+	as.clients[clientID] = registration.RedirectURIs
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"client_id": clientID})
+}
+
+func (as *fakeAuthorizationServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	request := authorizationRequest{
+		clientID:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		resource:      query.Get("resource"),
+		scope:         query.Get("scope"),
+	}
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	redirectURIs, ok := as.clients[request.clientID]
+	if !ok || len(redirectURIs) == 0 || redirectURIs[0] != request.redirectURI {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	assert.Equal(as.t, "code", query.Get("response_type"))
+	assert.Equal(as.t, "S256", query.Get("code_challenge_method"))
+	as.authorizations++
+	code := randomString()
+	as.codes[code] = request
+
+	redirect, _ := url.Parse(request.redirectURI)
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (as *fakeAuthorizationServer) token(w http.ResponseWriter, r *http.Request) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	var request authorizationRequest
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		var ok bool
+		request, ok = as.codes[r.FormValue("code")]
+		delete(as.codes, r.FormValue("code"))
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || request.clientID != r.FormValue("client_id") || request.redirectURI != r.FormValue("redirect_uri") ||
+			request.codeChallenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			fail("invalid_grant")
+			return
+		}
+	case "refresh_token":
+		var ok bool
+		request, ok = as.refreshTokens[r.FormValue("refresh_token")]
+		if !ok || request.clientID != r.FormValue("client_id") {
+			fail("invalid_grant")
+			return
+		}
+		delete(as.refreshTokens, r.FormValue("refresh_token"))
+		as.refreshes++
+	default:
+		fail("unsupported_grant_type")
+		return
+	}
+
+	refreshToken := randomString()
+	as.refreshTokens[refreshToken] = request
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": as.key.sign(as.t, map[string]interface{}{
+			"iss":   as.server.URL,
+			"sub":   "alice",
+			"aud":   request.resource,
+			"exp":   time.Now().Add(time.Duration(as.expiresIn) * time.Second).Unix(),
+			"scope": request.scope,
+		}),
+		"token_type":    "Bearer",
+		"expires_in":    as.expiresIn,
+		"refresh_token": refreshToken,
+	})
+}
+
+// Stands in for the user's browser, approving the authorization and returning where it was redirected to
+func followAuthorization(ctx context.Context, authorizationURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authorizationURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorization server returned %s", resp.Status)
+	}
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+// Starts an MCP server protected by as, with a tool that names the authenticated user
+func newProtectedServer(t *testing.T, as *fakeAuthorizationServer) string {
+	t.Helper()
+	httpTransport := streamablehttp.NewServerTransport()
+	server := mcp_golang.NewServer(httpTransport)
+	type noArgs struct{}
+	err := mcp_golang.RegisterTool(server, "whoami", "Names the user", func(ctx context.Context, args noArgs) (*mcp_golang.ToolResponse, error) {
+		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(mcp_golang.SessionFromContext(ctx).Identity().Subject)), nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, server.Serve())
+
+	var handler http.Handler
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	resource := httpServer.URL + "/mcp"
+	authenticator := NewAuthenticator(NewRemoteKeySet(as.server.URL+"/jwks"), resource,
+		WithIssuer(as.server.URL),
+		WithRequiredScopes("mcp"),
+	)
+	handler = authenticator.Handler(httpTransport)
+	t.Cleanup(func() {
+		httpTransport.Close()
+		httpServer.Close()
+	})
+	return resource
+}
+
+func callWhoami(t *testing.T, resource string, authorizer *Authorizer) string {
+	t.Helper()
+	client := mcp_golang.NewClient(streamablehttp.NewClientTransport(resource, streamablehttp.WithHTTPClient(authorizer.HTTPClient())))
+	ctx := context.Background()
+	_, err := client.Initialize(ctx)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer client.Close()
+	result, err := client.CallTool(ctx, "whoami", map[string]interface{}{})
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	return result.Content[0].TextContent.Text
+}
+
+func TestAuthorizer(t *testing.T) {
+	t.Run("authorizes on the first request", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(followAuthorization),
+		)
+
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+		assert.Equal(t, 1, as.registrations)
+		assert.Equal(t, 1, as.authorizations)
+
+		token, err := authorizer.Token(context.Background(), resource)
+		assert.NoError(t, err)
+		assert.Equal(t, resource, token.Resource)
+		assert.Equal(t, "client-1", token.ClientID)
+	})
+
+	t.Run("refreshes expired tokens", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(followAuthorization),
+		)
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+
+		now := time.Now().Add(2 * time.Hour)
+		authorizer.now = func() time.Time { return now }
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+		assert.Equal(t, 1, as.authorizations)
+		assert.Equal(t, 1, as.refreshes)
+	})
+
+	t.Run("authorizes again when the server rejects the token", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		store := NewMemoryTokenStore()
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(followAuthorization),
+			WithTokenStore(store),
+		)
+		assert.NoError(t, store.SaveToken(context.Background(), resource, &Token{AccessToken: "revoked", TokenType: "Bearer"}))
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+		assert.Equal(t, 1, as.authorizations)
+	})
+
+	t.Run("keeps tokens in a file", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(followAuthorization),
+			WithTokenStore(NewFileTokenStore(path)),
+		)
+		assert.Equal(t, "alice", callWhoami(t, resource, authorizer))
+
+		// A new run of the agent can't ask the user, but doesn't need to
+		restarted := NewAuthorizer(WithTokenStore(NewFileTokenStore(path)))
+		assert.Equal(t, "alice", callWhoami(t, resource, restarted))
+		assert.Equal(t, 1, as.authorizations)
+	})
+
+	t.Run("fails without a way to ask the user", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		_, err := NewAuthorizer().Authorize(context.Background(), resource)
+		assert.ErrorIs(t, err, ErrAuthorizationRequired)
+	})
+
+	t.Run("rejects redirects with the wrong state", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(func(ctx context.Context, authorizationURL string) (*url.URL, error) {
+				redirect, err := followAuthorization(ctx, authorizationURL)
+				if err != nil {
+					return nil, err
+				}
+				query := redirect.Query()
+				query.Set("state", "forged")
+				redirect.RawQuery = query.Encode()
+				return redirect, nil
+			}),
+		)
+		_, err := authorizer.Authorize(context.Background(), resource)
+		assert.ErrorContains(t, err, "state doesn't match")
+	})
+
+	t.Run("reports authorization errors", func(t *testing.T) {
+		as := newFakeAuthorizationServer(t)
+		resource := newProtectedServer(t, as)
+		authorizer := NewAuthorizer(
+			WithRedirectURI("http://127.0.0.1:8085/callback"),
+			WithAuthorizationHandler(func(ctx context.Context, authorizationURL string) (*url.URL, error) {
+				return nil, errors.New("user declined")
+			}),
+		)
+		_, err := authorizer.Authorize(context.Background(), resource)
+		assert.ErrorContains(t, err, "user declined")
+	})
+}
+
+func TestParseChallenges(t *testing.T) {
+	challenges := parseChallenges(`Basic realm="example", Bearer resource_metadata="https://example.com/.well-known/oauth-protected-resource", error="invalid_token", error_description="say \"hi\"", scope=mcp`)
+	assert.Equal(t, []challenge{
+		{scheme: "Basic", params: map[string]string{"realm": "example"}},
+		{scheme: "Bearer", params: map[string]string{
+			"resource_metadata": "https://example.com/.well-known/oauth-protected-resource",
+			"error":             "invalid_token",
+			"error_description": `say "hi"`,
+			"scope":             "mcp",
+		}},
+	}, challenges)
+
+	params, ok := bearerChallenge([]string{`Basic realm="example"`, `Bearer scope="a b"`})
+	assert.True(t, ok)
+	assert.Equal(t, "a b", params["scope"])
+}
@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// AuthorizationServerMetadata describes the endpoints of an authorization server, see RFC 8414
+type AuthorizationServerMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	RegistrationEndpoint          string   `json:"registration_endpoint,omitempty"`
+	JWKSURI                       string   `json:"jwks_uri,omitempty"`
+	ScopesSupported               []string `json:"scopes_supported,omitempty"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Returned when a metadata document isn't at the URL we tried, so the next candidate is tried
+var errNoMetadata = errors.New("no metadata")
+
+// Finds the protected resource metadata of resource, at the URL from the server's challenge or at the well-known URLs
+func (a *Authorizer) discoverResource(ctx context.Context, resource string, metadataURL string) (*ProtectedResourceMetadata, error) {
+	candidates := []string{metadataURL}
+	if metadataURL == "" {
+		candidates = wellKnownURLs(resource, metadataPathPrefix)
+	}
+	for _, candidate := range candidates {
+		var metadata ProtectedResourceMetadata
+		err := a.fetchJSON(ctx, candidate, &metadata)
+		if errors.Is(err, errNoMetadata) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A server can't send us to get tokens for another server
+		if !coversResource(metadata.Resource, resource) {
+			return nil, fmt.Errorf("protected resource metadata is for %q, not %q", metadata.Resource, resource)
+		}
+		return &metadata, nil
+	}
+	return nil, errNoMetadata
+}
+
+// Finds the metadata of the authorization server with the given issuer, trying the OAuth and the OpenID Connect
+// well-known URLs
+func (a *Authorizer) discoverAuthorizationServer(ctx context.Context, issuer string) (*AuthorizationServerMetadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer %q: %w", issuer, err)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	var candidates []string
+	for _, prefix := range []string{"/.well-known/oauth-authorization-server", "/.well-known/openid-configuration"} {
+		candidate := *u
+		candidate.Path = prefix + path
+		candidates = append(candidates, candidate.String())
+	}
+	if path != "" {
+		candidate := *u
+		candidate.Path = path + "/.well-known/openid-configuration"
+		candidates = append(candidates, candidate.String())
+	}
+
+	for _, candidate := range candidates {
+		var metadata AuthorizationServerMetadata
+		err := a.fetchJSON(ctx, candidate, &metadata)
+		if errors.Is(err, errNoMetadata) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if metadata.Issuer != issuer {
+			return nil, fmt.Errorf("authorization server metadata is for %q, not %q", metadata.Issuer, issuer)
+		}
+		if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+			return nil, fmt.Errorf("authorization server %q has no authorization or token endpoint", issuer)
+		}
+		// Without PKCE an intercepted code could be exchanged for a token by someone else
+		if !slices.Contains(metadata.CodeChallengeMethodsSupported, "S256") {
+			return nil, fmt.Errorf("authorization server %q doesn't support PKCE", issuer)
+		}
+		return &metadata, nil
+	}
+	return nil, fmt.Errorf("no metadata found for authorization server %q", issuer)
+}
+
+func (a *Authorizer) fetchJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNoMetadata
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", url, err)
+	}
+	return nil
+}
+
+// The well-known URLs of the metadata of resource, first with the resource's path appended to the well-known path and
+// then at the root of its host
+func wellKnownURLs(resource string, wellKnownPath string) []string {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return nil
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	var urls []string
+	if path := strings.TrimSuffix(u.Path, "/"); path != "" {
+		withPath := *u
+		withPath.Path = wellKnownPath + path
+		urls = append(urls, withPath.String())
+	}
+	u.Path = wellKnownPath
+	return append(urls, u.String())
+}
+
+// Whether the resource in protected resource metadata is resource, or a prefix of its path on the same host
+func coversResource(metadataResource string, resource string) bool {
+	if metadataResource == "" {
+		return false
+	}
+	metadataResource = strings.TrimSuffix(metadataResource, "/")
+	return resource == metadataResource || strings.HasPrefix(resource, metadataResource+"/")
+}
+
+// The canonical URL of the server a request is for, which tokens are requested and stored for
+func resourceURL(u *url.URL) string {
+	resource := url.URL{
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   strings.ToLower(u.Host),
+		Path:   strings.TrimSuffix(u.Path, "/"),
+	}
+	return resource.String()
+}
+
+// The origin of a URL, the authorization server of servers that don't publish protected resource metadata
+func origin(resource string) string {
+	u, err := url.Parse(resource)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+// A challenge from a WWW-Authenticate header, see RFC 9110
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// Returns the parameters of the Bearer challenge in headers
+func bearerChallenge(headers []string) (map[string]string, bool) {
+	for _, header := range headers {
+		for _, c := range parseChallenges(header) {
+			if strings.EqualFold(c.scheme, "Bearer") {
+				return c.params, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Parses a WWW-Authenticate header, which can hold several challenges, e.g.
+// `Bearer resource_metadata="https://example.com/.well-known/oauth-protected-resource", Basic realm="example"`
+func parseChallenges(header string) []challenge {
+	var challenges []challenge
+	s := header
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return challenges
+		}
+		name := s[:strings.IndexFunc(s+" ", func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '=' || r == '"'
+		})]
+		if name == "" {
+			// Malformed, keep what we have
+			return challenges
+		}
+		rest := strings.TrimLeft(s[len(name):], " \t")
+		if !strings.HasPrefix(rest, "=") || len(challenges) == 0 {
+			challenges = append(challenges, challenge{scheme: name, params: make(map[string]string)})
+			s = rest
+			continue
+		}
+		value, rest := parseParamValue(strings.TrimLeft(rest[1:], " \t"))
+		challenges[len(challenges)-1].params[strings.ToLower(name)] = value
+		s = rest
+	}
+}
+
+// Parses a token or a quoted string, returning it and the rest of s
+func parseParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, " \t,")
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), s[i+1:]
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return value.String(), ""
+}
@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSONWebKey is a public key from a JSON Web Key Set
+type JSONWebKey struct {
+	// The key id tokens refer to in their header
+	Kid string
+	// The algorithm the key is meant for, empty if it isn't restricted
+	Alg string
+	// An *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	Key crypto.PublicKey
+}
+
+// KeySet finds the keys that access tokens are signed with
+type KeySet interface {
+	// Key returns the key with the given id. kid is empty for tokens that don't name their key, in which case a key set
+	// with a single key returns it.
+	Key(ctx context.Context, kid string) (*JSONWebKey, error)
+}
+
+// ErrKeyNotFound is returned by key sets that don't have the key a token was signed with
+var ErrKeyNotFound = errors.New("key not found")
+
+// StaticKeySet is a key set that never changes, e.g. one loaded from a file
+type StaticKeySet struct {
+	keys []*JSONWebKey
+}
+
+// ParseJWKS parses a JSON Web Key Set. Keys that can't verify signatures, such as symmetric keys or keys on curves
+// that aren't supported, are skipped, as are malformed keys, so that one bad key doesn't make the others unusable.
+func ParseJWKS(data []byte) (*StaticKeySet, error) {
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key set: %w", err)
+	}
+	keys := &StaticKeySet{}
+	for _, raw := range set.Keys {
+		key, err := parseJWK(raw)
+		if err != nil {
+			continue
+		}
+		keys.keys = append(keys.keys, key)
+	}
+	return keys, nil
+}
+
+// LoadJWKSFile reads a JSON Web Key Set from a file
+func LoadJWKSFile(path string) (*StaticKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key set: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+// Key implements KeySet
+func (s *StaticKeySet) Key(ctx context.Context, kid string) (*JSONWebKey, error) {
+	if kid == "" && len(s.keys) == 1 {
+		return s.keys[0], nil
+	}
+	for _, key := range s.keys {
+		if kid != "" && key.Kid == kid {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+}
+
+// RemoteKeySet fetches a JSON Web Key Set from a URL, usually the jwks_uri of the authorization server.
+// Keys are cached, and fetched again when they expire or when a token refers to a key that isn't cached, which is how
+// authorization servers rotate keys.
+type RemoteKeySet struct {
+	url        string
+	httpClient *http.Client
+	cacheFor   time.Duration
+	// Fetches happen at most this often, whether they failed or were for unknown keys, so that tokens with made up key
+	// ids or an unavailable authorization server can't flood it with requests
+	minRefresh   time.Duration
+	fetchTimeout time.Duration
+	now          func() time.Time
+
+	mu   sync.Mutex
+	keys *StaticKeySet
+	// When keys were last fetched, and when a fetch last finished whether it succeeded or not
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// Why the last fetch failed, returned while there are no keys to fall back to
+	err error
+	// Closed when the fetch in progress finishes, nil when there is none
+	fetching chan struct{}
+}
+
+type RemoteKeySetOptions func(*RemoteKeySet)
+
+// WithKeySetHTTPClient sets the client used to fetch the key set, http.DefaultClient is used otherwise.
+// Fetches time out after 10 seconds whatever the client's timeout is.
+func WithKeySetHTTPClient(client *http.Client) RemoteKeySetOptions {
+	return func(s *RemoteKeySet) {
+		s.httpClient = client
+	}
+}
+
+// WithKeySetCacheDuration sets how long fetched keys are used for before they are fetched again, an hour by default
+func WithKeySetCacheDuration(duration time.Duration) RemoteKeySetOptions {
+	return func(s *RemoteKeySet) {
+		s.cacheFor = duration
+	}
+}
+
+// NewRemoteKeySet creates a key set that fetches keys from url
+func NewRemoteKeySet(url string, options ...RemoteKeySetOptions) *RemoteKeySet {
+	s := &RemoteKeySet{
+		url:          url,
+		httpClient:   http.DefaultClient,
+		cacheFor:     time.Hour,
+		minRefresh:   10 * time.Second,
+		fetchTimeout: 10 * time.Second,
+		now:          time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Key implements KeySet. If fetching the key set fails, the keys fetched before are used until a fetch succeeds.
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (*JSONWebKey, error) {
+	s.mu.Lock()
+	done := s.fetching
+	if done == nil && s.needsFetch(kid) {
+		done = make(chan struct{})
+		s.fetching = done
+		// Not tied to the caller, who may give up waiting while others still need the keys
+		go s.fetch(context.WithoutCancel(ctx), done)
+	}
+	s.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	keys, err := s.keys, s.err
+	s.mu.Unlock()
+	if keys == nil {
+		return nil, err
+	}
+	return keys.Key(ctx, kid)
+}
+
+// Must be called with the lock held
+func (s *RemoteKeySet) needsFetch(kid string) bool {
+	now := s.now()
+	if now.Sub(s.attemptedAt) <= s.minRefresh {
+		return false
+	}
+	if s.keys == nil || now.Sub(s.fetchedAt) > s.cacheFor {
+		return true
+	}
+	// The key may have been added since we last fetched
+	_, err := s.keys.Key(context.Background(), kid)
+	return errors.Is(err, ErrKeyNotFound)
+}
+
+// Fetches the key set without holding the lock, then records the result and closes done
+func (s *RemoteKeySet) fetch(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, s.fetchTimeout)
+	defer cancel()
+	keys, err := s.fetchKeys(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = s.now()
+	s.err = err
+	if err == nil {
+		s.keys = keys
+		s.fetchedAt = s.attemptedAt
+	}
+	s.fetching = nil
+	close(done)
+}
+
+func (s *RemoteKeySet) fetchKeys(ctx context.Context) (*StaticKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key set: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch key set: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key set: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+var errUnsupportedKey = errors.New("unsupported key")
+
+func parseJWK(data []byte) (*JSONWebKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	err := json.Unmarshal(data, &jwk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key: %w", err)
+	}
+	// Keys meant for encryption can't verify tokens
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, errUnsupportedKey
+	}
+	key := &JSONWebKey{Kid: jwk.Kid, Alg: jwk.Alg}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", jwk.Kid, err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", jwk.Kid, err)
+		}
+		// crypto/rsa only verifies with odd exponents that fit in 31 bits
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() >= 1<<31 || e.Bit(0) == 0 {
+			return nil, fmt.Errorf("%w: exponent of key %q", errUnsupportedKey, jwk.Kid)
+		}
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		curve, ecdhCurve, err := curveByName(jwk.Crv)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		x, err := decodeFixed(jwk.X, (curve.Params().BitSize+7)/8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x of key %q: %w", jwk.Kid, err)
+		}
+		y, err := decodeFixed(jwk.Y, (curve.Params().BitSize+7)/8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y of key %q: %w", jwk.Kid, err)
+		}
+		// Rejects points that aren't on the curve
+		_, err = ecdhCurve.NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid point of key %q: %w", jwk.Kid, err)
+		}
+		key.Key = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeFixed(jwk.X, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x of key %q: %w", jwk.Kid, err)
+		}
+		key.Key = ed25519.PublicKey(x)
+	default:
+		return nil, errUnsupportedKey
+	}
+	return key, nil
+}
+
+func curveByName(name string) (elliptic.Curve, ecdh.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), ecdh.P256(), nil
+	case "P-384":
+		return elliptic.P384(), ecdh.P384(), nil
+	case "P-521":
+		return elliptic.P521(), ecdh.P521(), nil
+	}
+	return nil, nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, name)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func decodeFixed(value string, size int) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(b))
+	}
+	return b, nil
+}
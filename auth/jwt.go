@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Claims are the claims of a validated access token
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	// The scopes in the scope claim, or in the scp claim that some authorization servers use instead
+	Scopes []string
+	// The client the token was issued to, from the client_id or azp claim
+	ClientID string
+	// Every claim of the token
+	Raw map[string]interface{}
+}
+
+// HasScope reports whether the token grants scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// The parts of a compact JWS
+type token struct {
+	header       tokenHeader
+	signingInput []byte
+	signature    []byte
+	claims       *Claims
+}
+
+func parseToken(raw string) (*token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+	t := &token{signingInput: []byte(parts[0] + "." + parts[1])}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid header encoding: %w", err)
+	}
+	err = json.Unmarshal(header, &t.header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	t.signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload encoding: %w", err)
+	}
+	t.claims, err = parseClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseClaims(payload []byte) (*Claims, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var raw map[string]interface{}
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.ClientID, _ = raw["client_id"].(string)
+	if claims.ClientID == "" {
+		claims.ClientID, _ = raw["azp"].(string)
+	}
+	claims.Audience, err = stringOrList(raw["aud"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid aud claim: %w", err)
+	}
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else if scp, ok := raw["scp"]; ok {
+		claims.Scopes, err = stringOrList(scp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scp claim: %w", err)
+		}
+	}
+	for name, date := range map[string]*time.Time{"exp": &claims.ExpiresAt, "nbf": &claims.NotBefore, "iat": &claims.IssuedAt} {
+		*date, err = numericDate(raw[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s claim: %w", name, err)
+		}
+	}
+	return claims, nil
+}
+
+func stringOrList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(v), nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("expected strings")
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, errors.New("expected a string or a list of strings")
+}
+
+// Dates are seconds since the epoch, the zero time is returned if the claim is missing
+func numericDate(value interface{}) (time.Time, error) {
+	if value == nil {
+		return time.Time{}, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("expected a number")
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// Checks the token's signature with key. Only asymmetric algorithms are accepted, so a token can't pick an algorithm
+// that makes a public key usable as a shared secret.
+func (t *token) verify(key *JSONWebKey) error {
+	alg := t.header.Alg
+	if key.Alg != "" && key.Alg != alg {
+		return fmt.Errorf("key %q is for %s, token is signed with %s", key.Kid, key.Alg, alg)
+	}
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		pub, ok := key.Key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q can't verify %s", key.Kid, alg)
+		}
+		hash := hashFor(alg)
+		digest := hashOf(hash, t.signingInput)
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(pub, hash, digest, t.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, t.signature)
+	case "ES256", "ES384", "ES512":
+		pub, ok := key.Key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().Name != ecdsaCurves[alg] {
+			return fmt.Errorf("key %q can't verify %s", key.Kid, alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, hashOf(hashFor(alg), t.signingInput), r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.Key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %q can't verify %s", key.Kid, alg)
+		}
+		if !ed25519.Verify(pub, t.signingInput, t.signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+var ecdsaCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+func hashFor(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+func hashOf(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Token is an access token a client got for a server, along with what it needs to refresh it
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	Scope        string    `json:"scope,omitempty"`
+	// The endpoint the token is refreshed at, and the client it was issued to
+	TokenEndpoint string `json:"token_endpoint,omitempty"`
+	ClientID      string `json:"client_id,omitempty"`
+	ClientSecret  string `json:"client_secret,omitempty"`
+	// The resource indicator the token was requested for
+	Resource string `json:"resource,omitempty"`
+}
+
+// Tokens are refreshed this long before they expire, so they don't expire on the way to the server
+const expiryDelta = 30 * time.Second
+
+// Valid reports whether the access token can still be used at now
+func (t *Token) Valid(now time.Time) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(expiryDelta).Before(t.Expiry))
+}
+
+// TokenStore keeps the tokens of a client between requests, and between runs if it is persistent.
+// Tokens are keyed by the URL of the server they are for.
+type TokenStore interface {
+	// Token returns the token saved for resource, or nil if there is none
+	Token(ctx context.Context, resource string) (*Token, error)
+	// SaveToken saves the token for resource, replacing the one saved before
+	SaveToken(ctx context.Context, resource string, token *Token) error
+}
+
+// MemoryTokenStore keeps tokens for as long as the process runs
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// NewMemoryTokenStore creates an empty memory token store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]Token)}
+}
+
+// Token implements TokenStore
+func (s *MemoryTokenStore) Token(ctx context.Context, resource string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[resource]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// SaveToken implements TokenStore
+func (s *MemoryTokenStore) SaveToken(ctx context.Context, resource string, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[resource] = *token
+	return nil
+}
+
+// FileTokenStore keeps tokens in a JSON file only the current user can read, so agents stay authorized across runs
+type FileTokenStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileTokenStore creates a token store backed by the file at path, which is created when the first token is saved
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+// Token implements TokenStore
+func (s *FileTokenStore) Token(ctx context.Context, resource string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	token, ok := tokens[resource]
+	if !ok {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// SaveToken implements TokenStore
+func (s *FileTokenStore) SaveToken(ctx context.Context, resource string, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens[resource] = *token
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tokens: %w", err)
+	}
+	// Written to a temporary file first so a crash can't leave a half written file behind
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+	return nil
+}
+
+func (s *FileTokenStore) load() (map[string]Token, error) {
+	tokens := make(map[string]Token)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tokens: %w", err)
+	}
+	return tokens, nil
+}
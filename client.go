@@ -307,6 +307,9 @@ func (j *ListPromptsRequest) UnmarshalJSON(b []byte) error {
 // schema, but this is not a closed set: any client can define its own, additional
 // capabilities.
 type ClientCapabilities struct {
+	// Present if the client supports elicitation from the server.
+	Elicitation *ClientCapabilitiesElicitation `json:"elicitation,omitempty" yaml:"elicitation,omitempty" mapstructure:"elicitation,omitempty"`
+
 	// Experimental, non-standard capabilities that the client supports.
 	Experimental ClientCapabilitiesExperimental `json:"experimental,omitempty" yaml:"experimental,omitempty" mapstructure:"experimental,omitempty"`
 
@@ -317,6 +320,9 @@ type ClientCapabilities struct {
 	Sampling ClientCapabilitiesSampling `json:"sampling,omitempty" yaml:"sampling,omitempty" mapstructure:"sampling,omitempty"`
 }
 
+// Present if the client supports elicitation from the server.
+type ClientCapabilitiesElicitation map[string]interface{}
+
 // Experimental, non-standard capabilities that the client supports.
 type ClientCapabilitiesExperimental map[string]map[string]interface{}
 
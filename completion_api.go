@@ -0,0 +1,106 @@
+package mcp_golang
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// The maximum number of values that can be returned in a single completion result
+const maxCompletionValues = 100
+
+const (
+	completionRefTypePrompt   = "ref/prompt"
+	completionRefTypeResource = "ref/resource"
+)
+
+// A Completer returns the candidate values for a single prompt argument or resource template variable.
+// value is what the user has typed so far. The server filters the returned candidates down to those starting with value,
+// so a completer may return its full list of options without doing any matching itself.
+// ctx belongs to the completion request, get the client's session with SessionFromContext and the values of the other
+// arguments with CompletionArgumentsFromContext.
+type Completer func(ctx context.Context, value string) ([]string, error)
+
+type completionArgumentsContextKey struct{}
+
+// CompletionArgumentsFromContext returns the values of the other arguments of the prompt or resource template being
+// completed, as sent by clients on protocol version 2025-06-18 or later. It returns nil if there are none.
+func CompletionArgumentsFromContext(ctx context.Context) map[string]string {
+	arguments, _ := ctx.Value(completionArgumentsContextKey{}).(map[string]string)
+	return arguments
+}
+
+type completionKey struct {
+	refType  string
+	ref      string
+	argument string
+}
+
+// RegisterPromptArgumentCompleter registers a completer for an argument of a prompt.
+// This takes precedence over completions derived from `enum=` values in the prompt's jsonschema tags.
+func (s *Server) RegisterPromptArgumentCompleter(promptName string, argumentName string, completer Completer) error {
+	if completer == nil {
+		return fmt.Errorf("completer must not be nil")
+	}
+	s.completers.Store(completionKey{refType: completionRefTypePrompt, ref: promptName, argument: argumentName}, completer)
+	return nil
+}
+
+// RegisterResourceTemplateCompleter registers a completer for a variable of a resource template, e.g. "name" in "file:///logs/{name}".
+func (s *Server) RegisterResourceTemplateCompleter(uriTemplate string, variable string, completer Completer) error {
+	if completer == nil {
+		return fmt.Errorf("completer must not be nil")
+	}
+	s.completers.Store(completionKey{refType: completionRefTypeResource, ref: uriTemplate, argument: variable}, completer)
+	return nil
+}
+
+// DeregisterPromptArgumentCompleter removes the completer of a prompt argument.
+// Completions derived from `enum=` values in the prompt's jsonschema tags are offered again afterwards.
+func (s *Server) DeregisterPromptArgumentCompleter(promptName string, argumentName string) {
+	s.completers.Delete(completionKey{refType: completionRefTypePrompt, ref: promptName, argument: argumentName})
+}
+
+// DeregisterResourceTemplateCompleter removes the completer of a resource template variable
+func (s *Server) DeregisterResourceTemplateCompleter(uriTemplate string, variable string) {
+	s.completers.Delete(completionKey{refType: completionRefTypeResource, ref: uriTemplate, argument: variable})
+}
+
+// Filters the candidates by prefix and caps the result at the protocol maximum, reporting the total number of matches
+func newCompleteResult(candidates []string, value string) CompleteResult {
+	values := make([]string, 0)
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, value) {
+			values = append(values, candidate)
+		}
+	}
+	total := len(values)
+	hasMore := total > maxCompletionValues
+	if hasMore {
+		values = values[:maxCompletionValues]
+	}
+	return CompleteResult{
+		Completion: CompleteResultCompletion{
+			HasMore: &hasMore,
+			Total:   &total,
+			Values:  values,
+		},
+	}
+}
+
+type baseCompleteRequestParams struct {
+	// The argument's information
+	Argument CompleteRequestParamsArgument `json:"argument" yaml:"argument" mapstructure:"argument"`
+
+	// Ref is either a PromptReference or a ResourceReference, distinguished by the type field
+	Ref struct {
+		Type string `json:"type" yaml:"type" mapstructure:"type"`
+		Name string `json:"name,omitempty" yaml:"name,omitempty" mapstructure:"name,omitempty"`
+		Uri  string `json:"uri,omitempty" yaml:"uri,omitempty" mapstructure:"uri,omitempty"`
+	} `json:"ref" yaml:"ref" mapstructure:"ref"`
+
+	// The values of the arguments that were already filled in
+	Context *struct {
+		Arguments map[string]string `json:"arguments,omitempty" yaml:"arguments,omitempty" mapstructure:"arguments,omitempty"`
+	} `json:"context,omitempty" yaml:"context,omitempty" mapstructure:"context,omitempty"`
+}
@@ -2,8 +2,10 @@ package mcp_golang
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/tidwall/sjson"
+	"slices"
 )
 
 type Role string
@@ -42,6 +44,32 @@ type ImageContent struct {
 	MimeType string `json:"mimeType" yaml:"mimeType" mapstructure:"mimeType"`
 }
 
+// Audio provided to or from an LLM.
+type AudioContent struct {
+	// The base64-encoded audio data.
+	Data string `json:"data" yaml:"data" mapstructure:"data"`
+
+	// The MIME type of the audio. Different providers may support different audio
+	// types.
+	MimeType string `json:"mimeType" yaml:"mimeType" mapstructure:"mimeType"`
+}
+
+// A reference to a resource that the server is capable of reading, included in a tool call result.
+// Unlike an embedded resource the contents are not inlined, the client reads the resource separately if it needs it.
+type ResourceLinkContent struct {
+	// A description of what this resource represents.
+	Description *string `json:"description,omitempty" yaml:"description,omitempty" mapstructure:"description,omitempty"`
+
+	// The MIME type of this resource, if known.
+	MimeType *string `json:"mimeType,omitempty" yaml:"mimeType,omitempty" mapstructure:"mimeType,omitempty"`
+
+	// A human-readable name for this resource.
+	Name string `json:"name" yaml:"name" mapstructure:"name"`
+
+	// The URI of this resource.
+	Uri string `json:"uri" yaml:"uri" mapstructure:"uri"`
+}
+
 type embeddedResourceType string
 
 const (
@@ -122,14 +150,21 @@ const (
 	ContentTypeText             ContentType = "text"
 	ContentTypeImage            ContentType = "image"
 	ContentTypeEmbeddedResource ContentType = "resource"
+	ContentTypeAudio            ContentType = "audio"
+	ContentTypeResourceLink     ContentType = "resource_link"
 )
 
 type Content struct {
-	Type             ContentType
-	TextContent      *TextContent
-	ImageContent     *ImageContent
-	EmbeddedResource *EmbeddedResource
-	Annotations      *Annotations
+	Type                ContentType
+	TextContent         *TextContent
+	ImageContent        *ImageContent
+	AudioContent        *AudioContent
+	EmbeddedResource    *EmbeddedResource
+	ResourceLinkContent *ResourceLinkContent
+	Annotations         *Annotations
+	// The JSON of content whose type isn't known to this package, e.g. a type added in a newer protocol version.
+	// It is kept as it was received, and sent again as it is.
+	Raw json.RawMessage
 }
 
 // Custom JSON marshaling for ToolResponse Content
@@ -149,13 +184,32 @@ func (c Content) MarshalJSON() ([]byte, error) {
 			return nil, err
 		}
 		rawJson = j
+	case ContentTypeAudio:
+		j, err := json.Marshal(c.AudioContent)
+		if err != nil {
+			return nil, err
+		}
+		rawJson = j
 	case ContentTypeEmbeddedResource:
-		j, err := json.Marshal(c.EmbeddedResource)
+		j, err := json.Marshal(struct {
+			Resource *EmbeddedResource `json:"resource"`
+		}{
+			Resource: c.EmbeddedResource,
+		})
+		if err != nil {
+			return nil, err
+		}
+		rawJson = j
+	case ContentTypeResourceLink:
+		j, err := json.Marshal(c.ResourceLinkContent)
 		if err != nil {
 			return nil, err
 		}
 		rawJson = j
 	default:
+		if c.Raw != nil {
+			return c.Raw, nil
+		}
 		return nil, fmt.Errorf("unknown content type: %s", c.Type)
 	}
 
@@ -170,7 +224,7 @@ func (c Content) MarshalJSON() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		rawJson, err = sjson.SetBytes(rawJson, "annotations", marshal)
+		rawJson, err = sjson.SetRawBytes(rawJson, "annotations", marshal)
 		if err != nil {
 			return nil, err
 		}
@@ -178,6 +232,119 @@ func (c Content) MarshalJSON() ([]byte, error) {
 	return rawJson, nil
 }
 
+// Custom JSON unmarshaling for ToolResponse Content
+// The type field decides which of the content fields is populated
+func (c *Content) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Type        ContentType     `json:"type"`
+		Resource    json.RawMessage `json:"resource"`
+		Annotations *Annotations    `json:"annotations"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	content := Content{
+		Type:        raw.Type,
+		Annotations: raw.Annotations,
+	}
+	switch raw.Type {
+	case ContentTypeText:
+		content.TextContent = &TextContent{}
+		if err := json.Unmarshal(b, content.TextContent); err != nil {
+			return err
+		}
+	case ContentTypeImage:
+		content.ImageContent = &ImageContent{}
+		if err := json.Unmarshal(b, content.ImageContent); err != nil {
+			return err
+		}
+	case ContentTypeAudio:
+		content.AudioContent = &AudioContent{}
+		if err := json.Unmarshal(b, content.AudioContent); err != nil {
+			return err
+		}
+	case ContentTypeEmbeddedResource:
+		if raw.Resource == nil {
+			return fmt.Errorf("field resource in Content: required")
+		}
+		resource, err := unmarshalEmbeddedResource(raw.Resource)
+		if err != nil {
+			return err
+		}
+		content.EmbeddedResource = resource
+	case ContentTypeResourceLink:
+		content.ResourceLinkContent = &ResourceLinkContent{}
+		if err := json.Unmarshal(b, content.ResourceLinkContent); err != nil {
+			return err
+		}
+	default:
+		// Clients shouldn't fail to read a whole result because a newer server sent a type they don't know
+		content.Raw = slices.Clone(b)
+	}
+	*c = content
+	return nil
+}
+
+// Embedded resources are either text or blobs, which one is decided by which of the two fields is present
+func unmarshalEmbeddedResource(b []byte) (*EmbeddedResource, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	if _, ok := raw["blob"]; ok {
+		blob := &BlobResourceContents{}
+		if err := json.Unmarshal(b, blob); err != nil {
+			return nil, err
+		}
+		return &EmbeddedResource{EmbeddedResourceType: embeddedResourceTypeBlob, BlobResourceContents: blob}, nil
+	}
+	text := &TextResourceContents{}
+	if err := json.Unmarshal(b, text); err != nil {
+		return nil, err
+	}
+	return &EmbeddedResource{EmbeddedResourceType: embeddedResourceTypeText, TextResourceContents: text}, nil
+}
+
+// contentForProtocolVersion replaces any content the client's protocol version doesn't know about with a text description of it.
+// Audio was added in 2025-03-26 and resource links in 2025-06-18.
+func contentForProtocolVersion(content []*Content, version string) []*Content {
+	converted := make([]*Content, 0, len(content))
+	for _, c := range content {
+		converted = append(converted, singleContentForProtocolVersion(c, version))
+	}
+	return converted
+}
+
+func singleContentForProtocolVersion(c *Content, version string) *Content {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Type == ContentTypeAudio && !protocolVersionAtLeast(version, protocolVersion20250326):
+		audio := "audio"
+		if c.AudioContent != nil && c.AudioContent.MimeType != "" {
+			audio = c.AudioContent.MimeType + " audio"
+		}
+		return &Content{
+			Type:        ContentTypeText,
+			TextContent: &TextContent{Text: fmt.Sprintf("[%s omitted, audio content is not supported by this client]", audio)},
+			Annotations: c.Annotations,
+		}
+	case c.Type == ContentTypeResourceLink && !protocolVersionAtLeast(version, protocolVersion20250618):
+		text := "[resource link omitted, resource links are not supported by this client]"
+		if c.ResourceLinkContent != nil {
+			text = fmt.Sprintf("Resource %s: %s", c.ResourceLinkContent.Name, c.ResourceLinkContent.Uri)
+		}
+		return &Content{
+			Type:        ContentTypeText,
+			TextContent: &TextContent{Text: text},
+			Annotations: c.Annotations,
+		}
+	}
+	return c
+}
+
 func (c *Content) WithAnnotations(annotations Annotations) *Content {
 	c.Annotations = &annotations
 	return c
@@ -185,7 +352,12 @@ func (c *Content) WithAnnotations(annotations Annotations) *Content {
 
 // newToolResponseSentError creates a new ToolResponse that represents an error.
 // This is used to create a result that will be returned to the client as an error for a tool call.
+// A ToolError keeps its content and details rather than being sent as plain text.
 func newToolResponseSentError(err error) *toolResponseSent {
+	var toolError *ToolError
+	if errors.As(err, &toolError) {
+		return toolError.toolResponseSent()
+	}
 	return &toolResponseSent{
 		Error: err,
 	}
@@ -198,6 +370,19 @@ func newToolResponseSent(response *ToolResponse) *toolResponseSent {
 	}
 }
 
+// newStructuredToolResponseSent creates a new toolResponseSent from the typed output of a handler
+// The output is sent as structured content, with its JSON serialization as a text block for clients that don't support structured content
+func newStructuredToolResponseSent(output interface{}) *toolResponseSent {
+	text, err := json.Marshal(output)
+	if err != nil {
+		return newToolResponseSentError(fmt.Errorf("failed to marshal tool output: %w", err))
+	}
+	return &toolResponseSent{
+		Response:          NewToolResponse(NewTextContent(string(text))),
+		StructuredContent: output,
+	}
+}
+
 // NewImageContent creates a new ToolResponse that is an image.
 // The given data is base64-encoded
 func NewImageContent(base64EncodedStringData string, mimeType string) *Content {
@@ -207,6 +392,31 @@ func NewImageContent(base64EncodedStringData string, mimeType string) *Content {
 	}
 }
 
+// NewAudioContent creates a new ToolResponse that is an audio clip.
+// The given data is base64-encoded
+func NewAudioContent(base64EncodedStringData string, mimeType string) *Content {
+	return &Content{
+		Type:         ContentTypeAudio,
+		AudioContent: &AudioContent{Data: base64EncodedStringData, MimeType: mimeType},
+	}
+}
+
+// NewResourceLinkContent creates a new ToolResponse that points at a resource by URI without inlining its contents.
+// description and mimeType are optional and are omitted when empty.
+func NewResourceLinkContent(uri string, name string, description string, mimeType string) *Content {
+	link := &ResourceLinkContent{Name: name, Uri: uri}
+	if description != "" {
+		link.Description = &description
+	}
+	if mimeType != "" {
+		link.MimeType = &mimeType
+	}
+	return &Content{
+		Type:                ContentTypeResourceLink,
+		ResourceLinkContent: link,
+	}
+}
+
 // NewTextContent creates a new ToolResponse that is a simple text string.
 // The client will render this as a single string.
 func NewTextContent(content string) *Content {
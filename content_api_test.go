@@ -0,0 +1,123 @@
+package mcp_golang
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEmbeddedResourceAndAnnotationsJSON(t *testing.T) {
+	// The contents of embedded resources are nested under "resource", and annotations are an object rather than a string
+	cases := map[*Content]string{
+		NewTextResourceContent("file:///notes.txt", "notes", "text/plain"):                   `{"resource":{"mimeType":"text/plain","text":"notes","uri":"file:///notes.txt"},"type":"resource"}`,
+		NewBlobResourceContent("file:///data.bin", "YmxvYg==", "application/octet-stream"):   `{"resource":{"blob":"YmxvYg==","mimeType":"application/octet-stream","uri":"file:///data.bin"},"type":"resource"}`,
+		NewTextContent("important").WithAnnotations(Annotations{Audience: []Role{RoleUser}}): `{"text":"important","type":"text","annotations":{"audience":["user"]}}`,
+	}
+	for content, expected := range cases {
+		b, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %s, got %s", expected, string(b))
+		}
+	}
+}
+
+func TestContentJSONRoundTrip(t *testing.T) {
+	contents := []*Content{
+		NewTextContent("hello"),
+		NewImageContent("aW1hZ2U=", "image/png"),
+		NewAudioContent("YXVkaW8=", "audio/wav"),
+		NewTextResourceContent("file:///notes.txt", "some notes", "text/plain"),
+		NewBlobResourceContent("file:///data.bin", "YmxvYg==", "application/octet-stream"),
+		NewResourceLinkContent("file:///big.log", "big.log", "A large log file", "text/plain"),
+		NewResourceLinkContent("file:///other.log", "other.log", "", ""),
+		NewTextContent("important").WithAnnotations(Annotations{Audience: []Role{RoleUser}}),
+	}
+
+	for _, content := range contents {
+		b, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Content
+		err = json.Unmarshal(b, &decoded)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s: %v", string(b), err)
+		}
+		if !reflect.DeepEqual(content, &decoded) {
+			t.Errorf("Round trip of %s changed the content: %+v", string(b), decoded)
+		}
+	}
+}
+
+func TestContentJSONFormat(t *testing.T) {
+	cases := map[*Content]string{
+		NewAudioContent("YXVkaW8=", "audio/wav"):                               `{"data":"YXVkaW8=","mimeType":"audio/wav","type":"audio"}`,
+		NewResourceLinkContent("file:///big.log", "big.log", "", "text/plain"): `{"mimeType":"text/plain","name":"big.log","uri":"file:///big.log","type":"resource_link"}`,
+	}
+	for content, expected := range cases {
+		b, err := json.Marshal(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("Expected %s, got %s", expected, string(b))
+		}
+	}
+
+	// Content of types added after this package was written is kept as it is
+	unknown := `{"type":"video","data":"abc","annotations":{"priority":1}}`
+	var decoded Content
+	if err := json.Unmarshal([]byte(unknown), &decoded); err != nil {
+		t.Fatalf("Expected unknown content types to be kept, got %v", err)
+	}
+	if decoded.Type != "video" || decoded.Annotations == nil {
+		t.Errorf("Unexpected content: %+v", decoded)
+	}
+	if b, err := json.Marshal(decoded); err != nil || string(b) != unknown {
+		t.Errorf("Expected %s to be sent as it was received, got %s %v", unknown, string(b), err)
+	}
+	var result CallToolResult
+	if err := json.Unmarshal([]byte(`{"content":[{"type":"text","text":"hi"},`+unknown+`]}`), &result); err != nil || len(result.Content) != 2 {
+		t.Errorf("Expected a result with unknown content to be read, got %+v %v", result, err)
+	}
+	if _, err := json.Marshal(&Content{Type: "video"}); err == nil {
+		t.Error("Expected error marshaling unknown content without its JSON")
+	}
+}
+
+func TestContentForProtocolVersion(t *testing.T) {
+	contents := []*Content{
+		NewTextContent("hello"),
+		NewAudioContent("YXVkaW8=", "audio/wav"),
+		NewResourceLinkContent("file:///big.log", "big.log", "", ""),
+	}
+
+	converted := contentForProtocolVersion(contents, protocolVersion20241105)
+	for _, c := range converted {
+		if c.Type != ContentTypeText {
+			t.Errorf("Expected only text content for 2024-11-05, got %s", c.Type)
+		}
+	}
+	if converted[2].TextContent.Text != "Resource big.log: file:///big.log" {
+		t.Errorf("Unexpected resource link fallback: %s", converted[2].TextContent.Text)
+	}
+
+	converted = contentForProtocolVersion(contents, protocolVersion20250326)
+	if converted[1].Type != ContentTypeAudio || converted[2].Type != ContentTypeText {
+		t.Errorf("Expected audio but no resource link for 2025-03-26, got %s %s", converted[1].Type, converted[2].Type)
+	}
+
+	// Content built by hand without its details doesn't stop the response from being sent
+	converted = contentForProtocolVersion([]*Content{{Type: ContentTypeAudio}, {Type: ContentTypeResourceLink}}, protocolVersion20241105)
+	if converted[0].Type != ContentTypeText || converted[1].Type != ContentTypeText {
+		t.Errorf("Expected text in place of incomplete content, got %s %s", converted[0].Type, converted[1].Type)
+	}
+
+	converted = contentForProtocolVersion(contents, protocolVersion20250618)
+	if !reflect.DeepEqual(converted, contents) {
+		t.Error("Expected content to be unchanged for 2025-06-18")
+	}
+}
@@ -0,0 +1,156 @@
+package mcp_golang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/invopop/jsonschema"
+	"github.com/metoro-io/mcp-golang/internal/protocol"
+	"reflect"
+	"time"
+)
+
+// How long to wait for the user to answer an elicitation if the context given has no deadline.
+// A human is on the other end so this is much longer than the default request timeout.
+const defaultElicitationTimeout = 10 * time.Minute
+
+type ElicitAction string
+
+const (
+	// The user submitted the form
+	ElicitActionAccept ElicitAction = "accept"
+	// The user explicitly declined to provide the information
+	ElicitActionDecline ElicitAction = "decline"
+	// The user dismissed the request without making a choice
+	ElicitActionCancel ElicitAction = "cancel"
+)
+
+// A request from the server to elicit additional information from the user via the client.
+type ElicitRequestParams struct {
+	// The message to present to the user.
+	Message string `json:"message" yaml:"message" mapstructure:"message"`
+
+	// A restricted subset of JSON Schema describing the information being requested.
+	// Only top-level properties are allowed, without nesting.
+	RequestedSchema json.RawMessage `json:"requestedSchema" yaml:"requestedSchema" mapstructure:"requestedSchema"`
+}
+
+// The client's response to an elicitation request.
+type ElicitResult struct {
+	// The user action in response to the elicitation.
+	Action ElicitAction `json:"action" yaml:"action" mapstructure:"action"`
+
+	// The submitted form data, only present when action is "accept".
+	// Contains values matching the requested schema.
+	Content map[string]interface{} `json:"content,omitempty" yaml:"content,omitempty" mapstructure:"content,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (j *ElicitResult) UnmarshalJSON(b []byte) error {
+	type Plain ElicitResult
+	var plain Plain
+	if err := json.Unmarshal(b, &plain); err != nil {
+		return err
+	}
+	switch plain.Action {
+	case ElicitActionAccept, ElicitActionDecline, ElicitActionCancel:
+	default:
+		return fmt.Errorf("invalid value for field action in ElicitResult: %#v", plain.Action)
+	}
+	*j = ElicitResult(plain)
+	return nil
+}
+
+// ElicitationHandler is installed on a Client to answer elicitation requests from the server, usually by showing a form to the user.
+type ElicitationHandler func(ctx context.Context, request ElicitRequestParams) (*ElicitResult, error)
+
+// Elicit asks the user, through the client, to fill in the fields of the struct pointed to by out.
+// The requested schema is generated from the struct in the same way as tool arguments, so the jsonschema tags
+// can be used to mark fields as required and to describe them. Only flat structs of strings, numbers, integers and
+// booleans are allowed by the protocol.
+// If the user accepts, their answers are decoded into out. out is left untouched if they decline or cancel.
+// Elicit is meant to be called from inside a tool, prompt or resource handler with the context the handler was given,
+// the request is sent to the client that called the handler.
+func (s *Server) Elicit(ctx context.Context, message string, out any) (ElicitAction, error) {
+	session := s.sessionFor(ctx)
+	if session.Stateless() {
+		return "", fmt.Errorf("elicitation is not supported by stateless servers")
+	}
+	if !session.clientSupportsElicitation() {
+		return "", fmt.Errorf("client does not support elicitation")
+	}
+	outValue := reflect.ValueOf(out)
+	if outValue.Kind() != reflect.Ptr || outValue.IsNil() || outValue.Elem().Kind() != reflect.Struct {
+		return "", fmt.Errorf("out must be a non-nil pointer to a struct")
+	}
+	requestedSchema := s.reflectSchema(outValue.Elem().Type())
+	err := validateElicitationSchema(requestedSchema)
+	if err != nil {
+		return "", err
+	}
+	// The requested schema is a restricted subset of json schema, the $schema and additionalProperties keywords aren't part of it
+	requestedSchema.Version = ""
+	requestedSchema.AdditionalProperties = nil
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := defaultElicitationTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	response, err := session.protocol.Request(ctx, "elicitation/create", map[string]interface{}{
+		"message":         message,
+		"requestedSchema": requestedSchema,
+	}, &protocol.RequestOptions{Timeout: timeout})
+	if err != nil {
+		return "", fmt.Errorf("failed to elicit information: %w", err)
+	}
+
+	var result ElicitResult
+	err = unmarshalResponse(response, &result)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal elicitation result: %w", err)
+	}
+	if result.Action != ElicitActionAccept {
+		return result.Action, nil
+	}
+
+	content, err := json.Marshal(result.Content)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal elicitation content: %w", err)
+	}
+	err = json.Unmarshal(content, out)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal elicitation content: %w", err)
+	}
+	return result.Action, nil
+}
+
+// The protocol only allows elicitation of flat objects whose properties are primitive types
+func validateElicitationSchema(schema *jsonschema.Schema) error {
+	if schema.Properties == nil {
+		return nil
+	}
+	for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+		switch pair.Value.Type {
+		case "string", "number", "integer", "boolean":
+		default:
+			return fmt.Errorf("elicitation field %s must be a string, number, integer or boolean, got %q", pair.Key, pair.Value.Type)
+		}
+	}
+	return nil
+}
+
+func (s *Session) clientSupportsElicitation() bool {
+	return s.ClientCapabilities().Elicitation != nil
+}
+
+// Responses from the protocol come back as raw JSON
+func unmarshalResponse(response interface{}, v interface{}) error {
+	raw, ok := response.(json.RawMessage)
+	if !ok {
+		return fmt.Errorf("unexpected response type %T", response)
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/metoro-io/mcp-golang"
 	"github.com/metoro-io/mcp-golang/transport/stdio"
@@ -16,8 +17,6 @@ type MyFunctionsArguments struct {
 }
 
 func main() {
-	done := make(chan struct{})
-
 	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
 	err := server.RegisterTool("hello", "Say hello to a person", func(arguments MyFunctionsArguments) (*mcp_golang.ToolResponse, error) {
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Hello, %server!", arguments.Submitter))), nil
@@ -41,10 +40,9 @@ func main() {
 		return mcp_golang.NewResourceResponse(mcp_golang.NewTextEmbeddedResource("file://app_logs", "This is a test resource", "text/plain")), nil
 	})
 
-	err = server.Serve()
+	// Blocks until the client disconnects
+	err = server.ServeContext(context.Background())
 	if err != nil {
 		panic(err)
 	}
-
-	<-done
 }
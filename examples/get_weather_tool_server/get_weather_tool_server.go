@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	mcp_golang "github.com/metoro-io/mcp-golang"
 	"github.com/metoro-io/mcp-golang/transport/stdio"
@@ -15,7 +16,6 @@ type WeatherArguments struct {
 
 // This is explained in the docs at https://mcpgolang.com/tools
 func main() {
-	done := make(chan struct{})
 	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
 	err := server.RegisterTool("get_weather", "Get the weather forecast for temperature, wind speed and relative humidity", func(arguments WeatherArguments) (*mcp_golang.ToolResponse, error) {
 		url := fmt.Sprintf("https://api.open-meteo.com/v1/forecast?latitude=%f&longitude=%f&current=temperature_2m,wind_speed_10m&hourly=temperature_2m,relative_humidity_2m,wind_speed_10m", arguments.Latitude, arguments.Longitude)
@@ -30,9 +30,9 @@ func main() {
 		}
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(string(output))), nil
 	})
-	err = server.Serve()
+	// Blocks until the client disconnects
+	err = server.ServeContext(context.Background())
 	if err != nil {
 		panic(err)
 	}
-	<-done
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	mcp_golang "github.com/metoro-io/mcp-golang"
 	"github.com/metoro-io/mcp-golang/transport/stdio"
@@ -12,14 +13,13 @@ type HelloArguments struct {
 
 // This is explained in the docs at https://mcpgolang.com/tools
 func main() {
-	done := make(chan struct{})
 	server := mcp_golang.NewServer(stdio.NewStdioServerTransport())
 	err := server.RegisterTool("hello", "Say hello to a person", func(arguments HelloArguments) (*mcp_golang.ToolResponse, error) {
 		return mcp_golang.NewToolResponse(mcp_golang.NewTextContent(fmt.Sprintf("Hello, %s!", arguments.Submitter))), nil
 	})
-	err = server.Serve()
+	// Blocks until the client disconnects
+	err = server.ServeContext(context.Background())
 	if err != nil {
 		panic(err)
 	}
-	<-done
 }
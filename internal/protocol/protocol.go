@@ -68,8 +68,10 @@ package protocol
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/metoro-io/mcp-golang/transport"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -78,8 +80,9 @@ const DefaultRequestTimeoutMsec = 60000
 
 // Progress represents a progress update
 type Progress struct {
-	Progress int64 `json:"progress"`
-	Total    int64 `json:"total"`
+	Progress float64 `json:"progress"`
+	Total    float64 `json:"total"`
+	Message  string  `json:"message,omitempty"`
 }
 
 // ProgressCallback is a callback for progress notifications
@@ -107,6 +110,12 @@ type RequestOptions struct {
 type RequestHandlerExtra struct {
 	// Context used to communicate if the request was cancelled from the sender's side
 	Context context.Context
+	// The id of the request being handled
+	RequestId transport.RequestId
+	// Metadata the transport attached to the request, see transport.BaseJsonRpcMessage
+	Metadata map[string]string
+	// Who the transport authenticated the sender of the request as, nil if it didn't
+	Identity *transport.Identity
 }
 
 // Protocol implements MCP protocol framing on top of a pluggable transport,
@@ -128,6 +137,14 @@ type Protocol struct {
 	responseHandlers map[transport.RequestId]chan *responseEnvelope
 	// Maps message ID to progress handler
 	progressHandlers map[transport.RequestId]ProgressCallback
+	// The requests being handled
+	inFlight sync.WaitGroup
+	// Set by Drain, requests are no longer accepted
+	draining bool
+
+	// The context the contexts given to request handlers are derived from, context.Background() if nil.
+	// Values in it are visible to every handler, and cancelling it cancels every request being handled.
+	BaseContext context.Context
 
 	// Callback for when the connection is closed for any reason
 	OnClose func()
@@ -158,13 +175,16 @@ func NewProtocol(options *ProtocolOptions) *Protocol {
 	// Set up default handlers
 	p.SetNotificationHandler("notifications/cancelled", p.handleCancelledNotification)
 	p.SetNotificationHandler("$/progress", p.handleProgressNotification)
+	p.SetNotificationHandler("notifications/progress", p.handleProgressNotification)
 
 	return p
 }
 
 // Connect attaches to the given transport, starts it, and starts listening for messages
 func (p *Protocol) Connect(tr transport.Transport) error {
+	p.mu.Lock()
 	p.transport = tr
+	p.mu.Unlock()
 
 	tr.SetCloseHandler(func() {
 		p.handleClose()
@@ -177,7 +197,7 @@ func (p *Protocol) Connect(tr transport.Transport) error {
 	tr.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
 		switch m := message.Type; {
 		case m == transport.BaseMessageTypeJSONRPCRequestType:
-			p.handleRequest(message.JsonRpcRequest)
+			p.handleRequest(message.JsonRpcRequest, message.Metadata, message.Identity)
 		case m == transport.BaseMessageTypeJSONRPCNotificationType:
 			p.handleNotification(message.JsonRpcNotification)
 		case m == transport.BaseMessageTypeJSONRPCResponseType:
@@ -192,11 +212,8 @@ func (p *Protocol) Connect(tr transport.Transport) error {
 
 func (p *Protocol) handleClose() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
-	// Clear all handlers
-	p.requestHandlers = make(map[string]func(*transport.BaseJSONRPCRequest, RequestHandlerExtra) (transport.JsonRpcBody, error))
-	p.notificationHandlers = make(map[string]func(notification *transport.BaseJSONRPCNotification) error)
+	// Handlers are kept so the protocol can be connected to a new transport
 
 	// Cancel all pending requests
 	for _, cancel := range p.requestCancellers {
@@ -204,20 +221,35 @@ func (p *Protocol) handleClose() {
 	}
 	p.requestCancellers = make(map[transport.RequestId]context.CancelFunc)
 
-	// Close all response channels with error
+	// Fail every request waiting for a response, unless its response already arrived
 	for id, ch := range p.responseHandlers {
-		ch <- &responseEnvelope{err: fmt.Errorf("connection closed")}
-		close(ch)
+		select {
+		case ch <- &responseEnvelope{err: fmt.Errorf("connection closed")}:
+		default:
+		}
 		delete(p.responseHandlers, id)
 	}
 
 	p.progressHandlers = make(map[transport.RequestId]ProgressCallback)
+	onClose := p.OnClose
+	p.mu.Unlock()
 
-	if p.OnClose != nil {
-		p.OnClose()
+	if onClose != nil {
+		onClose()
 	}
 }
 
+// PanicError is reported to OnError when a request handler panics
+type PanicError struct {
+	Method string
+	Value  interface{}
+	Stack  []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic handling %s: %v\n%s", e.Method, e.Value, e.Stack)
+}
+
 func (p *Protocol) handleError(err error) {
 	if p.OnError != nil {
 		p.OnError(err)
@@ -243,7 +275,7 @@ func (p *Protocol) handleNotification(notification *transport.BaseJSONRPCNotific
 	}()
 }
 
-func (p *Protocol) handleRequest(request *transport.BaseJSONRPCRequest) {
+func (p *Protocol) handleRequest(request *transport.BaseJSONRPCRequest, metadata map[string]string, identity *transport.Identity) {
 	p.mu.RLock()
 	handler := p.requestHandlers[request.Method]
 	if handler == nil {
@@ -257,20 +289,39 @@ func (p *Protocol) handleRequest(request *transport.BaseJSONRPCRequest) {
 	}
 	p.mu.RUnlock()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	baseContext := p.BaseContext
+	if baseContext == nil {
+		baseContext = context.Background()
+	}
+	ctx, cancel := context.WithCancel(baseContext)
 	p.mu.Lock()
+	if p.draining {
+		p.mu.Unlock()
+		cancel()
+		p.sendErrorResponse(request.Id, fmt.Errorf("shutting down, not accepting requests"))
+		return
+	}
 	p.requestCancellers[request.Id] = cancel
+	p.inFlight.Add(1)
 	p.mu.Unlock()
 
 	go func() {
+		defer p.inFlight.Done()
 		defer func() {
 			p.mu.Lock()
 			delete(p.requestCancellers, request.Id)
 			p.mu.Unlock()
 			cancel()
 		}()
+		// A panicking handler must not take the whole process down with it, the client gets an error instead
+		defer func() {
+			if r := recover(); r != nil {
+				p.handleError(&PanicError{Method: request.Method, Value: r, Stack: debug.Stack()})
+				p.sendErrorResponse(request.Id, fmt.Errorf("internal error handling %s", request.Method))
+			}
+		}()
 
-		result, err := handler(request, RequestHandlerExtra{Context: ctx})
+		result, err := handler(request, RequestHandlerExtra{Context: ctx, RequestId: request.Id, Metadata: metadata, Identity: identity})
 		if err != nil {
 			println("error:", err.Error())
 			p.sendErrorResponse(request.Id, err)
@@ -289,7 +340,7 @@ func (p *Protocol) handleRequest(request *transport.BaseJSONRPCRequest) {
 			Result:  jsonResult,
 		}
 
-		if err := p.transport.Send(transport.NewBaseMessageResponse(response)); err != nil {
+		if err := p.send(transport.NewBaseMessageResponse(response)); err != nil {
 			println("error:", err.Error())
 			p.handleError(fmt.Errorf("failed to send response: %w", err))
 		}
@@ -298,8 +349,9 @@ func (p *Protocol) handleRequest(request *transport.BaseJSONRPCRequest) {
 
 func (p *Protocol) handleProgressNotification(notification *transport.BaseJSONRPCNotification) error {
 	var params struct {
-		Progress      int64               `json:"progress"`
-		Total         int64               `json:"total"`
+		Progress      float64             `json:"progress"`
+		Total         float64             `json:"total"`
+		Message       string              `json:"message"`
 		ProgressToken transport.RequestId `json:"progressToken"`
 	}
 
@@ -315,6 +367,7 @@ func (p *Protocol) handleProgressNotification(notification *transport.BaseJSONRP
 		handler(Progress{
 			Progress: params.Progress,
 			Total:    params.Total,
+			Message:  params.Message,
 		})
 	}
 
@@ -343,7 +396,7 @@ func (p *Protocol) handleCancelledNotification(notification *transport.BaseJSONR
 }
 
 func (p *Protocol) handleResponse(response *transport.BaseJSONRPCResponse, errResp *transport.BaseJSONRPCError) {
-	var id = response.Id
+	var id transport.RequestId
 	var result interface{}
 	var err error
 
@@ -352,6 +405,7 @@ func (p *Protocol) handleResponse(response *transport.BaseJSONRPCResponse, errRe
 		err = fmt.Errorf("RPC error %d: %s", errResp.Error.Code, errResp.Error.Message)
 	} else {
 		// Parse the response
+		id = response.Id
 		result = response.Result
 	}
 
@@ -369,15 +423,15 @@ func (p *Protocol) handleResponse(response *transport.BaseJSONRPCResponse, errRe
 
 // Close closes the connection
 func (p *Protocol) Close() error {
-	if p.transport != nil {
-		return p.transport.Close()
+	if tr := p.currentTransport(); tr != nil {
+		return tr.Close()
 	}
 	return nil
 }
 
 // Request sends a request and waits for a response
 func (p *Protocol) Request(ctx context.Context, method string, params interface{}, opts *RequestOptions) (interface{}, error) {
-	if p.transport == nil {
+	if p.currentTransport() == nil {
 		return nil, fmt.Errorf("not connected")
 	}
 
@@ -421,6 +475,14 @@ func (p *Protocol) Request(ctx context.Context, method string, params interface{
 				"_meta": meta,
 			}
 		} else if paramsMap, ok := params.(map[string]interface{}); ok {
+			// Keep any _meta the caller set
+			if existing, ok := paramsMap["_meta"].(map[string]interface{}); ok {
+				for k, v := range existing {
+					if _, set := meta[k]; !set {
+						meta[k] = v
+					}
+				}
+			}
 			paramsMap["_meta"] = meta
 			requestParams = paramsMap
 		} else {
@@ -440,7 +502,7 @@ func (p *Protocol) Request(ctx context.Context, method string, params interface{
 		Id:      id,
 	}
 
-	if err := p.transport.Send(transport.NewBaseMessageRequest(request)); err != nil {
+	if err := p.send(transport.NewBaseMessageRequest(request)); err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
@@ -474,23 +536,39 @@ func (p *Protocol) sendCancelNotification(requestID transport.RequestId, reason
 		Params:  marshalled,
 	}
 
-	if err := p.transport.Send(transport.NewBaseMessageNotification(notification)); err != nil {
+	if err := p.send(transport.NewBaseMessageNotification(notification)); err != nil {
 		p.handleError(fmt.Errorf("failed to send cancel notification: %w", err))
 	}
 	return nil
 }
 
+// RequestError is returned by request handlers to answer with a specific JSON-RPC error code, other errors are sent
+// with code -32000
+type RequestError struct {
+	Code    int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
 func (p *Protocol) sendErrorResponse(requestID transport.RequestId, err error) error {
+	code := -32000 // Internal error
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		code = requestErr.Code
+	}
 	response := &transport.BaseJSONRPCError{
 		Jsonrpc: "2.0",
 		Id:      requestID,
 		Error: transport.BaseJSONRPCErrorInner{
-			Code:    -32000, // Internal error
+			Code:    code,
 			Message: err.Error(),
 		},
 	}
 
-	if err := p.transport.Send(transport.NewBaseMessageError(response)); err != nil {
+	if err := p.send(transport.NewBaseMessageError(response)); err != nil {
 		p.handleError(fmt.Errorf("failed to send error response: %w", err))
 	}
 	return nil
@@ -498,7 +576,7 @@ func (p *Protocol) sendErrorResponse(requestID transport.RequestId, err error) e
 
 // Notification emits a notification, which is a one-way message that does not expect a response
 func (p *Protocol) Notification(method string, params interface{}) error {
-	if p.transport == nil {
+	if p.currentTransport() == nil {
 		return fmt.Errorf("not connected")
 	}
 
@@ -513,7 +591,46 @@ func (p *Protocol) Notification(method string, params interface{}) error {
 		Params:  marshalled,
 	}
 
-	return p.transport.Send(transport.NewBaseMessageNotification(notification))
+	return p.send(transport.NewBaseMessageNotification(notification))
+}
+
+// Drain stops the protocol from accepting requests, new ones are answered with an error, and waits for the requests
+// being handled to finish. If ctx is done first the remaining requests are cancelled and ctx's error is returned.
+func (p *Protocol) Drain(ctx context.Context) error {
+	p.mu.Lock()
+	p.draining = true
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		for _, cancel := range p.requestCancellers {
+			cancel()
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (p *Protocol) currentTransport() transport.Transport {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.transport
+}
+
+func (p *Protocol) send(message *transport.BaseJsonRpcMessage) error {
+	tr := p.currentTransport()
+	if tr == nil {
+		return fmt.Errorf("not connected")
+	}
+	return tr.Send(message)
 }
 
 // SetRequestHandler registers a handler to invoke when this protocol object receives a request with the given method
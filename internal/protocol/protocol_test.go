@@ -32,10 +32,8 @@ func TestProtocol_Connect(t *testing.T) {
 // TestProtocol_Close tests the proper cleanup of resources when closing the protocol.
 // Proper cleanup is essential to prevent resource leaks and ensure graceful shutdown.
 // It verifies:
-// 1. All handlers are properly deregistered
-// 2. The transport is closed
-// 3. No messages can be sent after closing
-// 4. Multiple closes are handled safely
+// 1. The transport is closed
+// 2. The OnClose callback is called
 func TestProtocol_Close(t *testing.T) {
 	p := NewProtocol(nil)
 	transport := testingutils.NewMockTransport()
@@ -62,6 +60,36 @@ func TestProtocol_Close(t *testing.T) {
 	}
 }
 
+// TestProtocol_Reconnect verifies that handlers survive a closed transport,
+// so the same protocol can serve a new connection.
+func TestProtocol_Reconnect(t *testing.T) {
+	p := NewProtocol(nil)
+	handled := make(chan struct{}, 1)
+	p.SetRequestHandler("test_method", func(req *transport.BaseJSONRPCRequest, extra RequestHandlerExtra) (transport.JsonRpcBody, error) {
+		handled <- struct{}{}
+		return map[string]interface{}{}, nil
+	})
+
+	first := testingutils.NewMockTransport()
+	if err := p.Connect(first); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	second := testingutils.NewMockTransport()
+	if err := p.Connect(second); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	second.SimulateMessage(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 1, Method: "test_method"}))
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("Request handler was not called after reconnecting")
+	}
+}
+
 // TestProtocol_Request tests the core request-response functionality of the protocol.
 // This is the most important test as it covers the primary use case of the protocol.
 // It includes subtests for:
@@ -358,3 +386,48 @@ func TestProtocol_ErrorHandling(t *testing.T) {
 		t.Error("Error not received")
 	}
 }
+
+// TestProtocol_RequestHandlerPanic verifies that a panicking request handler doesn't crash the process.
+// The client gets an error response and the panic is reported to OnError with its stack trace.
+func TestProtocol_RequestHandlerPanic(t *testing.T) {
+	p := NewProtocol(nil)
+	tr := testingutils.NewMockTransport()
+
+	if err := p.Connect(tr); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	errorReceived := make(chan error, 1)
+	p.OnError = func(err error) {
+		errorReceived <- err
+	}
+	p.SetRequestHandler("test_method", func(req *transport.BaseJSONRPCRequest, extra RequestHandlerExtra) (transport.JsonRpcBody, error) {
+		panic("something went wrong")
+	})
+
+	tr.SimulateMessage(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{
+		Jsonrpc: "2.0",
+		Id:      7,
+		Method:  "test_method",
+		Params:  json.RawMessage(`{}`),
+	}))
+
+	select {
+	case err := <-errorReceived:
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) || panicErr.Value != "something went wrong" || len(panicErr.Stack) == 0 {
+			t.Errorf("Expected a PanicError with a stack trace, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Panic not reported")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	msgs := tr.GetMessages()
+	if len(msgs) != 1 || msgs[0].Type != transport.BaseMessageTypeJSONRPCErrorType {
+		t.Fatalf("Expected an error response, got %+v", msgs)
+	}
+	if msgs[0].JsonRpcError.Id != 7 || msgs[0].JsonRpcError.Error.Message != "internal error handling test_method" {
+		t.Errorf("Unexpected error response: %+v", msgs[0].JsonRpcError)
+	}
+}
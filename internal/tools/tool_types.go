@@ -2,14 +2,23 @@ package tools
 
 // Definition for a tool the client can call.
 type ToolRetType struct {
+	// Optional additional tool information.
+	Annotations interface{} `json:"annotations,omitempty" yaml:"annotations,omitempty" mapstructure:"annotations,omitempty"`
+
 	// A human-readable description of the tool.
 	Description *string `json:"description,omitempty" yaml:"description,omitempty" mapstructure:"description,omitempty"`
 
 	// A JSON Schema object defining the expected parameters for the tool.
 	InputSchema interface{} `json:"inputSchema" yaml:"inputSchema" mapstructure:"inputSchema"`
 
+	// An optional JSON Schema object defining the structure of the tool's output returned in the structuredContent field of a CallToolResult.
+	OutputSchema interface{} `json:"outputSchema,omitempty" yaml:"outputSchema,omitempty" mapstructure:"outputSchema,omitempty"`
+
 	// The name of the tool.
 	Name string `json:"name" yaml:"name" mapstructure:"name"`
+
+	// A human-readable title for the tool, intended for display in UIs.
+	Title *string `json:"title,omitempty" yaml:"title,omitempty" mapstructure:"title,omitempty"`
 }
 type ToolsResponse struct {
 	Tools      []ToolRetType `json:"tools" yaml:"tools" mapstructure:"tools"`
@@ -0,0 +1,209 @@
+package mcp_golang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/metoro-io/mcp-golang/internal/protocol"
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// Client is the other end of the Server: it connects to an MCP server over a transport, initializes the session and
+// then calls the server's tools.
+// Handlers for requests the server sends back to the client, such as elicitation, are installed with ClientOptions.
+type Client struct {
+	transport          transport.Transport
+	protocol           *protocol.Protocol
+	clientName         string
+	clientVersion      string
+	capabilities       ClientCapabilities
+	elicitationHandler ElicitationHandler
+	initializeResult   *InitializeResult
+}
+
+type ClientOptions func(*Client)
+
+// CallOptions adjust a single request to the server
+type CallOptions func(*callOptions)
+
+type callOptions struct {
+	meta map[string]interface{}
+}
+
+// WithRequestMeta sends meta as the _meta of the request, e.g. to pass a trace id or tenant to the server.
+// Servers can read it with RequestContextFromContext.
+func WithRequestMeta(meta map[string]interface{}) CallOptions {
+	return func(o *callOptions) {
+		o.meta = meta
+	}
+}
+
+// WithClientInfo sets the name and version the client reports to the server
+func WithClientInfo(name string, version string) ClientOptions {
+	return func(c *Client) {
+		c.clientName = name
+		c.clientVersion = version
+	}
+}
+
+// WithElicitationHandler installs a handler that answers the server's elicitation requests, usually by asking the user.
+// The client only advertises the elicitation capability if a handler is installed.
+func WithElicitationHandler(handler ElicitationHandler) ClientOptions {
+	return func(c *Client) {
+		c.elicitationHandler = handler
+	}
+}
+
+func NewClient(transport transport.Transport, options ...ClientOptions) *Client {
+	client := &Client{
+		transport:     transport,
+		protocol:      protocol.NewProtocol(nil),
+		clientName:    "mcp-golang",
+		clientVersion: "0.0.0",
+	}
+	for _, option := range options {
+		option(client)
+	}
+	if client.elicitationHandler != nil {
+		client.capabilities.Elicitation = &ClientCapabilitiesElicitation{}
+	}
+	return client
+}
+
+// Initialize connects to the transport and performs the initialization handshake with the server.
+// It must be called before any other method.
+func (c *Client) Initialize(ctx context.Context) (*InitializeResult, error) {
+	if c.initializeResult != nil {
+		return nil, fmt.Errorf("client already initialized")
+	}
+	c.protocol.SetRequestHandler("ping", c.handlePing)
+	if c.elicitationHandler != nil {
+		c.protocol.SetRequestHandler("elicitation/create", c.handleElicitation)
+	}
+	err := c.protocol.Connect(c.transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	response, err := c.protocol.Request(ctx, "initialize", InitializeRequestParams{
+		Capabilities:    c.capabilities,
+		ClientInfo:      Implementation{Name: c.clientName, Version: c.clientVersion},
+		ProtocolVersion: latestProtocolVersion,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize: %w", err)
+	}
+	var result InitializeResult
+	err = unmarshalResponse(response, &result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal initialize result: %w", err)
+	}
+
+	err = c.protocol.Notification("notifications/initialized", map[string]interface{}{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+	c.initializeResult = &result
+	return &result, nil
+}
+
+// ListTools lists the tools the server offers. Pass the NextCursor of the previous result to get the next page.
+func (c *Client) ListTools(ctx context.Context, cursor *string, options ...CallOptions) (*ListToolsResult, error) {
+	var result ListToolsResult
+	err := c.request(ctx, "tools/list", ListToolsRequestParams{Cursor: cursor}, &result, options)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CallTool calls the named tool. arguments can be anything that serializes to a JSON object, usually a struct or a map.
+// Errors from the tool itself are reported in the result with IsError set rather than as an error.
+// The _meta the server sent with the result is in its Meta.
+func (c *Client) CallTool(ctx context.Context, name string, arguments any, options ...CallOptions) (*CallToolResult, error) {
+	var result CallToolResult
+	err := c.request(ctx, "tools/call", map[string]interface{}{
+		"name":      name,
+		"arguments": arguments,
+	}, &result, options)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Ping checks that the server is still responding
+func (c *Client) Ping(ctx context.Context, options ...CallOptions) error {
+	return c.request(ctx, "ping", map[string]interface{}{}, nil, options)
+}
+
+// Close closes the underlying transport
+func (c *Client) Close() error {
+	return c.protocol.Close()
+}
+
+func (c *Client) request(ctx context.Context, method string, params interface{}, result interface{}, options []CallOptions) error {
+	if c.initializeResult == nil {
+		return fmt.Errorf("client not initialized")
+	}
+	var opts callOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.meta != nil {
+		var err error
+		params, err = withRequestMeta(params, opts.meta)
+		if err != nil {
+			return fmt.Errorf("failed to add _meta to %s: %w", method, err)
+		}
+	}
+	response, err := c.protocol.Request(ctx, method, params, nil)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	if result == nil {
+		return nil
+	}
+	err = unmarshalResponse(response, result)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal %s result: %w", method, err)
+	}
+	return nil
+}
+
+// Adds _meta to request params, which can be any type that serializes to a JSON object
+func withRequestMeta(params interface{}, meta map[string]interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	paramsMap := map[string]interface{}{}
+	if string(b) != "null" {
+		err = json.Unmarshal(b, &paramsMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	paramsMap["_meta"] = meta
+	return paramsMap, nil
+}
+
+func (c *Client) handlePing(_ *transport.BaseJSONRPCRequest, _ protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (c *Client) handleElicitation(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	var params ElicitRequestParams
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+	result, err := c.elicitationHandler(extra.Context, params)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("elicitation handler returned no result")
+	}
+	return result, nil
+}
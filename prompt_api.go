@@ -19,6 +19,10 @@ type PromptResponse struct {
 
 	// Messages corresponds to the JSON schema field "messages".
 	Messages []*PromptMessage `json:"messages" yaml:"messages" mapstructure:"messages"`
+
+	// This result property is reserved by the protocol to allow clients and servers
+	// to attach additional metadata to their responses.
+	Meta GetPromptResultMeta `json:"_meta,omitempty" yaml:"_meta,omitempty" mapstructure:"_meta,omitempty"`
 }
 
 func NewPromptResponse(description string, messages ...*PromptMessage) *PromptResponse {
@@ -27,3 +31,9 @@ func NewPromptResponse(description string, messages ...*PromptMessage) *PromptRe
 		Messages:    messages,
 	}
 }
+
+// WithMeta sets the _meta of the result
+func (r *PromptResponse) WithMeta(meta map[string]interface{}) *PromptResponse {
+	r.Meta = meta
+	return r
+}
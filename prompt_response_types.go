@@ -39,4 +39,7 @@ type promptSchemaArgument struct {
 
 	// Whether this argument must be provided.
 	Required *bool `json:"required,omitempty" yaml:"required,omitempty" mapstructure:"required,omitempty"`
+
+	// The allowed values of the argument, taken from the jsonschema enum tags. Used for completions.
+	enum []string
 }
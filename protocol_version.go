@@ -0,0 +1,34 @@
+package mcp_golang
+
+// The MCP protocol versions this library can speak, oldest first.
+// Protocol versions are dates so they can be compared as strings.
+const (
+	protocolVersion20241105 = "2024-11-05"
+	protocolVersion20250326 = "2025-03-26"
+	protocolVersion20250618 = "2025-06-18"
+)
+
+var supportedProtocolVersions = []string{
+	protocolVersion20241105,
+	protocolVersion20250326,
+	protocolVersion20250618,
+}
+
+const latestProtocolVersion = protocolVersion20250618
+
+// negotiateProtocolVersion picks the version to respond to an initialize request with.
+// If we support the version the client asked for we use it, otherwise we respond with the latest version we support
+// and leave it to the client to disconnect if it can't handle it.
+func negotiateProtocolVersion(requested string) string {
+	for _, v := range supportedProtocolVersions {
+		if v == requested {
+			return v
+		}
+	}
+	return latestProtocolVersion
+}
+
+// protocolVersionAtLeast reports whether version is the same as or newer than minimum
+func protocolVersionAtLeast(version string, minimum string) bool {
+	return version >= minimum
+}
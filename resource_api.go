@@ -4,6 +4,10 @@ package mcp_golang
 
 type ResourceResponse struct {
 	Contents []*EmbeddedResource `json:"contents"`
+
+	// This result property is reserved by the protocol to allow clients and servers
+	// to attach additional metadata to their responses.
+	Meta ReadResourceResultMeta `json:"_meta,omitempty"`
 }
 
 func NewResourceResponse(contents ...*EmbeddedResource) *ResourceResponse {
@@ -11,3 +15,9 @@ func NewResourceResponse(contents ...*EmbeddedResource) *ResourceResponse {
 		Contents: contents,
 	}
 }
+
+// WithMeta sets the _meta of the result
+func (r *ResourceResponse) WithMeta(meta map[string]interface{}) *ResourceResponse {
+	r.Meta = meta
+	return r
+}
@@ -0,0 +1,137 @@
+package mcp_golang
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+const schemaDefinitionsRefPrefix = "#/$defs/"
+
+var customSchemaType = reflect.TypeOf((*interface{ JSONSchema() *jsonschema.Schema })(nil)).Elem()
+
+// NewSchemaReflector returns a copy of the reflector the server uses by default to generate the schemas of tool
+// arguments, tool output and elicitation requests from go types.
+// Customise it, e.g. with a Mapper for your own types or with AddGoComments to use doc comments as descriptions,
+// and pass it to WithSchemaReflector.
+//
+// Types can also describe themselves by implementing `JSONSchema() *jsonschema.Schema`, or adjust the generated schema
+// by implementing `JSONSchemaExtend(*jsonschema.Schema)`.
+func NewSchemaReflector() *jsonschema.Reflector {
+	r := jsonSchemaReflector
+	return &r
+}
+
+// WithSchemaReflector sets the reflector used to generate schemas from go types.
+// Whatever the reflector's settings, the root of a generated schema is always an object rather than a reference.
+func WithSchemaReflector(reflector *jsonschema.Reflector) ServerOptions {
+	return func(s *Server) {
+		s.schemaReflector = reflector
+	}
+}
+
+// WithSchemaCommentMap adds descriptions for types and fields to generated schemas, in addition to any in the reflector's
+// CommentMap. Keys are fully qualified names such as "github.com/me/tools.Config.Timeout".
+// Such a map can be produced at build time with jsonschema.ExtractGoComments, as the source usually isn't available at runtime.
+func WithSchemaCommentMap(comments map[string]string) ServerOptions {
+	return func(s *Server) {
+		if s.schemaComments == nil {
+			s.schemaComments = map[string]string{}
+		}
+		maps.Copy(s.schemaComments, comments)
+	}
+}
+
+// Generates the schema of a go type with the server's reflector.
+// Types are expanded inline unless the reflector is set to use references, except for recursive types which can't be
+// expanded and are put in $defs instead.
+func (s *Server) reflectSchema(t reflect.Type) *jsonschema.Schema {
+	reflector := jsonSchemaReflector
+	if s.schemaReflector != nil {
+		reflector = *s.schemaReflector
+	}
+	if len(s.schemaComments) > 0 {
+		comments := maps.Clone(reflector.CommentMap)
+		if comments == nil {
+			comments = map[string]string{}
+		}
+		maps.Copy(comments, s.schemaComments)
+		reflector.CommentMap = comments
+	}
+	return reflectSchema(&reflector, t)
+}
+
+func reflectSchema(reflector *jsonschema.Reflector, t reflect.Type) *jsonschema.Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	r := *reflector
+	if isRecursiveType(t) {
+		r.DoNotReference = false
+	}
+	// Expanding the root ourselves rather than with ExpandedStruct keeps its definition around for recursive references
+	r.ExpandedStruct = false
+	schema := r.ReflectFromType(t)
+
+	name, ok := strings.CutPrefix(schema.Ref, schemaDefinitionsRefPrefix)
+	if !ok {
+		return schema
+	}
+	definition, ok := schema.Definitions[name]
+	if !ok {
+		return schema
+	}
+	root := *definition
+	root.Version = schema.Version
+	root.ID = schema.ID
+	root.Definitions = schema.Definitions
+	if !schemaReferences(&root, schemaDefinitionsRefPrefix+name) {
+		delete(root.Definitions, name)
+	}
+	if len(root.Definitions) == 0 {
+		root.Definitions = nil
+	}
+	return &root
+}
+
+// Reports whether anything in the schema, including its definitions, refers to ref
+func schemaReferences(schema *jsonschema.Schema, ref string) bool {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return true
+	}
+	encodedRef, err := json.Marshal(ref)
+	if err != nil {
+		return true
+	}
+	return strings.Contains(string(b), `"$ref":`+string(encodedRef))
+}
+
+// Reports whether a type contains itself, e.g. a tree node with a slice of child nodes.
+// The reflector can't expand these inline so they must be referenced through $defs.
+func isRecursiveType(t reflect.Type) bool {
+	return containsRecursiveType(t, map[reflect.Type]bool{})
+}
+
+func containsRecursiveType(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Implements(customSchemaType) || reflect.PointerTo(t).Implements(customSchemaType) {
+		return false
+	}
+	if visiting[t] {
+		return true
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		if containsRecursiveType(t.Field(i).Type, visiting) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package mcp_golang
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+type schemaTestNode struct {
+	Name     string           `json:"name" jsonschema:"required"`
+	Children []schemaTestNode `json:"children,omitempty"`
+}
+
+type schemaTestColour string
+
+func (schemaTestColour) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "string", Enum: []any{"red", "green"}}
+}
+
+type schemaTestConfig struct {
+	Timeout int              `json:"timeout"`
+	Colour  schemaTestColour `json:"colour"`
+	Raw     json.RawMessage  `json:"raw,omitempty"`
+}
+
+func TestReflectSchema(t *testing.T) {
+	server := NewServer(nil, WithSchemaCommentMap(map[string]string{
+		"github.com/metoro-io/mcp-golang.schemaTestConfig":         "Configures the thing.",
+		"github.com/metoro-io/mcp-golang.schemaTestConfig.Timeout": "Timeout in seconds.",
+	}))
+
+	tests := []struct {
+		name     string
+		t        reflect.Type
+		expected string
+	}{
+		{
+			name:     "recursive types use definitions",
+			t:        reflect.TypeOf(schemaTestNode{}),
+			expected: `{"$schema":"https://json-schema.org/draft/2020-12/schema","$defs":{"schemaTestNode":{"properties":{"name":{"type":"string"},"children":{"items":{"$ref":"#/$defs/schemaTestNode"},"type":"array"}},"additionalProperties":false,"type":"object","required":["name"]}},"properties":{"name":{"type":"string"},"children":{"items":{"$ref":"#/$defs/schemaTestNode"},"type":"array"}},"additionalProperties":false,"type":"object","required":["name"]}`,
+		},
+		{
+			name:     "custom schemas, raw messages and comments",
+			t:        reflect.TypeOf(&schemaTestConfig{}),
+			expected: `{"$schema":"https://json-schema.org/draft/2020-12/schema","properties":{"timeout":{"type":"integer","description":"Timeout in seconds."},"colour":{"type":"string","enum":["red","green"]},"raw":true},"additionalProperties":false,"type":"object","description":"Configures the thing."}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(server.reflectSchema(tt.t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, b)
+			}
+		})
+	}
+
+	// Recursive arguments are validated through their references
+	_, _, err := validateToolArguments(server.reflectSchema(reflect.TypeOf(schemaTestNode{})), []byte(`{"name":"root","children":[{"children":[]}]}`), ArgumentDecodingDefault)
+	if err == nil || err.Error() != "invalid arguments:\n- /children/0/name: is required" {
+		t.Errorf("Expected the nested node to be missing its name, got %v", err)
+	}
+}
+
+func TestWithSchemaReflector(t *testing.T) {
+	reflector := NewSchemaReflector()
+	reflector.DoNotReference = false
+	reflector.AllowAdditionalProperties = true
+	server := NewServer(nil, WithSchemaReflector(reflector))
+
+	type inner struct {
+		Value string `json:"value"`
+	}
+	type outer struct {
+		First  inner `json:"first"`
+		Second inner `json:"second"`
+	}
+	b, err := json.Marshal(server.reflectSchema(reflect.TypeOf(outer{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"$schema":"https://json-schema.org/draft/2020-12/schema","$defs":{"inner":{"properties":{"value":{"type":"string"}},"type":"object"}},"properties":{"first":{"$ref":"#/$defs/inner"},"second":{"$ref":"#/$defs/inner"}},"type":"object"}`
+	if string(b) != expected {
+		t.Errorf("Expected %s, got %s", expected, b)
+	}
+	if jsonSchemaReflector.DoNotReference != true {
+		t.Error("Expected the default reflector to be left untouched")
+	}
+}
@@ -0,0 +1,740 @@
+package mcp_golang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/invopop/jsonschema"
+)
+
+// ArgumentDecodingMode controls how forgiving the server is of tool arguments that don't quite match the tool's schema
+type ArgumentDecodingMode int
+
+const (
+	// Arguments must match the schema. Absent arguments and null optional properties are treated as not given.
+	ArgumentDecodingDefault ArgumentDecodingMode = iota
+	// Values that can be converted to the type the schema asks for are, e.g. "5" for an integer, "true" for a boolean,
+	// a single item where an array is expected or an object sent as a JSON encoded string. Unknown properties are dropped
+	// rather than rejected. Models often produce arguments like these.
+	ArgumentDecodingLenient
+	// Unknown properties are rejected even where the schema allows them and null is only accepted where the schema allows it.
+	ArgumentDecodingStrict
+)
+
+// ArgumentViolation is a single argument that doesn't match the schema of a tool.
+// Path is a JSON pointer to the offending value, e.g. /content/title
+type ArgumentViolation struct {
+	Path    string
+	Message string
+}
+
+// ArgumentValidationError is returned to the client as a tool error when the arguments of a tool call don't match the
+// tool's input schema. The handler isn't called.
+type ArgumentValidationError struct {
+	Violations []ArgumentViolation
+}
+
+func (e *ArgumentValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid arguments:")
+	for _, violation := range e.Violations {
+		path := violation.Path
+		if path == "" {
+			path = "/"
+		}
+		b.WriteString("\n- ")
+		b.WriteString(path)
+		b.WriteString(": ")
+		b.WriteString(violation.Message)
+	}
+	return b.String()
+}
+
+// Validates the arguments of a tool call against the tool's input schema and fills in the defaults of missing properties.
+// The returned arguments should be used in place of the ones given, along with a description of every value that was
+// coerced in lenient mode.
+// Except in strict mode, absent arguments are treated as an empty object and a null optional property is treated as if
+// it was absent, models often send those for arguments they have nothing to say about.
+func validateToolArguments(schema *jsonschema.Schema, arguments json.RawMessage, mode ArgumentDecodingMode) (json.RawMessage, []string, error) {
+	if schema == nil {
+		return arguments, nil, nil
+	}
+	var value interface{} = map[string]interface{}{}
+	if len(arguments) != 0 && string(arguments) != "null" {
+		decoder := json.NewDecoder(bytes.NewReader(arguments))
+		decoder.UseNumber()
+		err := decoder.Decode(&value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+		}
+	}
+
+	v := &schemaValidator{root: schema, mode: mode}
+	value = v.validate(schema, value, "")
+	if len(v.violations) > 0 {
+		return nil, v.coercions, &ArgumentValidationError{Violations: v.violations}
+	}
+	validated, err := json.Marshal(value)
+	if err != nil {
+		return nil, v.coercions, fmt.Errorf("failed to marshal arguments: %w", err)
+	}
+	return validated, v.coercions, nil
+}
+
+type schemaValidator struct {
+	root       *jsonschema.Schema
+	mode       ArgumentDecodingMode
+	violations []ArgumentViolation
+	coercions  []string
+}
+
+func (v *schemaValidator) fail(path string, format string, args ...interface{}) {
+	v.violations = append(v.violations, ArgumentViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *schemaValidator) coerced(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	v.coercions = append(v.coercions, path+": "+fmt.Sprintf(format, args...))
+}
+
+// Validates value against the schema, recording any violations, and returns the value with defaults applied
+func (v *schemaValidator) validate(schema *jsonschema.Schema, value interface{}, path string) interface{} {
+	if schema == nil {
+		return value
+	}
+	if isFalseSchema(schema) {
+		v.fail(path, "is not allowed")
+		return value
+	}
+	if schema.Ref != "" {
+		resolved, err := v.resolve(schema.Ref)
+		if err != nil {
+			v.fail(path, "%s", err.Error())
+			return value
+		}
+		value = v.validate(resolved, value, path)
+	}
+	for _, subSchema := range schema.AllOf {
+		value = v.validate(subSchema, value, path)
+	}
+	if len(schema.AnyOf) > 0 && v.countMatching(schema.AnyOf, value) == 0 {
+		v.fail(path, "does not match any of the allowed schemas")
+	}
+	if len(schema.OneOf) > 0 && v.countMatching(schema.OneOf, value) != 1 {
+		v.fail(path, "must match exactly one of the allowed schemas")
+	}
+	if schema.Not != nil && v.countMatching([]*jsonschema.Schema{schema.Not}, value) == 1 {
+		v.fail(path, "matches a schema it must not match")
+	}
+
+	types := schemaTypes(schema)
+	if len(types) > 0 && !slices.ContainsFunc(types, func(schemaType string) bool { return valueHasType(value, schemaType) }) {
+		var coerced interface{}
+		ok := false
+		for _, schemaType := range types {
+			if coerced, ok = v.coerce(value, schemaType, path); ok {
+				break
+			}
+		}
+		if !ok {
+			v.fail(path, "expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+			return value
+		}
+		value = coerced
+	}
+	if v.mode == ArgumentDecodingLenient && slices.Contains(types, "integer") && !slices.Contains(types, "number") {
+		value = v.canonicalInteger(value, path)
+	}
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if jsonValuesEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "must be one of %s", formatJSONValues(schema.Enum))
+		}
+	}
+	if schema.Const != nil && !jsonValuesEqual(schema.Const, value) {
+		v.fail(path, "must be %s", formatJSONValues([]any{schema.Const}))
+	}
+
+	switch typed := value.(type) {
+	case json.Number:
+		v.validateNumber(schema, typed, path)
+	case string:
+		v.validateString(schema, typed, path)
+	case []interface{}:
+		return v.validateArray(schema, typed, path)
+	case map[string]interface{}:
+		return v.validateObject(schema, typed, path)
+	}
+	return value
+}
+
+func (v *schemaValidator) validateNumber(schema *jsonschema.Schema, number json.Number, path string) {
+	value, err := number.Float64()
+	if err != nil {
+		v.fail(path, "is not a valid number")
+		return
+	}
+	if limit, ok := schemaNumber(schema.Minimum); ok && value < limit {
+		v.fail(path, "must be >= %s", schema.Minimum)
+	}
+	if limit, ok := schemaNumber(schema.Maximum); ok && value > limit {
+		v.fail(path, "must be <= %s", schema.Maximum)
+	}
+	if limit, ok := schemaNumber(schema.ExclusiveMinimum); ok && value <= limit {
+		v.fail(path, "must be > %s", schema.ExclusiveMinimum)
+	}
+	if limit, ok := schemaNumber(schema.ExclusiveMaximum); ok && value >= limit {
+		v.fail(path, "must be < %s", schema.ExclusiveMaximum)
+	}
+	if multipleOf, ok := schemaNumber(schema.MultipleOf); ok && multipleOf > 0 {
+		quotient := value / multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.fail(path, "must be a multiple of %s", schema.MultipleOf)
+		}
+	}
+}
+
+func (v *schemaValidator) validateString(schema *jsonschema.Schema, value string, path string) {
+	length := uint64(utf8.RuneCountInString(value))
+	if schema.MinLength != nil && length < *schema.MinLength {
+		v.fail(path, "must be at least %d characters long", *schema.MinLength)
+	}
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		v.fail(path, "must be at most %d characters long", *schema.MaxLength)
+	}
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+		if err == nil && !pattern.MatchString(value) {
+			v.fail(path, "must match the pattern %s", schema.Pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(schema *jsonschema.Schema, items []interface{}, path string) interface{} {
+	length := uint64(len(items))
+	if schema.MinItems != nil && length < *schema.MinItems {
+		v.fail(path, "must have at least %d items", *schema.MinItems)
+	}
+	if schema.MaxItems != nil && length > *schema.MaxItems {
+		v.fail(path, "must have at most %d items", *schema.MaxItems)
+	}
+	for i, item := range items {
+		itemSchema := schema.Items
+		if i < len(schema.PrefixItems) {
+			itemSchema = schema.PrefixItems[i]
+		}
+		items[i] = v.validate(itemSchema, item, fmt.Sprintf("%s/%d", path, i))
+	}
+	if schema.UniqueItems {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if jsonValuesEqual(items[i], items[j]) {
+					v.fail(path, "items %d and %d must be unique", i, j)
+				}
+			}
+		}
+	}
+	return items
+}
+
+func (v *schemaValidator) validateObject(schema *jsonschema.Schema, object map[string]interface{}, path string) interface{} {
+	required := map[string]bool{}
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	if v.mode != ArgumentDecodingStrict {
+		for name, value := range object {
+			if value == nil && !required[name] {
+				delete(object, name)
+			}
+		}
+	}
+
+	if schema.Properties != nil {
+		for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			if _, ok := object[pair.Key]; !ok && pair.Value != nil && pair.Value.Default != nil {
+				object[pair.Key] = normalizeJSONValue(pair.Value.Default)
+			}
+		}
+	}
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			v.fail(path+"/"+escapeJSONPointer(name), "is required")
+		}
+	}
+	if schema.MinProperties != nil && uint64(len(object)) < *schema.MinProperties {
+		v.fail(path, "must have at least %d properties", *schema.MinProperties)
+	}
+	if schema.MaxProperties != nil && uint64(len(object)) > *schema.MaxProperties {
+		v.fail(path, "must have at most %d properties", *schema.MaxProperties)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(object)) {
+		propertyPath := path + "/" + escapeJSONPointer(name)
+		matched := false
+		if schema.Properties != nil {
+			if propertySchema, ok := schema.Properties.Get(name); ok {
+				object[name] = v.validate(propertySchema, object[name], propertyPath)
+				matched = true
+			}
+		}
+		for pattern, propertySchema := range schema.PatternProperties {
+			re, err := regexp.Compile(pattern)
+			if err == nil && re.MatchString(name) {
+				object[name] = v.validate(propertySchema, object[name], propertyPath)
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		if schema.AdditionalProperties == nil && v.mode != ArgumentDecodingStrict {
+			continue
+		}
+		if schema.AdditionalProperties == nil || isFalseSchema(schema.AdditionalProperties) {
+			if v.mode == ArgumentDecodingLenient {
+				delete(object, name)
+				v.coerced(propertyPath, "dropped unknown property")
+				continue
+			}
+			v.fail(propertyPath, "unknown property")
+			continue
+		}
+		object[name] = v.validate(schema.AdditionalProperties, object[name], propertyPath)
+	}
+	return object
+}
+
+// In lenient mode, converts a value to the type the schema asks for if it can be done without losing information
+func (v *schemaValidator) coerce(value interface{}, schemaType string, path string) (interface{}, bool) {
+	if v.mode != ArgumentDecodingLenient {
+		return nil, false
+	}
+	switch typed := value.(type) {
+	case string:
+		trimmed := strings.TrimSpace(typed)
+		switch schemaType {
+		case "integer", "number":
+			number := json.Number(trimmed)
+			if _, err := number.Float64(); err != nil || !valueHasType(number, schemaType) {
+				return nil, false
+			}
+			v.coerced(path, "string %q to %s", typed, schemaType)
+			return number, true
+		case "boolean":
+			switch strings.ToLower(trimmed) {
+			case "true":
+				v.coerced(path, "string %q to boolean", typed)
+				return true, true
+			case "false":
+				v.coerced(path, "string %q to boolean", typed)
+				return false, true
+			}
+			return nil, false
+		case "object", "array":
+			// Objects and arrays are sometimes sent JSON encoded as a string
+			decoder := json.NewDecoder(strings.NewReader(trimmed))
+			decoder.UseNumber()
+			var decoded interface{}
+			if decoder.Decode(&decoded) != nil || decoder.More() || !valueHasType(decoded, schemaType) {
+				break
+			}
+			v.coerced(path, "JSON encoded string to %s", schemaType)
+			return decoded, true
+		}
+	case json.Number, bool:
+		if schemaType == "string" {
+			v.coerced(path, "%s to string", jsonTypeName(value))
+			return fmt.Sprint(typed), true
+		}
+	}
+	if schemaType == "array" && value != nil {
+		v.coerced(path, "single %s to array", jsonTypeName(value))
+		return []interface{}{value}, true
+	}
+	return nil, false
+}
+
+// Integers with a fraction or exponent, such as 5.0 or 1e3, are valid in JSON schema but can't be decoded into go integers
+func (v *schemaValidator) canonicalInteger(value interface{}, path string) interface{} {
+	number, ok := value.(json.Number)
+	if !ok || !strings.ContainsAny(string(number), ".eE") {
+		return value
+	}
+	f, err := number.Float64()
+	if err != nil || math.Abs(f) > 1<<53 {
+		return value
+	}
+	canonical := json.Number(strconv.FormatInt(int64(f), 10))
+	v.coerced(path, "number %s to integer %s", number, canonical)
+	return canonical
+}
+
+// Counts the schemas the value matches without recording any violations
+func (v *schemaValidator) countMatching(schemas []*jsonschema.Schema, value interface{}) int {
+	matching := 0
+	for _, schema := range schemas {
+		trial := &schemaValidator{root: v.root, mode: v.mode}
+		trial.validate(schema, deepCopyJSONValue(value), "")
+		if len(trial.violations) == 0 {
+			matching++
+		}
+	}
+	return matching
+}
+
+// Only references to definitions in the root schema are supported, this is all the reflector generates
+func (v *schemaValidator) resolve(ref string) (*jsonschema.Schema, error) {
+	for _, prefix := range []string{"#/$defs/", "#/definitions/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			if schema, ok := v.root.Definitions[name]; ok {
+				return schema, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unresolvable schema reference %s", ref)
+}
+
+// The jsonschema package represents `false` as a schema with an unexported flag, the only way to see it is to marshal it
+func isFalseSchema(schema *jsonschema.Schema) bool {
+	if schema.Type != "" || schema.Ref != "" || schema.Properties != nil {
+		return false
+	}
+	b, err := json.Marshal(schema)
+	return err == nil && string(b) == "false"
+}
+
+func valueHasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := number.Float64()
+		return err == nil && f == math.Trunc(f)
+	}
+	return true
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaNumber(number json.Number) (float64, bool) {
+	if number == "" {
+		return 0, false
+	}
+	f, err := number.Float64()
+	return f, err == nil
+}
+
+// Values from the schema are ordinary go values whereas arguments are decoded with json.Number,
+// comparing them through their JSON representation with numbers as float64 treats 1, 1.0 and json.Number("1") as equal
+func jsonValuesEqual(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(toComparableJSONValue(a), toComparableJSONValue(b))
+}
+
+func toComparableJSONValue(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var comparable interface{}
+	if json.Unmarshal(b, &comparable) != nil {
+		return value
+	}
+	return comparable
+}
+
+// Converts a go value, such as a schema default, into the representation used for decoded arguments
+func normalizeJSONValue(value interface{}) interface{} {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var normalized interface{}
+	if decoder.Decode(&normalized) != nil {
+		return value
+	}
+	return normalized
+}
+
+func deepCopyJSONValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			copied[k] = deepCopyJSONValue(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, v := range typed {
+			copied[i] = deepCopyJSONValue(v)
+		}
+		return copied
+	}
+	return value
+}
+
+func formatJSONValues(values []any) string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		b, err := json.Marshal(value)
+		if err != nil {
+			formatted = append(formatted, fmt.Sprint(value))
+			continue
+		}
+		formatted = append(formatted, string(b))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func escapeJSONPointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// The jsonschema package only has room for a single type, the types of schemas that list several, e.g.
+// {"type": ["string", "null"]}, are kept in Extras under this key
+const schemaTypesExtra = "type"
+
+// The types a schema allows, none if it allows any
+func schemaTypes(schema *jsonschema.Schema) []string {
+	if types, ok := schema.Extras[schemaTypesExtra].([]string); ok {
+		return types
+	}
+	if schema.Type != "" {
+		return []string{schema.Type}
+	}
+	return nil
+}
+
+// Parses a tool input schema given as JSON and checks that it is well formed: it must be an object schema, every
+// pattern must compile and every reference must point at one of its definitions.
+func parseToolInputSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(&object)
+	if err != nil {
+		return nil, fmt.Errorf("input schema must be a JSON object: %w", err)
+	}
+	if object == nil {
+		return nil, fmt.Errorf("input schema must be a JSON object")
+	}
+	// Type arrays are taken out so that the rest of the schema can be parsed, and put back once it is
+	typeArrays := map[string][]string{}
+	err = takeTypeArrays(object, "", typeArrays)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+	withoutTypeArrays, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+	var schema jsonschema.Schema
+	err = json.Unmarshal(withoutTypeArrays, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+	putTypeArrays(&schema, "", typeArrays)
+	if types := schemaTypes(&schema); !slices.Equal(types, []string{"object"}) {
+		return nil, fmt.Errorf("input schema must have type \"object\", got %s", formatJSONValues([]any{types}))
+	}
+	v := &schemaValidator{root: &schema}
+	err = v.checkWellFormed(&schema, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid input schema: %w", err)
+	}
+	return &schema, nil
+}
+
+func (v *schemaValidator) checkWellFormed(schema *jsonschema.Schema, path string) error {
+	if schema == nil {
+		return nil
+	}
+	for _, schemaType := range schemaTypes(schema) {
+		switch schemaType {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("%s: unknown type %q", schemaPath(path), schemaType)
+		}
+	}
+	if schema.Ref != "" {
+		if _, err := v.resolve(schema.Ref); err != nil {
+			return fmt.Errorf("%s: %w", schemaPath(path), err)
+		}
+	}
+	if schema.Pattern != "" {
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", schemaPath(path), err)
+		}
+	}
+	for _, number := range []json.Number{schema.Minimum, schema.Maximum, schema.ExclusiveMinimum, schema.ExclusiveMaximum, schema.MultipleOf} {
+		if _, err := number.Float64(); number != "" && err != nil {
+			return fmt.Errorf("%s: invalid number %s", schemaPath(path), number)
+		}
+	}
+
+	for pattern := range schema.PatternProperties {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s: invalid pattern property %q: %w", schemaPath(path), pattern, err)
+		}
+	}
+	children := schemaChildren(schema)
+	for _, childPath := range slices.Sorted(maps.Keys(children)) {
+		err := v.checkWellFormed(children[childPath], path+childPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The schemas nested in a schema, by their JSON pointer relative to it
+func schemaChildren(schema *jsonschema.Schema) map[string]*jsonschema.Schema {
+	children := map[string]*jsonschema.Schema{
+		"/items":                schema.Items,
+		"/additionalProperties": schema.AdditionalProperties,
+		"/not":                  schema.Not,
+	}
+	if schema.Properties != nil {
+		for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			children["/properties/"+escapeJSONPointer(pair.Key)] = pair.Value
+		}
+	}
+	for pattern, child := range schema.PatternProperties {
+		children["/patternProperties/"+escapeJSONPointer(pattern)] = child
+	}
+	for name, child := range schema.Definitions {
+		children["/$defs/"+escapeJSONPointer(name)] = child
+	}
+	for keyword, list := range map[string][]*jsonschema.Schema{"allOf": schema.AllOf, "anyOf": schema.AnyOf, "oneOf": schema.OneOf, "prefixItems": schema.PrefixItems} {
+		for i, child := range list {
+			children[fmt.Sprintf("/%s/%d", keyword, i)] = child
+		}
+	}
+	return children
+}
+
+// Removes the type arrays of a schema given as JSON and of the schemas nested in it, recording them by JSON pointer.
+// The schemas are found the same way schemaChildren finds them once parsed.
+func takeTypeArrays(schema map[string]interface{}, path string, typeArrays map[string][]string) error {
+	if list, ok := schema["type"].([]interface{}); ok {
+		types := make([]string, 0, len(list))
+		for _, item := range list {
+			schemaType, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("%s: types must be strings", schemaPath(path))
+			}
+			types = append(types, schemaType)
+		}
+		if len(types) == 0 {
+			return fmt.Errorf("%s: type must list at least one type", schemaPath(path))
+		}
+		typeArrays[path] = types
+		delete(schema, "type")
+	}
+	for _, keyword := range []string{"items", "additionalProperties", "not"} {
+		if child, ok := schema[keyword].(map[string]interface{}); ok {
+			if err := takeTypeArrays(child, path+"/"+keyword, typeArrays); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "$defs"} {
+		children, _ := schema[keyword].(map[string]interface{})
+		for name, child := range children {
+			if child, ok := child.(map[string]interface{}); ok {
+				if err := takeTypeArrays(child, path+"/"+keyword+"/"+escapeJSONPointer(name), typeArrays); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems"} {
+		children, _ := schema[keyword].([]interface{})
+		for i, child := range children {
+			if child, ok := child.(map[string]interface{}); ok {
+				if err := takeTypeArrays(child, fmt.Sprintf("%s/%s/%d", path, keyword, i), typeArrays); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Puts the type arrays recorded by takeTypeArrays back into the parsed schemas
+func putTypeArrays(schema *jsonschema.Schema, path string, typeArrays map[string][]string) {
+	if schema == nil {
+		return
+	}
+	if types, ok := typeArrays[path]; ok {
+		if schema.Extras == nil {
+			schema.Extras = map[string]any{}
+		}
+		schema.Extras[schemaTypesExtra] = types
+	}
+	for childPath, child := range schemaChildren(schema) {
+		putTypeArrays(child, path+childPath, typeArrays)
+	}
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,138 @@
+package mcp_golang
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateToolArguments(t *testing.T) {
+	type location struct {
+		City    string `json:"city" jsonschema:"required"`
+		Country string `json:"country,omitempty" jsonschema:"default=UK"`
+	}
+	type forecastArgs struct {
+		Location location `json:"location" jsonschema:"required"`
+		Days     int      `json:"days" jsonschema:"required,minimum=1,maximum=14"`
+		Units    string   `json:"units,omitempty" jsonschema:"enum=celsius,enum=fahrenheit,default=celsius"`
+		Detailed *bool    `json:"detailed,omitempty"`
+		Tags     []string `json:"tags,omitempty" jsonschema:"maxItems=2"`
+	}
+	schema := NewServer(nil).reflectSchema(reflect.TypeOf(forecastArgs{}))
+
+	tests := []struct {
+		name       string
+		mode       ArgumentDecodingMode
+		arguments  string
+		expected   string
+		coercions  []string
+		violations []ArgumentViolation
+	}{
+		{
+			name:      "valid arguments with defaults applied",
+			arguments: `{"location":{"city":"London"},"days":3}`,
+			expected:  `{"days":3,"location":{"city":"London","country":"UK"},"units":"celsius"}`,
+		},
+		{
+			name:      "null optional arguments are dropped",
+			arguments: `{"location":{"city":"London","country":"FR"},"days":3,"units":"fahrenheit","detailed":null}`,
+			expected:  `{"days":3,"location":{"city":"London","country":"FR"},"units":"fahrenheit"}`,
+		},
+		{
+			name:      "missing arguments",
+			arguments: ``,
+			violations: []ArgumentViolation{
+				{Path: "/location", Message: "is required"},
+				{Path: "/days", Message: "is required"},
+			},
+		},
+		{
+			name:      "every kind of violation",
+			arguments: `{"location":{"town":"London"},"days":30.5,"units":"kelvin","tags":["a","b","c"],"extra":true}`,
+			violations: []ArgumentViolation{
+				{Path: "/days", Message: "expected integer, got number"},
+				{Path: "/extra", Message: "unknown property"},
+				{Path: "/location/city", Message: "is required"},
+				{Path: "/location/town", Message: "unknown property"},
+				{Path: "/tags", Message: "must have at most 2 items"},
+				{Path: "/units", Message: `must be one of "celsius", "fahrenheit"`},
+			},
+		},
+		{
+			name:      "out of range",
+			arguments: `{"location":{"city":"London"},"days":0}`,
+			violations: []ArgumentViolation{
+				{Path: "/days", Message: "must be >= 1"},
+			},
+		},
+		{
+			name:      "wrong type",
+			arguments: `{"location":"London","days":"3"}`,
+			violations: []ArgumentViolation{
+				{Path: "/days", Message: "expected integer, got string"},
+				{Path: "/location", Message: "expected object, got string"},
+			},
+		},
+		{
+			name:      "lenient coercion",
+			mode:      ArgumentDecodingLenient,
+			arguments: `{"location":"{\"city\":\"London\"}","days":"3","detailed":"TRUE","tags":"rain","extra":1}`,
+			expected:  `{"days":3,"detailed":true,"location":{"city":"London","country":"UK"},"tags":["rain"],"units":"celsius"}`,
+			coercions: []string{
+				"/days: string \"3\" to integer",
+				"/detailed: string \"TRUE\" to boolean",
+				"/extra: dropped unknown property",
+				"/location: JSON encoded string to object",
+				"/tags: single string to array",
+			},
+		},
+		{
+			name:      "lenient integer with a fraction",
+			mode:      ArgumentDecodingLenient,
+			arguments: `{"location":{"city":"London"},"days":3.0}`,
+			expected:  `{"days":3,"location":{"city":"London","country":"UK"},"units":"celsius"}`,
+			coercions: []string{"/days: number 3.0 to integer 3"},
+		},
+		{
+			name:      "lenient values that can't be coerced",
+			mode:      ArgumentDecodingLenient,
+			arguments: `{"location":{"city":"London"},"days":"three"}`,
+			violations: []ArgumentViolation{
+				{Path: "/days", Message: "expected integer, got string"},
+			},
+		},
+		{
+			name:      "strict null",
+			mode:      ArgumentDecodingStrict,
+			arguments: `{"location":{"city":"London"},"days":3,"units":null,"tags":null}`,
+			violations: []ArgumentViolation{
+				{Path: "/tags", Message: "expected array, got null"},
+				{Path: "/units", Message: "expected string, got null"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validated, coercions, err := validateToolArguments(schema, []byte(tt.arguments), tt.mode)
+			if !reflect.DeepEqual(coercions, tt.coercions) {
+				t.Errorf("Expected coercions %q, got %q", tt.coercions, coercions)
+			}
+			if tt.violations == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(validated) != tt.expected {
+					t.Errorf("Expected %s, got %s", tt.expected, validated)
+				}
+				return
+			}
+			var validationErr *ArgumentValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected an ArgumentValidationError, got %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Violations, tt.violations) {
+				t.Errorf("Expected violations %+v, got %+v", tt.violations, validationErr.Violations)
+			}
+		})
+	}
+}
@@ -1,17 +1,24 @@
 package mcp_golang
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/invopop/jsonschema"
 	"github.com/metoro-io/mcp-golang/internal/datastructures"
 	"github.com/metoro-io/mcp-golang/internal/protocol"
 	"github.com/metoro-io/mcp-golang/internal/tools"
 	"github.com/metoro-io/mcp-golang/transport"
+	"log/slog"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Here we define the actual MCP server that users will create and run
@@ -24,7 +31,11 @@ import (
 
 type toolResponseSent struct {
 	Response *ToolResponse
-	Error    error
+	// The typed result of a handler that returns a struct rather than a ToolResponse, sent as structuredContent
+	StructuredContent interface{}
+	Error             error
+	// The result's _meta, see ToolResponse.Meta and RequestContext.SetResultMeta
+	Meta map[string]interface{}
 }
 
 // Custom JSON marshaling for ToolResponse
@@ -34,11 +45,15 @@ func (c toolResponseSent) MarshalJSON() ([]byte, error) {
 		c.Response = NewToolResponse(NewTextContent(errorText))
 	}
 	return json.Marshal(struct {
-		Content []*Content `json:"content" yaml:"content" mapstructure:"content"`
-		IsError bool       `json:"isError" yaml:"isError" mapstructure:"isError"`
+		Content           []*Content             `json:"content" yaml:"content" mapstructure:"content"`
+		StructuredContent interface{}            `json:"structuredContent,omitempty" yaml:"structuredContent,omitempty" mapstructure:"structuredContent,omitempty"`
+		IsError           bool                   `json:"isError" yaml:"isError" mapstructure:"isError"`
+		Meta              map[string]interface{} `json:"_meta,omitempty" yaml:"_meta,omitempty" mapstructure:"_meta,omitempty"`
 	}{
-		Content: c.Response.Content,
-		IsError: c.Error != nil,
+		Meta:              c.Meta,
+		Content:           c.Response.Content,
+		StructuredContent: c.StructuredContent,
+		IsError:           c.Error != nil || c.Response.IsError,
 	})
 }
 
@@ -48,13 +63,20 @@ func (c resourceResponseSent) MarshalJSON() ([]byte, error) {
 		errorText := c.Error.Error()
 		c.Response = NewResourceResponse(NewTextEmbeddedResource(c.Uri, errorText, "text/plain"))
 	}
-	return json.Marshal(c.Response)
+	if c.Response == nil {
+		return json.Marshal(c.Response)
+	}
+	response := *c.Response
+	response.Meta = c.Meta
+	return json.Marshal(response)
 }
 
 type resourceResponseSent struct {
 	Response *ResourceResponse
 	Uri      string
 	Error    error
+	// The result's _meta, see ResourceResponse.Meta and RequestContext.SetResultMeta
+	Meta map[string]interface{}
 }
 
 func newResourceResponseSentError(err error) *resourceResponseSent {
@@ -73,6 +95,8 @@ func newResourceResponseSent(response *ResourceResponse) *resourceResponseSent {
 type promptResponseSent struct {
 	Response *PromptResponse
 	Error    error
+	// The result's _meta, see PromptResponse.Meta and RequestContext.SetResultMeta
+	Meta map[string]interface{}
 }
 
 func newPromptResponseSentError(err error) *promptResponseSent {
@@ -94,34 +118,74 @@ func (c promptResponseSent) MarshalJSON() ([]byte, error) {
 		errorText := c.Error.Error()
 		c.Response = NewPromptResponse("error", NewPromptMessage(NewTextContent(errorText), RoleUser))
 	}
-	return json.Marshal(c.Response)
+	if c.Response == nil {
+		return json.Marshal(c.Response)
+	}
+	response := *c.Response
+	response.Meta = c.Meta
+	return json.Marshal(response)
 }
 
 type Server struct {
-	isRunning          bool
+	// Guards isRunning, served, shutdown, session and listeners
+	mu        sync.Mutex
+	isRunning bool
+	// Whether the transport the server was created with has been served, from then on it is closed with its session
+	served   bool
+	shutdown bool
+	// The transports that carry many sessions the server is listening on
+	listeners          []transport.SessionListener
 	transport          transport.Transport
 	protocol           *protocol.Protocol
 	paginationLimit    *int
 	tools              *datastructures.SyncMap[string, *tool]
 	prompts            *datastructures.SyncMap[string, *prompt]
 	resources          *datastructures.SyncMap[string, *resource]
+	completers         *datastructures.SyncMap[completionKey, Completer]
 	serverInstructions *string
 	serverName         string
 	serverVersion      string
+	// The session of the transport the server was created with
+	session *Session
+	// Every connected session by id
+	sessions         *datastructures.SyncMap[string, *Session]
+	argumentDecoding ArgumentDecodingMode
+	accessPolicy     AccessPolicy
+	logger           *slog.Logger
+	schemaReflector  *jsonschema.Reflector
+	schemaComments   map[string]string
 }
 
 type prompt struct {
 	Name              string
 	Description       string
-	Handler           func(baseGetPromptRequestParamsArguments) *promptResponseSent
+	Handler           func(context.Context, baseGetPromptRequestParamsArguments) *promptResponseSent
 	PromptInputSchema *promptSchema
 }
 
 type tool struct {
-	Name            string
-	Description     string
-	Handler         func(baseCallToolRequestParams) *toolResponseSent
-	ToolInputSchema *jsonschema.Schema
+	Name             string
+	Title            *string
+	Description      string
+	Annotations      *ToolAnnotations
+	Handler          func(context.Context, baseCallToolRequestParams) *toolResponseSent
+	ToolInputSchema  *jsonschema.Schema
+	ToolOutputSchema *jsonschema.Schema
+	// Overrides the server's argument decoding mode for this tool
+	argumentDecoding *ArgumentDecodingMode
+	// The schema given to RegisterToolWithSchema, sent to clients exactly as it was given
+	rawInputSchema json.RawMessage
+	// Execution policies, see tool_policy.go
+	timeout     time.Duration
+	concurrency chan struct{}
+	inflight    *toolCallGroup
+}
+
+func (t *tool) annotations() *ToolAnnotations {
+	if t.Annotations == nil {
+		t.Annotations = &ToolAnnotations{}
+	}
+	return t.Annotations
 }
 
 type resource struct {
@@ -129,7 +193,7 @@ type resource struct {
 	Description string
 	Uri         string
 	mimeType    string
-	Handler     func() *resourceResponseSent
+	Handler     func(context.Context) *resourceResponseSent
 }
 
 type ServerOptions func(*Server)
@@ -150,12 +214,15 @@ type CallToolResult struct {
 	Meta CallToolResultMeta `json:"_meta,omitempty" yaml:"_meta,omitempty" mapstructure:"_meta,omitempty"`
 
 	// Content corresponds to the JSON schema field "content".
-	Content []interface{} `json:"content" yaml:"content" mapstructure:"content"`
+	Content []*Content `json:"content" yaml:"content" mapstructure:"content"`
 
 	// Whether the tool call ended in an error.
 	//
 	// If not set, this is assumed to be false (the call was successful).
 	IsError *bool `json:"isError,omitempty" yaml:"isError,omitempty" mapstructure:"isError,omitempty"`
+
+	// An optional JSON object that represents the structured result of the tool call.
+	StructuredContent map[string]interface{} `json:"structuredContent,omitempty" yaml:"structuredContent,omitempty" mapstructure:"structuredContent,omitempty"`
 }
 
 // The server's response to a completion/complete request
@@ -680,43 +747,126 @@ func WithPaginationLimit(limit int) ServerOptions {
 	}
 }
 
+// WithArgumentDecoding sets how strictly tool arguments are checked against the tools' schemas.
+// It can be overridden for a single tool with WithToolArgumentDecoding.
+func WithArgumentDecoding(mode ArgumentDecodingMode) ServerOptions {
+	return func(s *Server) {
+		s.argumentDecoding = mode
+	}
+}
+
+// WithLogger sets the logger the server writes diagnostics to, slog.Default() is used otherwise.
+// Coerced tool arguments are logged at debug level.
+func WithLogger(logger *slog.Logger) ServerOptions {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
 func NewServer(transport transport.Transport, options ...ServerOptions) *Server {
 	server := &Server{
-		protocol:  protocol.NewProtocol(nil),
-		transport: transport,
-		tools:     new(datastructures.SyncMap[string, *tool]),
-		prompts:   new(datastructures.SyncMap[string, *prompt]),
-		resources: new(datastructures.SyncMap[string, *resource]),
+		logger:     slog.Default(),
+		protocol:   protocol.NewProtocol(nil),
+		transport:  transport,
+		tools:      new(datastructures.SyncMap[string, *tool]),
+		prompts:    new(datastructures.SyncMap[string, *prompt]),
+		resources:  new(datastructures.SyncMap[string, *resource]),
+		completers: new(datastructures.SyncMap[completionKey, Completer]),
+		sessions:   new(datastructures.SyncMap[string, *Session]),
 	}
 	for _, option := range options {
 		option(server)
 	}
+	server.session = newSession(server, server.protocol, transport)
 	return server
 }
 
 // RegisterTool registers a new tool with the server
-func (s *Server) RegisterTool(name string, description string, handler any) error {
+// The handler takes an optional context.Context followed by a struct of arguments.
+// It either returns a *ToolResponse and an error, or a struct and an error, in which case the struct is sent to the client
+// as structured content alongside a JSON text fallback and an output schema is generated for it.
+// Options can be passed to attach a title and behavioural hints (read only, destructive etc.) to the tool
+func (s *Server) RegisterTool(name string, description string, handler any, options ...ToolOptions) error {
 	err := validateToolHandler(handler)
 	if err != nil {
 		return err
 	}
-	inputSchema := createJsonSchemaFromHandler(handler)
+	handlerValue := reflect.ValueOf(handler)
+	var outputType reflect.Type
+	if handlerValue.Type().Out(0) != toolResponseType {
+		outputType = handlerValue.Type().Out(0)
+	}
+	argumentType := handlerValue.Type().In(handlerValue.Type().NumIn() - 1)
+	return s.registerTypedTool(name, description, argumentType, outputType, func(ctx context.Context, arguments any) (any, error) {
+		return callReflectedHandler(handlerValue, ctx, arguments)
+	}, options)
+}
 
-	s.tools.Store(name, &tool{
-		Name:            name,
-		Description:     description,
-		Handler:         createWrappedToolHandler(handler),
-		ToolInputSchema: inputSchema,
-	})
+// RegisterToolWithSchema registers a new tool whose input schema is given as JSON rather than generated from a go struct.
+// This is for tools that are defined at runtime, e.g. from external definitions.
+// The schema must be a JSON schema object with type "object", it is checked when the tool is registered and listed to
+// clients exactly as given. Arguments are validated against it before the handler is called with them as raw JSON.
+func (s *Server) RegisterToolWithSchema(name string, description string, inputSchema json.RawMessage, handler func(context.Context, json.RawMessage) (*ToolResponse, error), options ...ToolOptions) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	schema, err := parseToolInputSchema(inputSchema)
+	if err != nil {
+		return err
+	}
+	return s.registerTool(&tool{
+		Name:        name,
+		Description: description,
+		Handler: func(ctx context.Context, arguments baseCallToolRequestParams) *toolResponseSent {
+			response, err := handler(ctx, arguments.Arguments)
+			if err != nil {
+				return newToolResponseSentError(err)
+			}
+			if response == nil {
+				return newToolResponseSentError(fmt.Errorf("handler returned a nil response"))
+			}
+			return newToolResponseSent(response)
+		},
+		ToolInputSchema: schema,
+		// Copied so that callers reusing the slice can't change the schema listed to clients
+		rawInputSchema: slices.Clone(inputSchema),
+	}, options)
+}
+
+// registerTool is shared by the reflection based and the generic registration functions
+func (s *Server) registerTool(t *tool, options []ToolOptions) error {
+	for _, option := range options {
+		option(t)
+	}
+	s.tools.Store(t.Name, t)
 
 	return s.sendToolListChangedNotification()
 }
 
-func (s *Server) sendToolListChangedNotification() error {
-	if !s.isRunning {
-		return nil
+// GetToolAnnotations returns the title and behavioural hints a tool was registered with.
+// They don't depend on the protocol version of any session: the title is included even though clients of 2025-06-18
+// and later are sent it outside of the annotations.
+// The second return value is false if no tool with the given name is registered.
+func (s *Server) GetToolAnnotations(name string) (*ToolAnnotations, bool) {
+	t, ok := s.tools.Load(name)
+	if !ok {
+		return nil, false
+	}
+	if t.Annotations == nil && t.Title == nil {
+		return nil, true
 	}
-	return s.protocol.Notification("notifications/tools/list_changed", nil)
+	annotations := ToolAnnotations{}
+	if t.Annotations != nil {
+		annotations = *t.Annotations
+	}
+	if annotations.Title == nil {
+		annotations.Title = t.Title
+	}
+	return &annotations, true
+}
+
+func (s *Server) sendToolListChangedNotification() error {
+	return s.broadcast("notifications/tools/list_changed", nil)
 }
 
 func (s *Server) CheckToolRegistered(name string) bool {
@@ -729,26 +879,27 @@ func (s *Server) DeregisterTool(name string) error {
 	return s.sendToolListChangedNotification()
 }
 
+// RegisterResource registers a new resource with the server
+// The handler takes an optional context.Context and returns a *ResourceResponse and an error
 func (s *Server) RegisterResource(uri string, name string, description string, mimeType string, handler any) error {
 	err := validateResourceHandler(handler)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	s.resources.Store(uri, &resource{
-		Name:        name,
-		Description: description,
-		Uri:         uri,
-		mimeType:    mimeType,
-		Handler:     createWrappedResourceHandler(handler),
+	handlerValue := reflect.ValueOf(handler)
+	return RegisterResource(s, uri, name, description, mimeType, func(ctx context.Context) (*ResourceResponse, error) {
+		response, err := callReflectedHandler(handlerValue, ctx, nil)
+		return response.(*ResourceResponse), err
 	})
+}
+
+func (s *Server) registerResource(r *resource) error {
+	s.resources.Store(r.Uri, r)
 	return s.sendResourceListChangedNotification()
 }
 
 func (s *Server) sendResourceListChangedNotification() error {
-	if !s.isRunning {
-		return nil
-	}
-	return s.protocol.Notification("notifications/resources/list_changed", nil)
+	return s.broadcast("notifications/resources/list_changed", nil)
 }
 
 func (s *Server) CheckResourceRegistered(uri string) bool {
@@ -761,71 +912,106 @@ func (s *Server) DeregisterResource(uri string) error {
 	return s.sendResourceListChangedNotification()
 }
 
-func createWrappedResourceHandler(userHandler any) func() *resourceResponseSent {
-	handlerValue := reflect.ValueOf(userHandler)
-	return func() *resourceResponseSent {
-		// Call the handler with no arguments
-		output := handlerValue.Call([]reflect.Value{})
-
-		if len(output) != 2 {
-			return newResourceResponseSentError(fmt.Errorf("handler must return exactly two values, got %d", len(output)))
-		}
-
-		if !output[0].CanInterface() {
-			return newResourceResponseSentError(fmt.Errorf("handler must return a struct, got %s", output[0].Type().Name()))
-		}
-		promptR := output[0].Interface()
-		if !output[1].CanInterface() {
-			return newResourceResponseSentError(fmt.Errorf("handler must return an error, got %s", output[1].Type().Name()))
-		}
-		errorOut := output[1].Interface()
-		if errorOut == nil {
-			return newResourceResponseSent(promptR.(*ResourceResponse))
-		}
-		return newResourceResponseSentError(errorOut.(error))
-	}
-}
-
-// We just want to check that handler takes no arguments and returns a ResourceResponse and an error
+// We just want to check that handler takes no arguments (or only a context) and returns a ResourceResponse and an error
 func validateResourceHandler(handler any) error {
 	handlerValue := reflect.ValueOf(handler)
 	handlerType := handlerValue.Type()
-	if handlerType.NumIn() != 0 {
-		return fmt.Errorf("handler must take no arguments, got %d", handlerType.NumIn())
+	if handlerType.Kind() != reflect.Func {
+		return fmt.Errorf("handler must be a function, got %s", handlerType.Kind())
+	}
+	if handlerType.NumIn() > 1 || (handlerType.NumIn() == 1 && handlerType.In(0) != contextType) {
+		return fmt.Errorf("handler must take no arguments or only a context.Context, got %d arguments", handlerType.NumIn())
 	}
 	if handlerType.NumOut() != 2 {
 		return fmt.Errorf("handler must return exactly two values, got %d", handlerType.NumOut())
 	}
-	//if handlerType.Out(0) != reflect.TypeOf((*ResourceResponse)(nil)).Elem() {
-	//	return fmt.Errorf("handler must return ResourceResponse, got %s", handlerType.Out(0).Name())
-	//}
-	//if handlerType.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
-	//	return fmt.Errorf("handler must return error, got %s", handlerType.Out(1).Name())
-	//}
+	if handlerType.Out(0) != resourceResponseType {
+		return fmt.Errorf("handler must return *ResourceResponse, got %s", handlerType.Out(0).String())
+	}
+	if handlerType.Out(1) != errorType {
+		return fmt.Errorf("handler must return error, got %s", handlerType.Out(1).String())
+	}
 	return nil
 }
 
+// RegisterPrompt registers a new prompt with the server
+// The handler takes an optional context.Context followed by a struct of arguments and returns a *PromptResponse and an error
 func (s *Server) RegisterPrompt(name string, description string, handler any) error {
 	err := validatePromptHandler(handler)
 	if err != nil {
 		return err
 	}
-	promptSchema := createPromptSchemaFromHandler(handler)
-	s.prompts.Store(name, &prompt{
-		Name:              name,
-		Description:       description,
-		Handler:           createWrappedPromptHandler(handler),
-		PromptInputSchema: promptSchema,
+	handlerValue := reflect.ValueOf(handler)
+	argumentType := handlerValue.Type().In(handlerValue.Type().NumIn() - 1)
+	return s.registerTypedPrompt(name, description, argumentType, func(ctx context.Context, arguments any) (*PromptResponse, error) {
+		response, err := callReflectedHandler(handlerValue, ctx, arguments)
+		return response.(*PromptResponse), err
 	})
+}
+
+// RegisterPromptWithArguments registers a new prompt whose arguments are given explicitly rather than taken from a go struct.
+// The handler is called with the arguments the client sent, after checking that every required argument is present
+// and that no unknown arguments were sent.
+func (s *Server) RegisterPromptWithArguments(name string, description string, arguments []PromptArgument, handler func(context.Context, map[string]string) (*PromptResponse, error)) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	schema := &promptSchema{Arguments: make([]promptSchemaArgument, 0, len(arguments))}
+	known := map[string]bool{}
+	for _, argument := range arguments {
+		if argument.Name == "" {
+			return fmt.Errorf("prompt arguments must have a name")
+		}
+		if known[argument.Name] {
+			return fmt.Errorf("duplicate prompt argument %s", argument.Name)
+		}
+		known[argument.Name] = true
+		required := argument.Required != nil && *argument.Required
+		schema.Arguments = append(schema.Arguments, promptSchemaArgument{
+			Name:        argument.Name,
+			Description: argument.Description,
+			Required:    &required,
+		})
+	}
+	return s.registerPrompt(&prompt{
+		Name:        name,
+		Description: description,
+		Handler: func(ctx context.Context, params baseGetPromptRequestParamsArguments) *promptResponseSent {
+			values, err := decodePromptArguments(params.Arguments)
+			if err != nil {
+				return newPromptResponseSentError(err)
+			}
+			for argument := range values {
+				if !known[argument] {
+					return newPromptResponseSentError(fmt.Errorf("unknown argument %s", argument))
+				}
+			}
+			for _, argument := range schema.Arguments {
+				if _, ok := values[argument.Name]; *argument.Required && !ok {
+					return newPromptResponseSentError(fmt.Errorf("missing required argument %s", argument.Name))
+				}
+			}
+			response, err := handler(ctx, values)
+			if err != nil {
+				return newPromptResponseSentError(err)
+			}
+			if response == nil {
+				return newPromptResponseSentError(fmt.Errorf("handler returned a nil response"))
+			}
+			return newPromptResponseSent(response)
+		},
+		PromptInputSchema: schema,
+	})
+}
+
+func (s *Server) registerPrompt(p *prompt) error {
+	s.prompts.Store(p.Name, p)
 
 	return s.sendPromptListChangedNotification()
 }
 
 func (s *Server) sendPromptListChangedNotification() error {
-	if !s.isRunning {
-		return nil
-	}
-	return s.protocol.Notification("notifications/prompts/list_changed", nil)
+	return s.broadcast("notifications/prompts/list_changed", nil)
 }
 
 func (s *Server) CheckPromptRegistered(name string) bool {
@@ -838,180 +1024,398 @@ func (s *Server) DeregisterPrompt(name string) error {
 	return s.sendPromptListChangedNotification()
 }
 
-func createWrappedPromptHandler(userHandler any) func(baseGetPromptRequestParamsArguments) *promptResponseSent {
-	handlerValue := reflect.ValueOf(userHandler)
-	handlerType := handlerValue.Type()
-	argumentType := handlerType.In(0)
-	return func(arguments baseGetPromptRequestParamsArguments) *promptResponseSent {
-		// Instantiate a struct of the type of the arguments
-		if !reflect.New(argumentType).CanInterface() {
-			return newPromptResponseSentError(fmt.Errorf("arguments must be a struct"))
+// Prompt arguments are always a JSON object of strings, an absent object means no arguments were given.
+// Numbers and booleans are accepted too, some clients send those for arguments that look like them.
+func decodePromptArguments(arguments json.RawMessage) (map[string]string, error) {
+	values := map[string]string{}
+	if len(arguments) == 0 || string(arguments) == "null" {
+		return values, nil
+	}
+	var raw map[string]interface{}
+	err := json.Unmarshal(arguments, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+	for name, value := range raw {
+		switch typed := value.(type) {
+		case string:
+			values[name] = typed
+		case float64, bool:
+			values[name] = fmt.Sprint(typed)
+		case nil:
+		default:
+			return nil, fmt.Errorf("argument %s must be a string", name)
 		}
-		unmarshaledArguments := reflect.New(argumentType).Interface()
+	}
+	return values, nil
+}
 
-		// Unmarshal the JSON into the correct type
-		err := json.Unmarshal(arguments.Arguments, &unmarshaledArguments)
+// Sets the fields of the struct pointed to by target from the string values of the arguments, converting them to the
+// type of each field
+func bindPromptArguments(values map[string]string, target reflect.Value) error {
+	for _, field := range promptArgumentFields(target.Elem().Type()) {
+		value, ok := values[field.name]
+		if !ok {
+			if field.required {
+				return fmt.Errorf("missing required argument %s", field.name)
+			}
+			continue
+		}
+		if len(field.enum) > 0 && !slices.Contains(field.enum, value) {
+			return fmt.Errorf("argument %s must be one of %s, got %q", field.name, strings.Join(field.enum, ", "), value)
+		}
+		fieldValue := target.Elem().Field(field.index)
+		if fieldValue.Kind() == reflect.Ptr {
+			fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			fieldValue = fieldValue.Elem()
+		}
+		err := setPromptArgument(fieldValue, value)
 		if err != nil {
-			return newPromptResponseSentError(fmt.Errorf("failed to unmarshal arguments: %w", err))
+			return fmt.Errorf("argument %s %w, got %q", field.name, err, value)
 		}
+	}
+	return nil
+}
 
-		// Need to dereference the unmarshaled arguments
-		of := reflect.ValueOf(unmarshaledArguments)
-		if of.Kind() != reflect.Ptr || !of.Elem().CanInterface() {
-			return newPromptResponseSentError(fmt.Errorf("arguments must be a struct"))
+func setPromptArgument(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
 		}
-		// Call the handler with the typed arguments
-		output := handlerValue.Call([]reflect.Value{of.Elem()})
-
-		if len(output) != 2 {
-			return newPromptResponseSentError(fmt.Errorf("handler must return exactly two values, got %d", len(output)))
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be an integer between %d and %d", int64(-1)<<(field.Type().Bits()-1), int64(1)<<(field.Type().Bits()-1)-1)
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a non-negative integer of at most %d bits", field.Type().Bits())
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
 		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("has unsupported type %s", field.Type())
+	}
+	return nil
+}
 
-		if !output[0].CanInterface() {
-			return newPromptResponseSentError(fmt.Errorf("handler must return a struct, got %s", output[0].Type().Name()))
+type promptArgumentField struct {
+	index       int
+	name        string
+	description *string
+	required    bool
+	enum        []string
+}
+
+// The arguments of a prompt are the exported fields of its argument struct, named after their json tags
+func promptArgumentFields(argumentType reflect.Type) []promptArgumentField {
+	fields := make([]promptArgumentField, 0, argumentType.NumField())
+	for i := 0; i < argumentType.NumField(); i++ {
+		field := argumentType.Field(i)
+		if !field.IsExported() {
+			continue
 		}
-		promptR := output[0].Interface()
-		if !output[1].CanInterface() {
-			return newPromptResponseSentError(fmt.Errorf("handler must return an error, got %s", output[1].Type().Name()))
+		name := field.Name
+		if jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ","); jsonName == "-" {
+			continue
+		} else if jsonName != "" {
+			name = jsonName
 		}
-		errorOut := output[1].Interface()
-		if errorOut == nil {
-			return newPromptResponseSent(promptR.(*PromptResponse))
+
+		argument := promptArgumentField{index: i, name: name}
+		for _, tag := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+			if strings.HasPrefix(tag, "description=") {
+				s := strings.TrimPrefix(tag, "description=")
+				argument.description = &s
+			}
+			if tag == "required" {
+				argument.required = true
+			}
+			if strings.HasPrefix(tag, "enum=") {
+				argument.enum = append(argument.enum, strings.TrimPrefix(tag, "enum="))
+			}
 		}
-		return newPromptResponseSentError(errorOut.(error))
+		fields = append(fields, argument)
 	}
+	return fields
 }
 
 // Get the argument and iterate over the fields, we pull description from the jsonschema description tag
 // We pull required from the jsonschema required tag
+// Any enum values in the jsonschema tag are kept so that they can be offered as completions
 // Example:
 // type Content struct {
 // Title       string  `json:"title" jsonschema:"description=The title to submit,required"`
 // Description *string `json:"description" jsonschema:"description=The description to submit"`
 // }
 // Then we get the jsonschema for the struct where Title is a required field and Description is an optional field
-func createPromptSchemaFromHandler(handler any) *promptSchema {
-	handlerValue := reflect.ValueOf(handler)
-	handlerType := handlerValue.Type()
-	argumentType := handlerType.In(0)
-
+func createPromptSchemaFromType(argumentType reflect.Type) *promptSchema {
+	fields := promptArgumentFields(argumentType)
 	promptSchema := promptSchema{
-		Arguments: make([]promptSchemaArgument, argumentType.NumField()),
+		Arguments: make([]promptSchemaArgument, len(fields)),
 	}
-
-	for i := 0; i < argumentType.NumField(); i++ {
-		field := argumentType.Field(i)
-		fieldName := field.Name
-
-		jsonSchemaTags := strings.Split(field.Tag.Get("jsonschema"), ",")
-		var description *string
-		var required = false
-		for _, tag := range jsonSchemaTags {
-			if strings.HasPrefix(tag, "description=") {
-				s := strings.TrimPrefix(tag, "description=")
-				description = &s
-			}
-			if tag == "required" {
-				required = true
-			}
-		}
-
+	for i, field := range fields {
+		required := field.required
 		promptSchema.Arguments[i] = promptSchemaArgument{
-			Name:        fieldName,
-			Description: description,
+			Name:        field.name,
+			Description: field.description,
 			Required:    &required,
+			enum:        field.enum,
 		}
 	}
 	return &promptSchema
 }
 
-// A prompt can only take a struct with fields of type string or *string as the argument
+// A prompt takes a struct whose fields are strings, booleans or numbers, or pointers to them, as the argument
 func validatePromptHandler(handler any) error {
 	handlerValue := reflect.ValueOf(handler)
 	handlerType := handlerValue.Type()
-	argumentType := handlerType.In(0)
+	if handlerType.Kind() != reflect.Func {
+		return fmt.Errorf("handler must be a function, got %s", handlerType.Kind())
+	}
+	if handlerType.NumIn() != 1 && handlerType.NumIn() != 2 {
+		return fmt.Errorf("handler must take exactly one argument, optionally preceded by a context.Context, got %d", handlerType.NumIn())
+	}
+	if handlerType.NumIn() == 2 && handlerType.In(0) != contextType {
+		return fmt.Errorf("handler's first argument must be context.Context, got %s", handlerType.In(0).Name())
+	}
+	if handlerType.NumOut() != 2 {
+		return fmt.Errorf("handler must return exactly two values, got %d", handlerType.NumOut())
+	}
+	if handlerType.Out(0) != promptResponseType {
+		return fmt.Errorf("handler must return *PromptResponse, got %s", handlerType.Out(0).String())
+	}
+	if handlerType.Out(1) != errorType {
+		return fmt.Errorf("handler must return error, got %s", handlerType.Out(1).String())
+	}
+	return validatePromptArgumentType(handlerType.In(handlerType.NumIn() - 1))
+}
 
+func validatePromptArgumentType(argumentType reflect.Type) error {
 	if argumentType.Kind() != reflect.Struct {
 		return fmt.Errorf("argument must be a struct")
 	}
 
-	for i := 0; i < argumentType.NumField(); i++ {
-		field := argumentType.Field(i)
-		isValid := false
-		if field.Type.Kind() == reflect.String {
-			isValid = true
-		}
-		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.String {
-			isValid = true
+	// MCP sends every prompt argument as a string, only types that can be parsed from one are allowed
+	for _, field := range promptArgumentFields(argumentType) {
+		fieldType := argumentType.Field(field.index).Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
 		}
-		if !isValid {
-			return fmt.Errorf("all fields of the struct must be of type string or *string, found %s", field.Type.Kind())
+		switch fieldType.Kind() {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("argument %s must be a string, boolean or number, or a pointer to one, found %s", field.name, fieldType)
 		}
 	}
 	return nil
 }
 
-// Creates a full JSON schema from a user provided handler by introspecting the arguments
-func createJsonSchemaFromHandler(handler any) *jsonschema.Schema {
-	handlerValue := reflect.ValueOf(handler)
-	handlerType := handlerValue.Type()
-	argumentType := handlerType.In(0)
-	inputSchema := jsonSchemaReflector.ReflectFromType(argumentType)
-	return inputSchema
+// Calls a handler the Register methods were given, after checking its signature. It is passed ctx if it takes a
+// context, followed by the struct arguments points to unless arguments is nil.
+func callReflectedHandler(handler reflect.Value, ctx context.Context, arguments any) (any, error) {
+	var in []reflect.Value
+	if handler.Type().NumIn() > 0 && handler.Type().In(0) == contextType {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	if arguments != nil {
+		in = append(in, reflect.ValueOf(arguments).Elem())
+	}
+	output := handler.Call(in)
+	err, _ := output[1].Interface().(error)
+	return output[0].Interface(), err
 }
 
-// This takes a user provided handler and returns a wrapped handler which can be used to actually answer requests
-// Concretely, it will deserialize the arguments and call the user provided handler and then serialize the response
-// If the handler returns an error, it will be serialized and sent back as a tool error rather than a protocol error
-func createWrappedToolHandler(userHandler any) func(baseCallToolRequestParams) *toolResponseSent {
-	handlerValue := reflect.ValueOf(userHandler)
-	handlerType := handlerValue.Type()
-	argumentType := handlerType.In(0)
-	return func(arguments baseCallToolRequestParams) *toolResponseSent {
-		// Instantiate a struct of the type of the arguments
-		if !reflect.New(argumentType).CanInterface() {
-			return newToolResponseSentError(fmt.Errorf("arguments must be a struct"))
-		}
-		unmarshaledArguments := reflect.New(argumentType).Interface()
+// Tool arguments are a JSON object, an absent object means no arguments were given
+func decodeToolArguments(arguments json.RawMessage, v any) error {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		return nil
+	}
+	return json.Unmarshal(arguments, v)
+}
 
-		// Unmarshal the JSON into the correct type
-		err := json.Unmarshal(arguments.Arguments, &unmarshaledArguments)
-		if err != nil {
-			return newToolResponseSentError(fmt.Errorf("failed to unmarshal arguments: %w", err))
-		}
+// Serve starts serving clients over the transport the server was created with and returns straight away, see ServeContext
+// to block until the server stops
+func (s *Server) Serve() error {
+	_, err := s.serve()
+	return err
+}
 
-		// Need to dereference the unmarshaled arguments
-		of := reflect.ValueOf(unmarshaledArguments)
-		if of.Kind() != reflect.Ptr || !of.Elem().CanInterface() {
-			return newToolResponseSentError(fmt.Errorf("arguments must be a struct"))
-		}
-		// Call the handler with the typed arguments
-		output := handlerValue.Call([]reflect.Value{of.Elem()})
+// ServeContext serves the server like Serve, but blocks until the transport the server was created with closes or the
+// server is shut down, and then returns nil.
+// If ctx is cancelled first the server is shut down straight away, cancelling the requests being handled, and ctx's
+// error is returned. Call Shutdown to stop gracefully.
+func (s *Server) ServeContext(ctx context.Context) error {
+	stopped, err := s.serve()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		now, cancel := context.WithCancel(context.Background())
+		cancel()
+		_ = s.Shutdown(now)
+		return ctx.Err()
+	}
+}
 
-		if len(output) != 2 {
-			return newToolResponseSentError(fmt.Errorf("handler must return exactly two values, got %d", len(output)))
+// Starts serving the transport the server was created with, the returned channel is closed when it closes
+func (s *Server) serve() (<-chan struct{}, error) {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("server is shut down")
+	}
+	if s.isRunning {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("server is already running")
+	}
+	s.isRunning = true
+	wasServed := s.served
+	s.served = true
+	session := s.session
+	s.mu.Unlock()
+
+	// Once the transport closes the server can be served again, e.g. when the host reconnects
+	done := make(chan struct{})
+	var once sync.Once
+	stopped := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.isRunning = false
+			s.session = newSession(s, s.protocol, s.transport)
+			s.mu.Unlock()
+			close(done)
+		})
+	}
+	var err error
+	if listener, ok := s.transport.(transport.SessionListener); ok {
+		err = s.listen(listener, stopped)
+	} else {
+		err = s.connect(session, stopped)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.isRunning = false
+		s.served = wasServed
+		s.mu.Unlock()
+		return nil, err
+	}
+	return done, nil
+}
+
+// Shutdown stops the server gracefully. Sessions stop accepting requests, and the requests being handled are waited for
+// until ctx is done, when they are cancelled and ctx's error is returned. Every transport is then closed, the one the
+// server was created with and those added with Attach, after writing out the messages handlers already sent. The
+// transport the server was created with is closed even if it was never served.
+// The server can't be served again once it has been shut down.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	listeners := slices.Clone(s.listeners)
+	unserved := !s.served && s.transport != nil
+	s.mu.Unlock()
+
+	sessions := s.Sessions()
+	drained := make(chan error, len(sessions))
+	for _, session := range sessions {
+		go func() {
+			drained <- session.protocol.Drain(ctx)
+		}()
+	}
+	var drainErr error
+	for range sessions {
+		if err := <-drained; err != nil {
+			drainErr = err
 		}
+	}
 
-		if !output[0].CanInterface() {
-			return newToolResponseSentError(fmt.Errorf("handler must return a struct, got %s", output[0].Type().Name()))
+	errs := []error{drainErr}
+	if unserved {
+		if err := s.transport.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close transport: %w", err))
 		}
-		tool := output[0].Interface()
-		if !output[1].CanInterface() {
-			return newToolResponseSentError(fmt.Errorf("handler must return an error, got %s", output[1].Type().Name()))
+	}
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close transport: %w", err))
 		}
-		errorOut := output[1].Interface()
-		if errorOut == nil {
-			return newToolResponseSent(tool.(*ToolResponse))
+	}
+	for _, session := range sessions {
+		if err := session.protocol.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close session %s: %w", session.id, err))
 		}
-		return newToolResponseSentError(errorOut.(error))
 	}
+	return errors.Join(errs...)
 }
 
-func (s *Server) Serve() error {
-	if s.isRunning == true {
-		return fmt.Errorf("server is already running")
+// Attach starts serving clients over another transport, alongside the one the server was created with, e.g. an HTTP
+// listener next to stdio. It can be called at any time, whether or not the server is being served.
+// Closing the transport only ends the sessions it carries.
+func (s *Server) Attach(tr transport.Transport) error {
+	if s.isShutdown() {
+		return fmt.Errorf("server is shut down")
+	}
+	if listener, ok := tr.(transport.SessionListener); ok {
+		return s.listen(listener, nil)
+	}
+	return s.connect(newSession(s, protocol.NewProtocol(nil), tr), nil)
+}
+
+func (s *Server) isShutdown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+// Starts a session for every client that connects to a transport that carries many sessions, such as an HTTP server
+func (s *Server) listen(listener transport.SessionListener, onClose func()) error {
+	listener.SetSessionHandler(func(tr transport.Transport) {
+		if s.isShutdown() {
+			_ = tr.Close()
+			return
+		}
+		err := s.connect(newSession(s, protocol.NewProtocol(nil), tr), nil)
+		if err != nil {
+			s.logger.Error("failed to start session", "error", err)
+		}
+	})
+	if onClose != nil {
+		listener.SetCloseHandler(onClose)
+	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+	return listener.Start(context.Background())
+}
+
+// Starts serving a session. The registries are shared by every session, while each session has its own protocol and so
+// its own request ids, client info, log level and subscriptions. onClose is called once the session's transport closes.
+func (s *Server) connect(session *Session, onClose func()) error {
+	pr := session.protocol
+	if pr.OnError == nil {
+		pr.OnError = func(err error) {
+			s.logger.Error("protocol error", "session", session.id, "error", err)
+		}
 	}
-	pr := s.protocol
 	pr.SetRequestHandler("ping", s.handlePing)
 	pr.SetRequestHandler("initialize", s.handleInitialize)
 	pr.SetRequestHandler("tools/list", s.handleListTools)
@@ -1020,21 +1424,49 @@ func (s *Server) Serve() error {
 	pr.SetRequestHandler("prompts/get", s.handlePromptCalls)
 	pr.SetRequestHandler("resources/list", s.handleListResources)
 	pr.SetRequestHandler("resources/read", s.handleResourceCalls)
-	err := pr.Connect(s.transport)
+	pr.SetRequestHandler("resources/subscribe", s.handleSubscribe)
+	pr.SetRequestHandler("resources/unsubscribe", s.handleUnsubscribe)
+	pr.SetRequestHandler("completion/complete", s.handleComplete)
+	pr.SetRequestHandler("logging/setLevel", s.handleSetLogLevel)
+	s.sessions.Store(session.id, session)
+	err := pr.Connect(&closeHookTransport{Transport: session.transport, onClose: func() {
+		if onClose != nil {
+			onClose()
+		}
+		s.sessions.Delete(session.id)
+	}})
 	if err != nil {
+		s.sessions.Delete(session.id)
 		return err
 	}
-	s.protocol = pr
-	s.isRunning = true
 	return nil
 }
 
-func (s *Server) handleInitialize(_ *transport.BaseJSONRPCRequest, _ protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+func (s *Server) handleInitialize(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	type initializeRequestParams struct {
+		ProtocolVersion string             `json:"protocolVersion"`
+		Capabilities    ClientCapabilities `json:"capabilities"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	var params initializeRequestParams
+	if len(request.Params) > 0 {
+		err := json.Unmarshal(request.Params, &params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+		}
+	}
+	session := s.sessionFor(extra.Context)
+	session.setIdentity(extra.Identity)
+	session.initialize(negotiateProtocolVersion(params.ProtocolVersion), params.Capabilities, Implementation{Name: params.ClientInfo.Name, Version: params.ClientInfo.Version})
+
 	return initializeResult{
 		Meta:            nil,
-		Capabilities:    s.generateCapabilities(),
+		Capabilities:    s.generateCapabilities(session),
 		Instructions:    s.serverInstructions,
-		ProtocolVersion: "2024-11-05",
+		ProtocolVersion: session.ProtocolVersion(),
 		ServerInfo: implementation{
 			Name:    s.serverName,
 			Version: s.serverVersion,
@@ -1042,7 +1474,7 @@ func (s *Server) handleInitialize(_ *transport.BaseJSONRPCRequest, _ protocol.Re
 	}, nil
 }
 
-func (s *Server) handleListTools(request *transport.BaseJSONRPCRequest, _ protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+func (s *Server) handleListTools(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
 	type toolRequestParams struct {
 		Cursor *string `json:"cursor"`
 	}
@@ -1052,10 +1484,13 @@ func (s *Server) handleListTools(request *transport.BaseJSONRPCRequest, _ protoc
 		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
 	}
 
-	// Order by name for pagination
+	// Order by name for pagination, leaving out the tools the session isn't allowed to see
+	ctx := s.handlerContext(request, extra)
 	var orderedTools []*tool
 	s.tools.Range(func(k string, t *tool) bool {
-		orderedTools = append(orderedTools, t)
+		if s.allowed(ctx, FeatureKindTool, k) {
+			orderedTools = append(orderedTools, t)
+		}
 		return true
 	})
 	sort.Slice(orderedTools, func(i, j int) bool {
@@ -1093,12 +1528,27 @@ func (s *Server) handleListTools(request *transport.BaseJSONRPCRequest, _ protoc
 
 	toolsToReturn := make([]tools.ToolRetType, 0)
 
+	version := s.sessionFor(extra.Context).negotiatedProtocolVersion()
 	for i := startPosition; i < endPosition; i++ {
-		toolsToReturn = append(toolsToReturn, tools.ToolRetType{
+		toolToReturn := tools.ToolRetType{
 			Name:        orderedTools[i].Name,
 			Description: &orderedTools[i].Description,
 			InputSchema: orderedTools[i].ToolInputSchema,
-		})
+		}
+		if orderedTools[i].rawInputSchema != nil {
+			toolToReturn.InputSchema = orderedTools[i].rawInputSchema
+		}
+		// Titles and annotations were added in later versions of the protocol, only send them to clients that understand them
+		if protocolVersionAtLeast(version, protocolVersion20250618) {
+			toolToReturn.Title = orderedTools[i].Title
+			if orderedTools[i].ToolOutputSchema != nil {
+				toolToReturn.OutputSchema = orderedTools[i].ToolOutputSchema
+			}
+		}
+		if annotations := orderedTools[i].annotationsForVersion(version); annotations != nil {
+			toolToReturn.Annotations = annotations
+		}
+		toolsToReturn = append(toolsToReturn, toolToReturn)
 	}
 
 	return tools.ToolsResponse{
@@ -1113,7 +1563,29 @@ func (s *Server) handleListTools(request *transport.BaseJSONRPCRequest, _ protoc
 	}, nil
 }
 
-func (s *Server) handleToolCalls(req *transport.BaseJSONRPCRequest, _ protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+// annotationsForVersion returns the annotations to advertise for the tool, or nil if there are none or the version doesn't support them.
+// Clients that predate the top level tool title get it through the annotations instead.
+func (t *tool) annotationsForVersion(version string) *ToolAnnotations {
+	if !protocolVersionAtLeast(version, protocolVersion20250326) {
+		return nil
+	}
+	if t.Annotations == nil && t.Title == nil {
+		return nil
+	}
+	annotations := ToolAnnotations{}
+	if t.Annotations != nil {
+		annotations = *t.Annotations
+	}
+	if annotations.Title == nil && !protocolVersionAtLeast(version, protocolVersion20250618) {
+		annotations.Title = t.Title
+	}
+	if annotations == (ToolAnnotations{}) {
+		return nil
+	}
+	return &annotations
+}
+
+func (s *Server) handleToolCalls(req *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
 	params := baseCallToolRequestParams{}
 	// Instantiate a struct of the type of the arguments
 	err := json.Unmarshal(req.Params, &params)
@@ -1130,13 +1602,44 @@ func (s *Server) handleToolCalls(req *transport.BaseJSONRPCRequest, _ protocol.R
 		return false
 	})
 
-	if toolToUse == nil {
-		return nil, fmt.Errorf("unknown tool: %s", req.Method)
+	ctx := s.handlerContext(req, extra)
+	if toolToUse == nil || !s.allowed(ctx, FeatureKindTool, toolToUse.Name) {
+		return nil, unknownToolError(params.Name)
+	}
+	var response *toolResponseSent
+	// Arguments that don't match the schema never reach the handler, the model is told what's wrong so it can try again
+	mode := s.argumentDecoding
+	if toolToUse.argumentDecoding != nil {
+		mode = *toolToUse.argumentDecoding
+	}
+	var coercions []string
+	params.Arguments, coercions, err = validateToolArguments(toolToUse.ToolInputSchema, params.Arguments, mode)
+	for _, coercion := range coercions {
+		s.logger.Debug("coerced tool argument", "tool", toolToUse.Name, "coercion", coercion)
+	}
+	if err != nil {
+		response = newToolResponseSentError(err)
+	} else {
+		response = s.runTool(ctx, toolToUse, params)
+	}
+	version := s.sessionFor(ctx).negotiatedProtocolVersion()
+	// Structured content was added in 2025-06-18, older clients only get the text fallback
+	if !protocolVersionAtLeast(version, protocolVersion20250618) {
+		response.StructuredContent = nil
+	}
+	var meta map[string]interface{}
+	if response.Error == nil && response.Response != nil {
+		meta = response.Response.Meta
+		response.Response = &ToolResponse{
+			Content: contentForProtocolVersion(response.Response.Content, version),
+			IsError: response.Response.IsError,
+		}
 	}
-	return toolToUse.Handler(params), nil
+	response.Meta = RequestContextFromContext(ctx).resultMetaWith(meta)
+	return response, nil
 }
 
-func (s *Server) generateCapabilities() serverCapabilities {
+func (s *Server) generateCapabilities(session *Session) serverCapabilities {
 	t := false
 	return serverCapabilities{
 		Tools: func() *serverCapabilitiesTools {
@@ -1150,10 +1653,14 @@ func (s *Server) generateCapabilities() serverCapabilities {
 			}
 		}(),
 		Resources: func() *serverCapabilitiesResources {
+			subscribe := !session.Stateless()
 			return &serverCapabilitiesResources{
 				ListChanged: &t,
+				Subscribe:   &subscribe,
 			}
 		}(),
+		Completions: &serverCapabilitiesCompletions{},
+		Logging:     serverCapabilitiesLogging{},
 	}
 }
 
@@ -1167,10 +1674,13 @@ func (s *Server) handleListPrompts(request *transport.BaseJSONRPCRequest, extra
 		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
 	}
 
-	// Order by name for pagination
+	// Order by name for pagination, leaving out the prompts the session isn't allowed to see
+	ctx := s.handlerContext(request, extra)
 	var orderedPrompts []*prompt
 	s.prompts.Range(func(k string, p *prompt) bool {
-		orderedPrompts = append(orderedPrompts, p)
+		if s.allowed(ctx, FeatureKindPrompt, k) {
+			orderedPrompts = append(orderedPrompts, p)
+		}
 		return true
 	})
 	sort.Slice(orderedPrompts, func(i, j int) bool {
@@ -1230,10 +1740,13 @@ func (s *Server) handleListResources(request *transport.BaseJSONRPCRequest, extr
 		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
 	}
 
-	// Order by URI for pagination
+	// Order by URI for pagination, leaving out the resources the session isn't allowed to see
+	ctx := s.handlerContext(request, extra)
 	var orderedResources []*resource
 	s.resources.Range(func(k string, r *resource) bool {
-		orderedResources = append(orderedResources, r)
+		if s.allowed(ctx, FeatureKindResource, k) {
+			orderedResources = append(orderedResources, r)
+		}
 		return true
 	})
 	sort.Slice(orderedResources, func(i, j int) bool {
@@ -1305,10 +1818,29 @@ func (s *Server) handlePromptCalls(req *transport.BaseJSONRPCRequest, extra prot
 		return false
 	})
 
-	if promptToUse == nil {
-		return nil, fmt.Errorf("unknown prompt: %s", req.Method)
+	ctx := s.handlerContext(req, extra)
+	if promptToUse == nil || !s.allowed(ctx, FeatureKindPrompt, promptToUse.Name) {
+		return nil, unknownPromptError(params.Name)
 	}
-	return promptToUse.Handler(params), nil
+	var response *promptResponseSent
+	err = s.recoverHandler("prompt", promptToUse.Name, func() {
+		response = promptToUse.Handler(ctx, params)
+	})
+	if err != nil {
+		response = newPromptResponseSentError(err)
+	}
+	var meta map[string]interface{}
+	if response.Error == nil && response.Response != nil {
+		meta = response.Response.Meta
+		version := s.sessionFor(ctx).negotiatedProtocolVersion()
+		messages := make([]*PromptMessage, 0, len(response.Response.Messages))
+		for _, message := range response.Response.Messages {
+			messages = append(messages, NewPromptMessage(singleContentForProtocolVersion(message.Content, version), message.Role))
+		}
+		response.Response = &PromptResponse{Description: response.Response.Description, Messages: messages}
+	}
+	response.Meta = RequestContextFromContext(ctx).resultMetaWith(meta)
+	return response, nil
 }
 
 func (s *Server) handleResourceCalls(req *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
@@ -1328,35 +1860,124 @@ func (s *Server) handleResourceCalls(req *transport.BaseJSONRPCRequest, extra pr
 		return false
 	})
 
-	if resourceToUse == nil {
-		return nil, fmt.Errorf("unknown prompt: %s", req.Method)
+	ctx := s.handlerContext(req, extra)
+	if resourceToUse == nil || !s.allowed(ctx, FeatureKindResource, resourceToUse.Uri) {
+		return nil, unknownResourceError(params.Uri)
+	}
+	var response *resourceResponseSent
+	err = s.recoverHandler("resource", resourceToUse.Uri, func() {
+		response = resourceToUse.Handler(ctx)
+	})
+	if err != nil {
+		response = newResourceResponseSentError(err)
+	}
+	var meta map[string]interface{}
+	if response.Error == nil && response.Response != nil {
+		meta = response.Response.Meta
 	}
-	return resourceToUse.Handler(), nil
+	response.Meta = RequestContextFromContext(ctx).resultMetaWith(meta)
+	return response, nil
+}
+
+func (s *Server) handleComplete(req *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	params := baseCompleteRequestParams{}
+	err := json.Unmarshal(req.Params, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+
+	key := completionKey{refType: params.Ref.Type, argument: params.Argument.Name}
+	switch params.Ref.Type {
+	case completionRefTypePrompt:
+		key.ref = params.Ref.Name
+	case completionRefTypeResource:
+		key.ref = params.Ref.Uri
+	default:
+		return nil, fmt.Errorf("unknown completion reference type: %s", params.Ref.Type)
+	}
+
+	ctx := s.handlerContext(req, extra)
+	// Prompts and resources the session isn't allowed to see can't be completed either
+	if params.Ref.Type == completionRefTypePrompt && !s.allowed(ctx, FeatureKindPrompt, params.Ref.Name) {
+		return nil, unknownPromptError(params.Ref.Name)
+	}
+	if params.Ref.Type == completionRefTypeResource && !s.allowed(ctx, FeatureKindResource, params.Ref.Uri) {
+		return nil, unknownResourceError(params.Ref.Uri)
+	}
+
+	// A registered completer always wins
+	if completer, ok := s.completers.Load(key); ok {
+		if params.Context != nil && params.Context.Arguments != nil {
+			ctx = context.WithValue(ctx, completionArgumentsContextKey{}, params.Context.Arguments)
+		}
+		candidates, err := completer(ctx, params.Argument.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to complete argument %s: %w", params.Argument.Name, err)
+		}
+		return newCompleteResult(candidates, params.Argument.Value), nil
+	}
+
+	// Otherwise fall back to the enum values declared on the prompt arguments
+	if params.Ref.Type == completionRefTypePrompt {
+		p, ok := s.prompts.Load(params.Ref.Name)
+		if !ok {
+			return nil, unknownPromptError(params.Ref.Name)
+		}
+		for _, argument := range p.PromptInputSchema.Arguments {
+			if argument.Name == params.Argument.Name {
+				return newCompleteResult(argument.enum, params.Argument.Value), nil
+			}
+		}
+	}
+
+	return newCompleteResult(nil, params.Argument.Value), nil
 }
 
 func (s *Server) handlePing(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
 	return map[string]interface{}{}, nil
 }
 
+var (
+	contextType          = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType            = reflect.TypeOf((*error)(nil)).Elem()
+	toolResponseType     = reflect.PointerTo(reflect.TypeOf(ToolResponse{}))
+	promptResponseType   = reflect.PointerTo(reflect.TypeOf(PromptResponse{}))
+	resourceResponseType = reflect.PointerTo(reflect.TypeOf(ResourceResponse{}))
+)
+
 func validateToolHandler(handler any) error {
 	handlerValue := reflect.ValueOf(handler)
 	handlerType := handlerValue.Type()
 
-	if handlerType.NumIn() != 1 {
-		return fmt.Errorf("handler must take exactly one argument, got %d", handlerType.NumIn())
+	if handlerType.Kind() != reflect.Func {
+		return fmt.Errorf("handler must be a function, got %s", handlerType.Kind())
+	}
+
+	if handlerType.NumIn() != 1 && handlerType.NumIn() != 2 {
+		return fmt.Errorf("handler must take exactly one argument, optionally preceded by a context.Context, got %d", handlerType.NumIn())
+	}
+
+	if handlerType.NumIn() == 2 && handlerType.In(0) != contextType {
+		return fmt.Errorf("handler's first argument must be context.Context, got %s", handlerType.In(0).Name())
 	}
 
 	if handlerType.NumOut() != 2 {
 		return fmt.Errorf("handler must return exactly two values, got %d", handlerType.NumOut())
 	}
 
-	// Check that the output type is *tools.ToolResponse
-	if handlerType.Out(0) != reflect.PointerTo(reflect.TypeOf(ToolResponse{})) {
-		return fmt.Errorf("handler must return *tools.ToolResponse, got %s", handlerType.Out(0).Name())
+	// Check that the output type is *tools.ToolResponse or a struct to send as structured content
+	outputType := handlerType.Out(0)
+	if outputType != toolResponseType {
+		if outputType.Kind() == reflect.Ptr {
+			outputType = outputType.Elem()
+		}
+		if outputType.Kind() != reflect.Struct {
+			return fmt.Errorf("handler must return *tools.ToolResponse or a struct, got %s", handlerType.Out(0).Name())
+		}
 	}
 
 	// Check that the output type is error
-	if handlerType.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+	if handlerType.Out(1) != errorType {
 		return fmt.Errorf("handler must return error, got %s", handlerType.Out(1).Name())
 	}
 
@@ -1368,7 +1989,7 @@ var (
 		BaseSchemaID:               "",
 		Anonymous:                  true,
 		AssignAnchor:               false,
-		AllowAdditionalProperties:  true,
+		AllowAdditionalProperties:  false,
 		RequiredFromJSONSchemaTags: true,
 		DoNotReference:             true,
 		ExpandedStruct:             true,
@@ -1,12 +1,29 @@
 package mcp_golang
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/invopop/jsonschema"
 	"github.com/metoro-io/mcp-golang/internal/protocol"
 	"github.com/metoro-io/mcp-golang/internal/testingutils"
 	"github.com/metoro-io/mcp-golang/internal/tools"
 	"github.com/metoro-io/mcp-golang/transport"
+	"github.com/metoro-io/mcp-golang/transport/stdio"
+	"github.com/metoro-io/mcp-golang/transport/streamablehttp"
 )
 
 func TestServerListChangedNotifications(t *testing.T) {
@@ -536,3 +553,1939 @@ func TestHandleListResourcesPagination(t *testing.T) {
 		t.Error("Expected no next cursor when pagination is disabled")
 	}
 }
+
+func TestHandleComplete(t *testing.T) {
+	mockTransport := testingutils.NewMockTransport()
+	server := NewServer(mockTransport)
+	err := server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testPromptArgs struct {
+		Language string `json:"language" jsonschema:"required,enum=go,enum=golang,enum=python"`
+		Topic    string `json:"topic" jsonschema:"description=The topic"`
+	}
+	err = server.RegisterPrompt("test-prompt", "Test prompt", func(args testPromptArgs) (*PromptResponse, error) {
+		return NewPromptResponse("test", NewPromptMessage(NewTextContent("test"), RoleUser)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func(params string) CompleteResult {
+		t.Helper()
+		resp, err := server.handleComplete(&transport.BaseJSONRPCRequest{
+			Params: []byte(params),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, ok := resp.(CompleteResult)
+		if !ok {
+			t.Fatal("Expected CompleteResult")
+		}
+		return result
+	}
+
+	// Enum values from the jsonschema tags are filtered by prefix
+	result := complete(`{"ref":{"type":"ref/prompt","name":"test-prompt"},"argument":{"name":"language","value":"go"}}`)
+	if len(result.Completion.Values) != 2 || result.Completion.Values[0] != "go" || result.Completion.Values[1] != "golang" {
+		t.Errorf("Unexpected completion values: %v", result.Completion.Values)
+	}
+	if *result.Completion.Total != 2 || *result.Completion.HasMore {
+		t.Errorf("Unexpected total or hasMore: %d %v", *result.Completion.Total, *result.Completion.HasMore)
+	}
+
+	// Arguments without enum values have no completions
+	result = complete(`{"ref":{"type":"ref/prompt","name":"test-prompt"},"argument":{"name":"topic","value":""}}`)
+	if len(result.Completion.Values) != 0 {
+		t.Errorf("Expected no completion values, got %v", result.Completion.Values)
+	}
+
+	// Registered completers take precedence and are capped at 100 values
+	err = server.RegisterPromptArgumentCompleter("test-prompt", "topic", func(ctx context.Context, value string) ([]string, error) {
+		values := make([]string, 0, 150)
+		for i := 0; i < 150; i++ {
+			values = append(values, fmt.Sprintf("topic-%03d", i))
+		}
+		return values, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = complete(`{"ref":{"type":"ref/prompt","name":"test-prompt"},"argument":{"name":"topic","value":"topic-"}}`)
+	if len(result.Completion.Values) != 100 {
+		t.Errorf("Expected 100 completion values, got %d", len(result.Completion.Values))
+	}
+	if *result.Completion.Total != 150 || !*result.Completion.HasMore {
+		t.Errorf("Unexpected total or hasMore: %d %v", *result.Completion.Total, *result.Completion.HasMore)
+	}
+
+	// Resource template variables
+	err = server.RegisterResourceTemplateCompleter("file:///logs/{name}", "name", func(ctx context.Context, value string) ([]string, error) {
+		if CompletionArgumentsFromContext(ctx)["service"] == "db" {
+			return []string{"db.log"}, nil
+		}
+		return []string{"app.log", "access.log", "error.log"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = complete(`{"ref":{"type":"ref/resource","uri":"file:///logs/{name}"},"argument":{"name":"name","value":"a"}}`)
+	if len(result.Completion.Values) != 2 || result.Completion.Values[0] != "app.log" || result.Completion.Values[1] != "access.log" {
+		t.Errorf("Unexpected completion values: %v", result.Completion.Values)
+	}
+	// Completers see the arguments that were already filled in
+	result = complete(`{"ref":{"type":"ref/resource","uri":"file:///logs/{name}"},"argument":{"name":"name","value":""},"context":{"arguments":{"service":"db"}}}`)
+	if len(result.Completion.Values) != 1 || result.Completion.Values[0] != "db.log" {
+		t.Errorf("Unexpected completion values: %v", result.Completion.Values)
+	}
+
+	// Unknown prompts are an error
+	_, err = server.handleComplete(&transport.BaseJSONRPCRequest{
+		Params: []byte(`{"ref":{"type":"ref/prompt","name":"missing"},"argument":{"name":"topic","value":""}}`),
+	}, protocol.RequestHandlerExtra{})
+	if err == nil {
+		t.Error("Expected error for unknown prompt")
+	}
+}
+
+func TestHandleListToolsAnnotations(t *testing.T) {
+	mockTransport := testingutils.NewMockTransport()
+	server := NewServer(mockTransport)
+	err := server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testToolArgs struct {
+		Message string `json:"message" jsonschema:"required,description=A test message"`
+	}
+	err = server.RegisterTool("delete-tool", "Deletes things", func(args testToolArgs) (*ToolResponse, error) {
+		return NewToolResponse(), nil
+	}, WithToolTitle("Delete things"), WithReadOnlyHint(false), WithDestructiveHint(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, ok := server.GetToolAnnotations("delete-tool")
+	if !ok {
+		t.Fatal("Expected tool to be registered")
+	}
+	if annotations.DestructiveHint == nil || !*annotations.DestructiveHint || annotations.ReadOnlyHint == nil || *annotations.ReadOnlyHint {
+		t.Errorf("Unexpected annotations: %+v", annotations)
+	}
+	if annotations.Title == nil || *annotations.Title != "Delete things" {
+		t.Errorf("Expected title in annotations, got %v", annotations.Title)
+	}
+
+	listTools := func(protocolVersion string) tools.ToolRetType {
+		t.Helper()
+		_, err := server.handleInitialize(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"protocolVersion":"` + protocolVersion + `","capabilities":{}}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := server.handleListTools(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.(tools.ToolsResponse).Tools[0]
+	}
+
+	// The oldest protocol version knows nothing about titles or annotations
+	toolResp := listTools("2024-11-05")
+	if toolResp.Title != nil || toolResp.Annotations != nil {
+		t.Errorf("Expected no title or annotations, got %v %v", toolResp.Title, toolResp.Annotations)
+	}
+
+	// 2025-03-26 has annotations but no top level title
+	toolResp = listTools("2025-03-26")
+	if toolResp.Title != nil {
+		t.Errorf("Expected no title, got %v", *toolResp.Title)
+	}
+	if a, ok := toolResp.Annotations.(*ToolAnnotations); !ok || a.Title == nil || *a.Title != "Delete things" || !*a.DestructiveHint {
+		t.Errorf("Unexpected annotations: %v", toolResp.Annotations)
+	}
+
+	// 2025-06-18 has both
+	toolResp = listTools("2025-06-18")
+	if toolResp.Title == nil || *toolResp.Title != "Delete things" {
+		t.Errorf("Expected title, got %v", toolResp.Title)
+	}
+	if a, ok := toolResp.Annotations.(*ToolAnnotations); !ok || a.Title != nil || !*a.DestructiveHint {
+		t.Errorf("Unexpected annotations: %v", toolResp.Annotations)
+	}
+	// What the tool was registered with doesn't depend on the version clients negotiated
+	annotations, _ = server.GetToolAnnotations("delete-tool")
+	if annotations.Title == nil || *annotations.Title != "Delete things" {
+		t.Errorf("Expected title in annotations, got %v", annotations.Title)
+	}
+}
+
+func TestHandleToolCallsStructuredOutput(t *testing.T) {
+	mockTransport := testingutils.NewMockTransport()
+	server := NewServer(mockTransport)
+	err := server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type weatherArgs struct {
+		City string `json:"city" jsonschema:"required,description=The city"`
+	}
+	type weatherOutput struct {
+		Temperature float64 `json:"temperature" jsonschema:"required,description=Temperature in celsius"`
+		Conditions  string  `json:"conditions"`
+	}
+	err = server.RegisterTool("weather", "Get the weather", func(ctx context.Context, args weatherArgs) (weatherOutput, error) {
+		if ctx == nil {
+			t.Error("Expected a context")
+		}
+		return weatherOutput{Temperature: 21.5, Conditions: "sunny in " + args.City}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.RegisterTool("bad", "Returns an int", func(args weatherArgs) (int, error) {
+		return 0, nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a tool that returns a non struct")
+	}
+
+	_, err = server.handleInitialize(&transport.BaseJSONRPCRequest{
+		Params: []byte(`{"protocolVersion":"2025-06-18","capabilities":{}}`),
+	}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := server.handleListTools(&transport.BaseJSONRPCRequest{
+		Params: []byte(`{}`),
+	}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputSchema, ok := resp.(tools.ToolsResponse).Tools[0].OutputSchema.(*jsonschema.Schema)
+	if !ok {
+		t.Fatal("Expected an output schema")
+	}
+	if _, ok := outputSchema.Properties.Get("temperature"); !ok || len(outputSchema.Required) != 1 {
+		t.Errorf("Unexpected output schema: %+v", outputSchema)
+	}
+
+	callTool := func() map[string]interface{} {
+		t.Helper()
+		resp, err := server.handleToolCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"weather","arguments":{"city":"London"}}`),
+		}, protocol.RequestHandlerExtra{Context: context.Background()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result map[string]interface{}
+		err = json.Unmarshal(b, &result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := callTool()
+	structured, ok := result["structuredContent"].(map[string]interface{})
+	if !ok || structured["temperature"] != 21.5 || structured["conditions"] != "sunny in London" {
+		t.Errorf("Unexpected structured content: %v", result["structuredContent"])
+	}
+	text := result["content"].([]interface{})[0].(map[string]interface{})["text"]
+	if text != `{"temperature":21.5,"conditions":"sunny in London"}` {
+		t.Errorf("Unexpected text fallback: %v", text)
+	}
+
+	// Older clients only get the text fallback
+	_, err = server.handleInitialize(&transport.BaseJSONRPCRequest{
+		Params: []byte(`{"protocolVersion":"2024-11-05","capabilities":{}}`),
+	}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = callTool()
+	if _, ok := result["structuredContent"]; ok {
+		t.Error("Expected no structured content for old protocol versions")
+	}
+}
+
+func TestTypedRegistration(t *testing.T) {
+	mockTransport := testingutils.NewMockTransport()
+	server := NewServer(mockTransport)
+	err := server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type greetArgs struct {
+		Name string `json:"name" jsonschema:"required,description=Who to greet"`
+	}
+	type greetOutput struct {
+		Greeting string `json:"greeting"`
+	}
+	err = RegisterTool(server, "greet", "Greet someone", func(ctx context.Context, args greetArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent("Hello " + args.Name)), nil
+	}, WithReadOnlyHint(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterStructuredTool(server, "greet-structured", "Greet someone", func(ctx context.Context, args greetArgs) (*greetOutput, error) {
+		return &greetOutput{Greeting: "Hello " + args.Name}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "bad", "Takes a string", func(ctx context.Context, args string) (*ToolResponse, error) {
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a tool whose arguments aren't a struct")
+	}
+	// The reflection based methods share the generic core, so their handlers are checked and called in the same way
+	err = server.RegisterTool("greet-nil", "Greet no one", func(args greetArgs) (*ToolResponse, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterTool("bad", "Takes a string", func(args string) (*ToolResponse, error) {
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a tool whose arguments aren't a struct")
+	}
+	err = RegisterPrompt(server, "greet-prompt", "Greet someone", func(ctx context.Context, args greetArgs) (*PromptResponse, error) {
+		return NewPromptResponse("greeting", NewPromptMessage(NewTextContent("Hello "+args.Name), RoleUser)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterResource(server, "test://greeting", "greeting", "A greeting", "text/plain", func(ctx context.Context) (*ResourceResponse, error) {
+		return NewResourceResponse(NewTextEmbeddedResource("test://greeting", "Hello", "text/plain")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(handler func(*transport.BaseJSONRPCRequest, protocol.RequestHandlerExtra) (transport.JsonRpcBody, error), params string) string {
+		t.Helper()
+		resp, err := handler(&transport.BaseJSONRPCRequest{Params: []byte(params)}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(*transport.BaseJSONRPCRequest, protocol.RequestHandlerExtra) (transport.JsonRpcBody, error)
+		params   string
+		expected string
+	}{
+		{
+			name:     "tool",
+			handler:  server.handleToolCalls,
+			params:   `{"name":"greet","arguments":{"name":"Ada"}}`,
+			expected: `{"content":[{"text":"Hello Ada","type":"text"}],"isError":false}`,
+		},
+		{
+			name:     "tool without required arguments",
+			handler:  server.handleToolCalls,
+			params:   `{"name":"greet"}`,
+			expected: `{"content":[{"text":"invalid arguments:\n- /name: is required","type":"text"}],"isError":true}`,
+		},
+		{
+			name:     "reflected tool returning nil",
+			handler:  server.handleToolCalls,
+			params:   `{"name":"greet-nil","arguments":{"name":"Ada"}}`,
+			expected: `{"content":[{"text":"handler returned a nil response","type":"text"}],"isError":true}`,
+		},
+		{
+			name:     "prompt",
+			handler:  server.handlePromptCalls,
+			params:   `{"name":"greet-prompt","arguments":{"name":"Ada"}}`,
+			expected: `{"description":"greeting","messages":[{"content":{"text":"Hello Ada","type":"text"},"role":"user"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(tt.handler, tt.params); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	_, err = server.handleInitialize(&transport.BaseJSONRPCRequest{
+		Params: []byte(`{"protocolVersion":"2025-06-18","capabilities":{}}`),
+	}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := call(server.handleToolCalls, `{"name":"greet-structured","arguments":{"name":"Ada"}}`); got != `{"content":[{"text":"{\"greeting\":\"Hello Ada\"}","type":"text"}],"structuredContent":{"greeting":"Hello Ada"},"isError":false}` {
+		t.Errorf("Unexpected structured tool response: %s", got)
+	}
+	if got := call(server.handleResourceCalls, `{"uri":"test://greeting"}`); !strings.Contains(got, `"text":"Hello"`) {
+		t.Errorf("Unexpected resource response: %s", got)
+	}
+	if annotations, ok := server.GetToolAnnotations("greet"); !ok || annotations.ReadOnlyHint == nil || !*annotations.ReadOnlyHint {
+		t.Errorf("Expected the greet tool to be read only, got %+v", annotations)
+	}
+}
+
+func TestRegisterValidatesHandlers(t *testing.T) {
+	server := NewServer(testingutils.NewMockTransport())
+	type promptArgs struct {
+		Name string `json:"name"`
+	}
+
+	err := server.RegisterResource("test://a", "a", "A", "text/plain", func() (string, error) {
+		return "", nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a resource handler with the wrong return type")
+	}
+	err = server.RegisterResource("test://a", "a", "A", "text/plain", func(ctx context.Context) (*ResourceResponse, error) {
+		return NewResourceResponse(NewTextEmbeddedResource("test://a", "a", "text/plain")), nil
+	})
+	if err != nil {
+		t.Errorf("Expected resource handler taking a context to be accepted, got %v", err)
+	}
+	err = server.RegisterPrompt("p", "P", func(args promptArgs) (string, error) {
+		return "", nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a prompt handler with the wrong return type")
+	}
+	err = server.RegisterPrompt("p", "P", func(ctx context.Context, args promptArgs) (*PromptResponse, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Errorf("Expected prompt handler taking a context to be accepted, got %v", err)
+	}
+}
+
+func TestArgumentDecodingModes(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	server := NewServer(testingutils.NewMockTransport(), WithArgumentDecoding(ArgumentDecodingLenient), WithLogger(logger))
+
+	type countArgs struct {
+		Count int `json:"count" jsonschema:"required"`
+	}
+	handler := func(ctx context.Context, args countArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent(fmt.Sprint(args.Count))), nil
+	}
+	err := RegisterTool(server, "lenient", "Uses the server's mode", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "default", "Overrides the server's mode", handler, WithToolArgumentDecoding(ArgumentDecodingDefault))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callTool := func(name string) string {
+		t.Helper()
+		resp, err := server.handleToolCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"` + name + `","arguments":{"count":"5"}}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := callTool("lenient"); got != `{"content":[{"text":"5","type":"text"}],"isError":false}` {
+		t.Errorf("Expected the count to be coerced, got %s", got)
+	}
+	if !strings.Contains(logs.String(), `coercion="/count: string \"5\" to integer"`) {
+		t.Errorf("Expected the coercion to be logged, got %s", logs.String())
+	}
+	if got := callTool("default"); got != `{"content":[{"text":"invalid arguments:\n- /count: expected integer, got string","type":"text"}],"isError":true}` {
+		t.Errorf("Expected the count to be rejected, got %s", got)
+	}
+}
+
+func TestRegisterWithExplicitSchemas(t *testing.T) {
+	server := NewServer(testingutils.NewMockTransport())
+
+	inputSchema := `{"type":"object","properties":{"limit":{"type":["integer","null"],"minimum":1},"query":{"type":"string","minLength":1}},"required":["query"],"x-origin":"external"}`
+	// The caller reuses its buffer once the tool is registered
+	buffer := []byte(inputSchema)
+	err := server.RegisterToolWithSchema("search", "Search things", buffer, func(ctx context.Context, arguments json.RawMessage) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent(string(arguments))), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer, strings.Repeat(" ", len(buffer)))
+	for _, invalid := range []string{
+		`[]`,
+		`null`,
+		`{"type":"string"}`,
+		`{"type":["object","null"]}`,
+		`{"type":"object","properties":{"a":{"type":["string",1]}}}`,
+		`{"type":"object","properties":{"a":{"type":[]}}}`,
+		`{"type":"object","properties":{"a":{"items":{"type":["string","strin"]}}}}`,
+		`{"type":"object","properties":{"a":{"type":"strin"}}}`,
+		`{"type":"object","properties":{"a":{"type":"string","pattern":"("}}}`,
+		`{"type":"object","properties":{"a":{"$ref":"#/$defs/missing"}}}`,
+	} {
+		err = server.RegisterToolWithSchema("invalid", "Invalid", json.RawMessage(invalid), func(ctx context.Context, arguments json.RawMessage) (*ToolResponse, error) {
+			return nil, nil
+		})
+		if err == nil {
+			t.Errorf("Expected error registering a tool with the schema %s", invalid)
+		}
+	}
+
+	resp, err := server.handleListTools(&transport.BaseJSONRPCRequest{Params: []byte(`{}`)}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := json.Marshal(resp.(tools.ToolsResponse).Tools[0].InputSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(listed) != inputSchema {
+		t.Errorf("Expected the schema to be listed verbatim, got %s", listed)
+	}
+
+	callTool := func(arguments string) string {
+		t.Helper()
+		resp, err := server.handleToolCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"search","arguments":` + arguments + `}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := callTool(`{"query":"go"}`); got != `{"content":[{"text":"{\"query\":\"go\"}","type":"text"}],"isError":false}` {
+		t.Errorf("Unexpected response: %s", got)
+	}
+	if got := callTool(`{"query":""}`); got != `{"content":[{"text":"invalid arguments:\n- /query: must be at least 1 characters long","type":"text"}],"isError":true}` {
+		t.Errorf("Unexpected response: %s", got)
+	}
+	if got := callTool(`{"query":"go","limit":5}`); !strings.Contains(got, `"isError":false`) {
+		t.Errorf("Expected a limit to be accepted, got %s", got)
+	}
+	if got := callTool(`{"query":"go","limit":0}`); !strings.Contains(got, `/limit: must be \u003e= 1`) {
+		t.Errorf("Expected the limit to be checked, got %s", got)
+	}
+	if got := callTool(`{"query":"go","limit":"five"}`); !strings.Contains(got, `/limit: expected integer or null, got string`) {
+		t.Errorf("Expected the limit's types to be checked, got %s", got)
+	}
+
+	required := true
+	description := "The language to review"
+	err = server.RegisterPromptWithArguments("review", "Review code", []PromptArgument{
+		{Name: "language", Description: &description, Required: &required},
+		{Name: "style"},
+	}, func(ctx context.Context, arguments map[string]string) (*PromptResponse, error) {
+		return NewPromptResponse("review", NewPromptMessage(NewTextContent("Review this "+arguments["language"]), RoleUser)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterPromptWithArguments("duplicate", "Duplicate arguments", []PromptArgument{{Name: "a"}, {Name: "a"}}, func(ctx context.Context, arguments map[string]string) (*PromptResponse, error) {
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a prompt with duplicate arguments")
+	}
+
+	resp, err = server.handleListPrompts(&transport.BaseJSONRPCRequest{Params: []byte(`{}`)}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err = json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"prompts":[{"arguments":[{"description":"The language to review","name":"language","required":true},{"name":"style","required":false}],"name":"review"}]}`; string(listed) != expected {
+		t.Errorf("Expected %s, got %s", expected, listed)
+	}
+
+	getPrompt := func(arguments string) string {
+		t.Helper()
+		resp, err := server.handlePromptCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"review","arguments":` + arguments + `}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := getPrompt(`{"language":"go"}`); !strings.Contains(got, "Review this go") {
+		t.Errorf("Unexpected response: %s", got)
+	}
+	if got := getPrompt(`{"style":"terse"}`); !strings.Contains(got, "missing required argument language") {
+		t.Errorf("Expected a missing argument error, got %s", got)
+	}
+	if got := getPrompt(`{"language":"go","tone":"harsh"}`); !strings.Contains(got, "unknown argument tone") {
+		t.Errorf("Expected an unknown argument error, got %s", got)
+	}
+}
+
+func TestPromptArgumentConversion(t *testing.T) {
+	server := NewServer(testingutils.NewMockTransport())
+
+	type storyArgs struct {
+		Title    string   `json:"title" jsonschema:"required,description=The title"`
+		Length   int      `json:"length" jsonschema:"required"`
+		Funny    *bool    `json:"funny,omitempty"`
+		Ratio    float64  `json:"ratio,omitempty"`
+		Tone     string   `json:"tone,omitempty" jsonschema:"enum=formal,enum=casual"`
+		Internal string   `json:"-"`
+		Tags     []string `json:"-"`
+	}
+	err := server.RegisterPrompt("story", "Write a story", func(args storyArgs) (*PromptResponse, error) {
+		funny := args.Funny != nil && *args.Funny
+		return NewPromptResponse("story", NewPromptMessage(NewTextContent(fmt.Sprintf("%s %d %v %v %s", args.Title, args.Length, funny, args.Ratio, args.Tone)), RoleUser)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type unsupportedArgs struct {
+		Tags []string `json:"tags"`
+	}
+	err = server.RegisterPrompt("unsupported", "Unsupported", func(args unsupportedArgs) (*PromptResponse, error) {
+		return nil, nil
+	})
+	if err == nil {
+		t.Error("Expected error registering a prompt with a slice argument")
+	}
+
+	resp, err := server.handleListPrompts(&transport.BaseJSONRPCRequest{Params: []byte(`{}`)}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"prompts":[{"arguments":[{"description":"The title","name":"title","required":true},{"name":"length","required":true},{"name":"funny","required":false},{"name":"ratio","required":false},{"name":"tone","required":false}],"name":"story"}]}`
+	if string(listed) != expected {
+		t.Errorf("Expected %s, got %s", expected, listed)
+	}
+
+	tests := []struct {
+		arguments string
+		expected  string
+	}{
+		{`{"title":"Go","length":"3","funny":"true","ratio":"0.5","tone":"casual"}`, "Go 3 true 0.5 casual"},
+		{`{"title":"Go","length":" 3 "}`, "Go 3 false 0 "},
+		{`{"title":"Go","length":"three"}`, `argument length must be an integer between -9223372036854775808 and 9223372036854775807, got "three"`},
+		{`{"title":"Go","length":"3","funny":"maybe"}`, `argument funny must be true or false, got "maybe"`},
+		{`{"title":"Go","length":"3","tone":"rude"}`, `argument tone must be one of formal, casual, got "rude"`},
+		{`{"length":"3"}`, "missing required argument title"},
+	}
+	for _, tt := range tests {
+		resp, err := server.handlePromptCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"story","arguments":` + tt.arguments + `}`),
+		}, protocol.RequestHandlerExtra{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent := resp.(*promptResponseSent)
+		if sent.Error != nil {
+			if got := sent.Error.Error(); got != tt.expected {
+				t.Errorf("Expected error %q, got %q", tt.expected, got)
+			}
+			continue
+		}
+		if got := sent.Response.Messages[0].Content.TextContent.Text; got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+// Connects a server and a client to each other over in memory pipes
+func newConnectedServerAndClient(t *testing.T, serverOptions []ServerOptions, clientOptions ...ClientOptions) (*Server, *Client) {
+	t.Helper()
+	serverTransport, clientTransport, _ := newPipeTransports(t)
+	server := NewServer(serverTransport, serverOptions...)
+	client := NewClient(clientTransport, clientOptions...)
+	return server, client
+}
+
+// Creates the two ends of a stdio connection, hangUp closes it as if the client went away
+func newPipeTransports(t *testing.T) (serverTransport *stdio.StdioServerTransport, clientTransport *stdio.StdioServerTransport, hangUp func()) {
+	clientToServerReader, clientToServerWriter := io.Pipe()
+	serverToClientReader, serverToClientWriter := io.Pipe()
+	hangUp = func() {
+		clientToServerWriter.Close()
+		serverToClientWriter.Close()
+	}
+	t.Cleanup(hangUp)
+	serverTransport = stdio.NewStdioServerTransportWithIO(clientToServerReader, serverToClientWriter)
+	clientTransport = stdio.NewStdioServerTransportWithIO(serverToClientReader, clientToServerWriter)
+	return serverTransport, clientTransport, hangUp
+}
+
+func TestElicitation(t *testing.T) {
+	type deployArgs struct {
+		Service string `json:"service" jsonschema:"required"`
+	}
+	type confirmation struct {
+		Confirm  bool   `json:"confirm" jsonschema:"required,description=Whether to deploy"`
+		Reason   string `json:"reason" jsonschema:"description=Why the deploy is happening"`
+		Replicas int    `json:"replicas"`
+	}
+
+	var receivedSchema map[string]interface{}
+	answers := []*ElicitResult{
+		{Action: ElicitActionAccept, Content: map[string]interface{}{"confirm": true, "reason": "release", "replicas": 3}},
+		{Action: ElicitActionDecline},
+	}
+	server, client := newConnectedServerAndClient(t, nil, WithElicitationHandler(func(ctx context.Context, request ElicitRequestParams) (*ElicitResult, error) {
+		if request.Message != "Deploy api?" {
+			t.Errorf("Unexpected message: %s", request.Message)
+		}
+		err := json.Unmarshal(request.RequestedSchema, &receivedSchema)
+		if err != nil {
+			t.Error(err)
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}))
+
+	err := server.RegisterTool("deploy", "Deploys a service", func(ctx context.Context, args deployArgs) (*ToolResponse, error) {
+		var c confirmation
+		action, err := server.Elicit(ctx, "Deploy "+args.Service+"?", &c)
+		if err != nil {
+			return nil, err
+		}
+		return NewToolResponse(NewTextContent(fmt.Sprintf("%s %v %s %d", action, c.Confirm, c.Reason, c.Replicas))), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	_, err = client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callDeploy := func() string {
+		t.Helper()
+		result, err := client.CallTool(ctx, "deploy", deployArgs{Service: "api"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Content[0].TextContent.Text
+	}
+
+	if text := callDeploy(); text != "accept true release 3" {
+		t.Errorf("Unexpected result: %s", text)
+	}
+	if receivedSchema["type"] != "object" || receivedSchema["required"].([]interface{})[0] != "confirm" {
+		t.Errorf("Unexpected requested schema: %v", receivedSchema)
+	}
+	if _, ok := receivedSchema["$schema"]; ok {
+		t.Error("Expected no $schema keyword in the requested schema")
+	}
+	if text := callDeploy(); text != "decline false  0" {
+		t.Errorf("Unexpected result: %s", text)
+	}
+
+	// Nested structs can't be elicited
+	type nested struct {
+		Inner confirmation `json:"inner"`
+	}
+	_, err = server.Elicit(ctx, "Nested?", &nested{})
+	if err == nil {
+		t.Error("Expected error eliciting a nested struct")
+	}
+}
+
+func TestToolErrors(t *testing.T) {
+	server, client := newConnectedServerAndClient(t, nil)
+
+	type fetchArgs struct {
+		Url string `json:"url" jsonschema:"required"`
+	}
+	cause := fmt.Errorf("connection reset")
+	err := RegisterTool(server, "fetch", "Fetches a page", func(ctx context.Context, args fetchArgs) (*ToolResponse, error) {
+		switch args.Url {
+		case "rate-limited":
+			return nil, NewToolError("rate_limited", "Too many requests, try again in a minute").
+				WithRetryable(true).
+				WithPartialResults(NewTextContent("page 1 of 2")).
+				WithCause(cause)
+		case "screenshot":
+			return nil, fmt.Errorf("fetching page: %w", NewToolError("blocked", "The page blocked us").WithContent(NewImageContent("aGVsbG8=", "image/png")))
+		case "explicit":
+			return NewToolErrorResponse(NewTextContent("Not found")), nil
+		}
+		return nil, cause
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type page struct {
+		Title string `json:"title" jsonschema:"required"`
+	}
+	err = RegisterStructuredTool(server, "lookup", "Looks a page up", func(ctx context.Context, args fetchArgs) (*page, error) {
+		return nil, NewToolError("not_found", "No such page")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callFetch := func(url string) *CallToolResult {
+		t.Helper()
+		result, err := client.CallTool(ctx, "fetch", fetchArgs{Url: url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.IsError == nil || !*result.IsError {
+			t.Fatalf("Expected an error result for %s", url)
+		}
+		return result
+	}
+
+	result := callFetch("rate-limited")
+	toolError := result.ToolError()
+	if toolError.Code != "rate_limited" || !toolError.Retryable || toolError.Message != "Too many requests, try again in a minute" {
+		t.Errorf("Unexpected tool error: %+v", toolError)
+	}
+	if len(result.Content) != 2 || result.Content[1].TextContent.Text != "page 1 of 2" {
+		t.Errorf("Expected the error followed by the partial results, got %+v", result.Content)
+	}
+	if !strings.Contains(fmt.Sprint(result.Meta[toolErrorMetaKey]), "partial:true") {
+		t.Errorf("Expected the error details to mark partial results, got %v", result.Meta)
+	}
+
+	result = callFetch("screenshot")
+	if result.Content[0].Type != ContentTypeImage || result.ToolError().Code != "blocked" || result.ToolError().Retryable {
+		t.Errorf("Expected the wrapped ToolError's content and code, got %+v", result.ToolError())
+	}
+
+	result = callFetch("explicit")
+	if toolError := result.ToolError(); toolError.Message != "Not found" || toolError.Code != "" {
+		t.Errorf("Unexpected tool error: %+v", toolError)
+	}
+
+	result = callFetch("other")
+	if toolError := result.ToolError(); toolError.Message != "connection reset" || result.Meta != nil {
+		t.Errorf("Expected plain errors to be sent as text, got %+v", toolError)
+	}
+
+	// The details don't break the output schema of tools with structured output
+	result, err = client.CallTool(ctx, "lookup", fetchArgs{Url: "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toolError := result.ToolError(); toolError == nil || toolError.Code != "not_found" || result.StructuredContent != nil {
+		t.Errorf("Expected a tool error without structured content, got %+v and %v", toolError, result.StructuredContent)
+	}
+
+	if !errors.Is(NewToolError("x", "y").WithCause(cause), cause) {
+		t.Error("Expected ToolError to unwrap to its cause")
+	}
+}
+
+func TestToolExecutionPolicies(t *testing.T) {
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&lockedWriter{w: &logs, mu: &logsMu}, nil))
+	server := NewServer(testingutils.NewMockTransport(), WithLogger(logger))
+
+	type args struct {
+		Key string `json:"key"`
+	}
+	err := RegisterTool(server, "panics", "Panics", func(ctx context.Context, args args) (*ToolResponse, error) {
+		panic("third party library exploded")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "slow", "Ignores its context", func(ctx context.Context, args args) (*ToolResponse, error) {
+		time.Sleep(time.Second)
+		return NewToolResponse(NewTextContent("done")), nil
+	}, WithToolTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var active, maxActive, invocations atomic.Int32
+	release := make(chan struct{})
+	tracked := func(ctx context.Context, args args) (*ToolResponse, error) {
+		invocations.Add(1)
+		current := active.Add(1)
+		defer active.Add(-1)
+		for {
+			previous := maxActive.Load()
+			if current <= previous || maxActive.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		<-release
+		RequestContextFromContext(ctx).SetResultMeta("key", args.Key)
+		return NewToolResponse(NewTextContent("result for " + args.Key)), nil
+	}
+	err = RegisterTool(server, "limited", "At most two at a time", tracked, WithToolMaxConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "deduplicated", "Shares identical calls", tracked, WithToolDeduplication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlerCancelled := make(chan struct{}, 1)
+	err = RegisterTool(server, "waits", "Runs until it is cancelled", func(ctx context.Context, args args) (*ToolResponse, error) {
+		<-ctx.Done()
+		handlerCancelled <- struct{}{}
+		return nil, ctx.Err()
+	}, WithToolDeduplication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hang := make(chan struct{})
+	err = RegisterTool(server, "hangs", "Ignores its context, one at a time", func(ctx context.Context, args args) (*ToolResponse, error) {
+		<-hang
+		return NewToolResponse(NewTextContent("done")), nil
+	}, WithToolTimeout(20*time.Millisecond), WithToolMaxConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterPrompt("panics", "Panics", func(args struct{}) (*PromptResponse, error) {
+		panic("prompt exploded")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callToolWithContext := func(ctx context.Context, name string, arguments string) string {
+		t.Helper()
+		resp, err := server.handleToolCalls(&transport.BaseJSONRPCRequest{
+			Params: []byte(`{"name":"` + name + `","arguments":` + arguments + `}`),
+		}, protocol.RequestHandlerExtra{Context: ctx})
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		b, err := json.Marshal(resp)
+		if err != nil {
+			t.Error(err)
+		}
+		return string(b)
+	}
+	callTool := func(name string, arguments string) string {
+		t.Helper()
+		return callToolWithContext(context.Background(), name, arguments)
+	}
+	callConcurrentlyWithContexts := func(contexts []context.Context, name string, arguments ...string) []string {
+		t.Helper()
+		results := make([]string, len(arguments))
+		var wg sync.WaitGroup
+		for i, argument := range arguments {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = callToolWithContext(contexts[i], name, argument)
+			}()
+		}
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		release = make(chan struct{})
+		return results
+	}
+	callConcurrently := func(name string, arguments ...string) []string {
+		t.Helper()
+		contexts := make([]context.Context, len(arguments))
+		for i := range contexts {
+			contexts[i] = context.Background()
+		}
+		return callConcurrentlyWithContexts(contexts, name, arguments...)
+	}
+
+	if got := callTool("panics", `{}`); got != `{"content":[{"text":"tool panics failed unexpectedly","type":"text"}],"isError":true}` {
+		t.Errorf("Expected the panic to become an error result, got %s", got)
+	}
+	logsMu.Lock()
+	if !strings.Contains(logs.String(), "third party library exploded") || !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("Expected the panic to be logged with a stack trace, got %s", logs.String())
+	}
+	logsMu.Unlock()
+
+	resp, err := server.handlePromptCalls(&transport.BaseJSONRPCRequest{Params: []byte(`{"name":"panics"}`)}, protocol.RequestHandlerExtra{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := resp.(*promptResponseSent); sent.Error == nil || sent.Error.Error() != "prompt panics failed unexpectedly" {
+		t.Errorf("Expected the prompt panic to become an error, got %+v", sent)
+	}
+
+	start := time.Now()
+	if got := callTool("slow", `{}`); !strings.Contains(got, "tool slow timed out after 20ms") {
+		t.Errorf("Expected a timeout, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the timeout to return straight away, took %s", elapsed)
+	}
+
+	callConcurrently("limited", `{"key":"a"}`, `{"key":"b"}`, `{"key":"c"}`, `{"key":"d"}`)
+	if maxActive.Load() != 2 || invocations.Load() != 4 {
+		t.Errorf("Expected 4 calls with at most 2 at a time, got %d calls with %d at a time", invocations.Load(), maxActive.Load())
+	}
+
+	invocations.Store(0)
+	results := callConcurrently("deduplicated", `{"key":"a"}`, `{"key": "a"}`, `{"key":"b"}`)
+	if invocations.Load() != 2 {
+		t.Errorf("Expected identical calls to share an invocation, got %d invocations", invocations.Load())
+	}
+	if !strings.Contains(results[0], "result for a") || results[0] != results[1] || !strings.Contains(results[2], "result for b") {
+		t.Errorf("Unexpected results: %v", results)
+	}
+	if !strings.Contains(results[1], `"_meta":{"key":"a"}`) {
+		t.Errorf("Expected the _meta set by the shared call to reach every caller, got %s", results[1])
+	}
+
+	// Results may be tailored to the session's client or identity, so they are never shared between sessions
+	invocations.Store(0)
+	sessionContexts := []context.Context{
+		context.WithValue(context.Background(), sessionContextKey{}, &Session{server: server, id: "alice"}),
+		context.WithValue(context.Background(), sessionContextKey{}, &Session{server: server, id: "bob"}),
+	}
+	callConcurrentlyWithContexts(sessionContexts, "deduplicated", `{"key":"a"}`, `{"key":"a"}`)
+	if invocations.Load() != 2 {
+		t.Errorf("Expected calls from different sessions not to be shared, got %d invocations", invocations.Load())
+	}
+
+	// Callers waiting for a shared call can still cancel
+	started := make(chan string)
+	go func() {
+		started <- callTool("deduplicated", `{"key":"c"}`)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if got := callToolWithContext(ctx, "deduplicated", `{"key":"c"}`); !strings.Contains(got, "call to tool deduplicated was cancelled") {
+		t.Errorf("Expected the waiting call to be cancelled, got %s", got)
+	}
+	cancel()
+	close(release)
+	if got := <-started; !strings.Contains(got, "result for c") {
+		t.Errorf("Expected the shared call to finish, got %s", got)
+	}
+	release = make(chan struct{})
+
+	// A shared call is cancelled once every caller has gone away, not before
+	expectHandlerCancelled := func(expected bool) {
+		t.Helper()
+		select {
+		case <-handlerCancelled:
+			if !expected {
+				t.Error("Expected the shared call to keep running while a caller waits for it")
+			}
+		case <-time.After(100 * time.Millisecond):
+			if expected {
+				t.Error("Expected the shared call to be cancelled")
+			}
+		}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	if got := callToolWithContext(ctx, "waits", `{}`); !strings.Contains(got, "call to tool waits was cancelled") {
+		t.Errorf("Expected the call to be cancelled, got %s", got)
+	}
+	cancel()
+	expectHandlerCancelled(true)
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	waited := make(chan string, 2)
+	for _, ctx := range []context.Context{first, second} {
+		go func() {
+			waited <- callToolWithContext(ctx, "waits", `{}`)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancelFirst()
+	<-waited
+	expectHandlerCancelled(false)
+	cancelSecond()
+	<-waited
+	expectHandlerCancelled(true)
+
+	// A call that timed out keeps its slot until its handler returns
+	if got := callTool("hangs", `{}`); !strings.Contains(got, "tool hangs timed out after 20ms") {
+		t.Errorf("Expected a timeout, got %s", got)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if got := callToolWithContext(ctx, "hangs", `{}`); !strings.Contains(got, "call to tool hangs was cancelled") {
+		t.Errorf("Expected the call to wait for the handler that timed out, got %s", got)
+	}
+	cancel()
+	close(hang)
+
+	// Cancellations by the client aren't timeouts
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(5*time.Millisecond, cancel)
+	if got := callToolWithContext(ctx, "slow", `{}`); !strings.Contains(got, "cancelled") || strings.Contains(got, "timeout") {
+		t.Errorf("Expected a cancellation, got %s", got)
+	}
+}
+
+// Lets a test read a log buffer that handlers write to from other goroutines
+type lockedWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func TestSession(t *testing.T) {
+	server, client := newConnectedServerAndClient(t, nil, WithClientInfo("desktop-host", "1.2.0"))
+
+	type formatArgs struct {
+		Text string `json:"text" jsonschema:"required"`
+	}
+	var requestContext *RequestContext
+	err := RegisterTool(server, "format", "Formats text for the host", func(ctx context.Context, args formatArgs) (*ToolResponse, error) {
+		requestContext = RequestContextFromContext(ctx)
+		session := SessionFromContext(ctx)
+		if session != requestContext.Session {
+			t.Error("Expected the request context to belong to the session")
+		}
+		err := requestContext.ReportProgress(1, 2, "halfway")
+		if err != nil {
+			return nil, err
+		}
+		err = requestContext.Log(LoggingLevelDebug, "format", "too verbose")
+		if err != nil {
+			return nil, err
+		}
+		err = requestContext.Log(LoggingLevelWarning, "format", map[string]interface{}{"text": args.Text})
+		if err != nil {
+			return nil, err
+		}
+		if session.ClientInfo().Name == "desktop-host" {
+			return NewToolResponse(NewTextContent("**" + args.Text + "**")), nil
+		}
+		return NewToolResponse(NewTextContent(args.Text)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := make(chan LoggingMessageNotificationParams, 10)
+	client.protocol.SetNotificationHandler("notifications/message", func(notification *transport.BaseJSONRPCNotification) error {
+		var params LoggingMessageNotificationParams
+		err := json.Unmarshal(notification.Params, &params)
+		if err != nil {
+			t.Error(err)
+		}
+		logs <- params
+		return nil
+	})
+	ctx := context.Background()
+	_, err = client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.protocol.Request(ctx, "logging/setLevel", map[string]interface{}{"level": "info"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := make(chan protocol.Progress, 10)
+	response, err := client.protocol.Request(ctx, "tools/call", map[string]interface{}{
+		"name":      "format",
+		"arguments": map[string]interface{}{"text": "hello"},
+	}, &protocol.RequestOptions{OnProgress: func(p protocol.Progress) {
+		progress <- p
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result CallToolResult
+	err = unmarshalResponse(response, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := result.Content[0].TextContent.Text; text != "**hello**" {
+		t.Errorf("Expected output formatted for the host, got %s", text)
+	}
+
+	session := requestContext.Session
+	if session.ID() == "" || session.ClientInfo() != (Implementation{Name: "desktop-host", Version: "1.2.0"}) || session.ProtocolVersion() != latestProtocolVersion {
+		t.Errorf("Unexpected session: %s %+v %s", session.ID(), session.ClientInfo(), session.ProtocolVersion())
+	}
+	if requestContext.Method != "tools/call" || requestContext.Meta["progressToken"] == nil {
+		t.Errorf("Unexpected request context: %+v", requestContext)
+	}
+
+	select {
+	case p := <-progress:
+		if p != (protocol.Progress{Progress: 1, Total: 2, Message: "halfway"}) {
+			t.Errorf("Unexpected progress: %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a progress notification")
+	}
+	select {
+	case log := <-logs:
+		if log.Level != LoggingLevelWarning || *log.Logger != "format" || log.Data.(map[string]interface{})["text"] != "hello" {
+			t.Errorf("Unexpected log message: %+v", log)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a log message")
+	}
+	select {
+	case log := <-logs:
+		t.Errorf("Expected messages below the client's level to be dropped, got %+v", log)
+	default:
+	}
+}
+
+func TestRequestAndResultMeta(t *testing.T) {
+	server, client := newConnectedServerAndClient(t, nil)
+
+	type searchArgs struct {
+		Query string `json:"query" jsonschema:"required"`
+	}
+	type searchResult struct {
+		Hits int `json:"hits"`
+	}
+	echoTraceId := func(ctx context.Context) {
+		request := RequestContextFromContext(ctx)
+		request.SetResultMeta("traceId", request.Meta["traceId"])
+		request.SetResultMeta("ui", "default")
+	}
+	err := RegisterTool(server, "search", "Searches", func(ctx context.Context, args searchArgs) (*ToolResponse, error) {
+		echoTraceId(ctx)
+		return NewToolResponse(NewTextContent("found")).WithMeta(map[string]interface{}{"ui": "compact"}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterStructuredTool(server, "count", "Counts", func(ctx context.Context, args searchArgs) (searchResult, error) {
+		echoTraceId(ctx)
+		return searchResult{Hits: 3}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterPrompt(server, "summarise", "Summarises", func(ctx context.Context, args struct{}) (*PromptResponse, error) {
+		echoTraceId(ctx)
+		return NewPromptResponse("summary", NewPromptMessage(NewTextContent("Summarise"), RoleUser)).WithMeta(map[string]interface{}{"cached": true}), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTrace := WithRequestMeta(map[string]interface{}{"traceId": "abc"})
+	result, err := client.CallTool(ctx, "search", searchArgs{Query: "go"}, withTrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Meta["traceId"] != "abc" || result.Meta["ui"] != "compact" {
+		t.Errorf("Unexpected tool result meta: %v", result.Meta)
+	}
+	result, err = client.CallTool(ctx, "count", searchArgs{Query: "go"}, withTrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Meta["traceId"] != "abc" || result.Meta["ui"] != "default" || result.StructuredContent["hits"] != float64(3) {
+		t.Errorf("Unexpected structured tool result: %+v", result)
+	}
+
+	response, err := client.protocol.Request(ctx, "prompts/get", map[string]interface{}{
+		"name":  "summarise",
+		"_meta": map[string]interface{}{"traceId": "def"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prompt GetPromptResult
+	err = unmarshalResponse(response, &prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.Meta["traceId"] != "def" || prompt.Meta["cached"] != true {
+		t.Errorf("Unexpected prompt result meta: %v", prompt.Meta)
+	}
+}
+
+func TestMultipleSessions(t *testing.T) {
+	httpTransport := streamablehttp.NewServerTransport()
+	server := NewServer(httpTransport)
+	err := server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(httpTransport)
+	t.Cleanup(func() {
+		httpTransport.Close()
+		httpServer.Close()
+	})
+
+	err = RegisterResource(server, "file:///config", "config", "The configuration", "text/plain", func(ctx context.Context) (*ResourceResponse, error) {
+		return NewResourceResponse(NewTextEmbeddedResource("file:///config", "debug=true", "text/plain")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type whoamiArgs struct{}
+	err = RegisterTool(server, "whoami", "Names the client", func(ctx context.Context, args whoamiArgs) (*ToolResponse, error) {
+		session := SessionFromContext(ctx)
+		err := session.Log(LoggingLevelInfo, "whoami", session.ClientInfo().Name)
+		if err != nil {
+			return nil, err
+		}
+		return NewToolResponse(NewTextContent(session.ClientInfo().Name)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type connectedClient struct {
+		client        *Client
+		notifications chan string
+	}
+	ctx := context.Background()
+	connect := func(name string) connectedClient {
+		c := connectedClient{
+			client:        NewClient(streamablehttp.NewClientTransport(httpServer.URL), WithClientInfo(name, "1.0.0")),
+			notifications: make(chan string, 10),
+		}
+		c.client.protocol.FallbackNotificationHandler = func(notification *transport.BaseJSONRPCNotification) error {
+			c.notifications <- notification.Method
+			return nil
+		}
+		_, err := c.client.Initialize(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	expectNotification := func(c connectedClient, method string) {
+		t.Helper()
+		select {
+		case received := <-c.notifications:
+			if received != method {
+				t.Errorf("Expected %s, got %s", method, received)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected %s", method)
+		}
+	}
+	expectNoNotification := func(c connectedClient) {
+		t.Helper()
+		select {
+		case received := <-c.notifications:
+			t.Errorf("Expected no notification, got %s", received)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	first := connect("first")
+	second := connect("second")
+	if len(server.Sessions()) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(server.Sessions()))
+	}
+
+	// Each session answers as its own client and gets its own log messages
+	_, err = first.client.protocol.Request(ctx, "logging/setLevel", map[string]interface{}{"level": "error"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []connectedClient{first, second} {
+		result, err := c.client.CallTool(ctx, "whoami", map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := result.Content[0].TextContent.Text
+		if c.client.clientName != name {
+			t.Errorf("Expected the tool to see %s, got %s", c.client.clientName, name)
+		}
+	}
+	expectNotification(second, "notifications/message")
+	expectNoNotification(first)
+
+	// Only the subscribed session hears about updates, every session hears about list changes
+	_, err = second.client.protocol.Request(ctx, "resources/subscribe", map[string]interface{}{"uri": "file:///config"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.NotifyResourceUpdated("file:///config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNotification(second, "notifications/resources/updated")
+	expectNoNotification(first)
+
+	err = server.DeregisterTool("whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNotification(first, "notifications/tools/list_changed")
+	expectNotification(second, "notifications/tools/list_changed")
+
+	// Closing a client ends its session only
+	err = first.client.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := server.Sessions()
+	if len(sessions) != 1 || sessions[0].ClientInfo().Name != "second" {
+		t.Errorf("Expected only the second session to remain, got %d sessions", len(sessions))
+	}
+	err = second.client.Ping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	serverTransport, clientTransport, hangUp := newPipeTransports(t)
+	server := NewServer(serverTransport)
+	type echoArgs struct {
+		Text string `json:"text" jsonschema:"required"`
+	}
+	err := RegisterTool(server, "echo", "Echoes the text", func(ctx context.Context, args echoArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent(args.Text)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	connect := func(tr transport.Transport) *Client {
+		client := NewClient(tr)
+		_, err := client.Initialize(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	echo := func(client *Client) {
+		t.Helper()
+		result, err := client.CallTool(ctx, "echo", map[string]interface{}{"text": "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Content[0].TextContent.Text != "hello" {
+			t.Errorf("Unexpected result: %+v", result.Content[0])
+		}
+	}
+	waitForSessions := func(count int) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for len(server.Sessions()) != count {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d sessions, got %d", count, len(server.Sessions()))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	first := connect(clientTransport)
+	attachedServerTransport, attachedClientTransport, hangUpAttached := newPipeTransports(t)
+	err = server.Attach(attachedServerTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached := connect(attachedClientTransport)
+	echo(first)
+	echo(attached)
+	waitForSessions(2)
+
+	// The attached client going away leaves the other session alone
+	hangUpAttached()
+	waitForSessions(1)
+	echo(first)
+
+	// A client can reconnect over a new transport without the server being restarted
+	reconnectedServerTransport, reconnectedClientTransport, _ := newPipeTransports(t)
+	err = server.Attach(reconnectedServerTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(connect(reconnectedClientTransport))
+	waitForSessions(2)
+
+	// Once the transport the server was created with closes, the server can be served again
+	hangUp()
+	waitForSessions(1)
+	err = server.Serve()
+	if err != nil {
+		t.Errorf("Expected the server to be served again once its transport closed, got %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	type waitArgs struct{}
+	newServer := func(handler func(ctx context.Context, args waitArgs) (*ToolResponse, error)) (*Server, *Client, chan error) {
+		server, client := newConnectedServerAndClient(t, nil)
+		err := RegisterTool(server, "wait", "Waits", handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() {
+			served <- server.ServeContext(context.Background())
+		}()
+		_, err = client.Initialize(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server, client, served
+	}
+	expectServed := func(served chan error) {
+		t.Helper()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Errorf("Expected ServeContext to return nil, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected ServeContext to return once the server was shut down")
+		}
+	}
+
+	t.Run("waits for requests being handled", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		server, client, served := newServer(func(ctx context.Context, args waitArgs) (*ToolResponse, error) {
+			close(started)
+			<-release
+			return NewToolResponse(NewTextContent("done")), nil
+		})
+		called := make(chan error, 1)
+		go func() {
+			_, err := client.CallTool(context.Background(), "wait", map[string]interface{}{})
+			called <- err
+		}()
+		<-started
+
+		shutdown := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdown <- server.Shutdown(ctx)
+		}()
+		// New requests are refused while the server drains
+		deadline := time.Now().Add(time.Second)
+		for client.Ping(context.Background()) == nil {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected requests to be refused while shutting down")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		close(release)
+		if err := <-called; err != nil {
+			t.Errorf("Expected the request being handled to finish, got %v", err)
+		}
+		if err := <-shutdown; err != nil {
+			t.Errorf("Unexpected error shutting down: %v", err)
+		}
+		expectServed(served)
+		if err := server.Serve(); err == nil {
+			t.Error("Expected a shut down server not to be served again")
+		}
+	})
+
+	t.Run("cancels requests at the deadline", func(t *testing.T) {
+		started := make(chan struct{})
+		cancelled := make(chan struct{})
+		server, client, served := newServer(func(ctx context.Context, args waitArgs) (*ToolResponse, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		})
+		go client.CallTool(context.Background(), "wait", map[string]interface{}{})
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the deadline to be exceeded, got %v", err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("Expected the request to be cancelled")
+		}
+		expectServed(served)
+	})
+
+	t.Run("closes a transport that was never served", func(t *testing.T) {
+		mockTransport := testingutils.NewMockTransport()
+		server := NewServer(mockTransport)
+		if err := server.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !mockTransport.IsClosed() {
+			t.Error("Expected the transport to be closed")
+		}
+	})
+}
+
+func TestAccessPolicy(t *testing.T) {
+	premium := map[string]bool{"export": true, "report": true, "file:///reports": true}
+	server, client := newConnectedServerAndClient(t, []ServerOptions{WithAccessPolicy(func(ctx context.Context, feature Feature) bool {
+		return !premium[feature.Name] || SessionFromContext(ctx).Attribute("tier") == "premium"
+	})})
+
+	type noArgs struct{}
+	err := RegisterTool(server, "upgrade", "Upgrades the client to the premium tier", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		SessionFromContext(ctx).SetAttribute("tier", "premium")
+		return NewToolResponse(NewTextContent("upgraded")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "export", "Exports everything", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent("exported")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterPrompt("report", "Writes a report", func(args noArgs) (*PromptResponse, error) {
+		return NewPromptResponse("report", NewPromptMessage(NewTextContent("report"), RoleUser)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterResource(server, "file:///reports", "reports", "Every report", "text/plain", func(ctx context.Context) (*ResourceResponse, error) {
+		return NewResourceResponse(NewTextEmbeddedResource("file:///reports", "reports", "text/plain")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.RegisterResourceTemplateCompleter("file:///reports", "year", func(ctx context.Context, value string) ([]string, error) {
+		return []string{"2025", "2026"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, err = client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := func() []string {
+		var names []string
+		tools, err := client.ListTools(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tool := range tools.Tools {
+			names = append(names, tool.Name)
+		}
+		for _, method := range []string{"prompts/list", "resources/list"} {
+			response, err := client.protocol.Request(ctx, method, map[string]interface{}{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result struct {
+				Prompts   []Prompt   `json:"prompts"`
+				Resources []Resource `json:"resources"`
+			}
+			err = unmarshalResponse(response, &result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, prompt := range result.Prompts {
+				names = append(names, prompt.Name)
+			}
+			for _, resource := range result.Resources {
+				names = append(names, resource.Uri)
+			}
+		}
+		return names
+	}
+
+	if names := listed(); !slices.Equal(names, []string{"upgrade"}) {
+		t.Errorf("Expected only the free features to be listed, got %v", names)
+	}
+	_, err = client.CallTool(ctx, "export", map[string]interface{}{})
+	if err == nil || !strings.Contains(err.Error(), "-32602") || !strings.Contains(err.Error(), "unknown tool: export") {
+		t.Errorf("Expected calling a hidden tool to fail like an unknown tool, got %v", err)
+	}
+	_, err = client.protocol.Request(ctx, "prompts/get", map[string]interface{}{"name": "report"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown prompt: report") {
+		t.Errorf("Expected getting a hidden prompt to fail, got %v", err)
+	}
+	_, err = client.protocol.Request(ctx, "resources/read", map[string]interface{}{"uri": "file:///reports"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-32002") {
+		t.Errorf("Expected reading a hidden resource to fail with resource not found, got %v", err)
+	}
+	_, err = client.protocol.Request(ctx, "resources/subscribe", map[string]interface{}{"uri": "file:///reports"}, nil)
+	if err == nil {
+		t.Error("Expected subscribing to a hidden resource to fail")
+	}
+	_, err = client.protocol.Request(ctx, "completion/complete", map[string]interface{}{
+		"ref":      map[string]interface{}{"type": "ref/resource", "uri": "file:///reports"},
+		"argument": map[string]interface{}{"name": "year", "value": ""},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-32002") || !strings.Contains(err.Error(), "unknown resource: file:///reports") {
+		t.Errorf("Expected completing a hidden resource to fail with resource not found, got %v", err)
+	}
+
+	_, err = client.CallTool(ctx, "upgrade", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := listed(); !slices.Equal(names, []string{"export", "upgrade", "report", "file:///reports"}) {
+		t.Errorf("Expected every feature to be listed after upgrading, got %v", names)
+	}
+	result, err := client.CallTool(ctx, "export", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content[0].TextContent.Text != "exported" {
+		t.Errorf("Unexpected result: %+v", result.Content[0])
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	httpTransport := streamablehttp.NewServerTransport()
+	// Only admins see the audit tool
+	server := NewServer(httpTransport, WithAccessPolicy(func(ctx context.Context, feature Feature) bool {
+		return feature.Name != "audit" || SessionFromContext(ctx).Identity().Claims["role"] == "admin"
+	}))
+	type noArgs struct{}
+	err := RegisterTool(server, "audit", "Audits the server", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent("audited")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "whoami", "Names the authenticated user", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		identity := SessionFromContext(ctx).Identity()
+		if identity == nil || !identity.HasScope("tools") {
+			return nil, fmt.Errorf("not authenticated")
+		}
+		return NewToolResponse(NewTextContent(identity.Subject)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for auth.Authenticator, trusting whoever the client says it is
+	authenticate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-User")
+		roles := map[string]string{"alice": "admin", "bob": "user"}
+		ctx := transport.ContextWithIdentity(r.Context(), &transport.Identity{
+			Subject: user,
+			Scopes:  []string{"tools"},
+			Claims:  map[string]interface{}{"role": roles[user]},
+		})
+		httpTransport.ServeHTTP(w, r.WithContext(ctx))
+	})
+	httpServer := httptest.NewServer(authenticate)
+	t.Cleanup(func() {
+		httpTransport.Close()
+		httpServer.Close()
+	})
+
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob"} {
+		client := NewClient(streamablehttp.NewClientTransport(httpServer.URL, streamablehttp.WithHeader("X-User", user)))
+		_, err := client.Initialize(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := client.CallTool(ctx, "whoami", map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Content[0].TextContent.Text != user {
+			t.Errorf("Expected %s, got %s", user, result.Content[0].TextContent.Text)
+		}
+		_, err = client.CallTool(ctx, "audit", map[string]interface{}{})
+		if (err == nil) != (user == "alice") {
+			t.Errorf("Expected only alice to be allowed to audit, %s got %v", user, err)
+		}
+	}
+}
+
+func TestStatelessServer(t *testing.T) {
+	httpTransport := streamablehttp.NewServerTransport(streamablehttp.WithStateless())
+	server := NewServer(httpTransport)
+	type noArgs struct{}
+	err := RegisterTool(server, "session", "Names the session", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		return NewToolResponse(NewTextContent(SessionFromContext(ctx).ID())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterTool(server, "ask", "Asks the user", func(ctx context.Context, args noArgs) (*ToolResponse, error) {
+		var answer struct {
+			Name string `json:"name"`
+		}
+		_, err := server.Elicit(ctx, "What is your name?", &answer)
+		return nil, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RegisterResource(server, "file:///config", "config", "The configuration", "text/plain", func(ctx context.Context) (*ResourceResponse, error) {
+		return NewResourceResponse(NewTextEmbeddedResource("file:///config", "debug=true", "text/plain")), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type version struct {
+		Version string `json:"version"`
+	}
+	err = RegisterStructuredTool(server, "version", "Tells the protocol version", func(ctx context.Context, args noArgs) (*version, error) {
+		return &version{Version: SessionFromContext(ctx).ProtocolVersion()}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = server.Serve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := httptest.NewServer(httpTransport)
+	t.Cleanup(func() {
+		httpTransport.Close()
+		httpServer.Close()
+	})
+
+	ctx := context.Background()
+	clientTransport := streamablehttp.NewClientTransport(httpServer.URL)
+	client := NewClient(clientTransport)
+	result, err := client.Initialize(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientTransport.SessionID() != "" {
+		t.Errorf("Expected no session id, got %s", clientTransport.SessionID())
+	}
+	if subscribe := result.Capabilities.Resources.Subscribe; subscribe == nil || *subscribe {
+		t.Error("Expected stateless servers not to offer resource subscriptions")
+	}
+
+	// Every request is handled in a session of its own, which is gone once the request is answered
+	var sessionIds []string
+	for i := 0; i < 2; i++ {
+		response, err := client.CallTool(ctx, "session", map[string]interface{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionIds = append(sessionIds, response.Content[0].TextContent.Text)
+	}
+	if sessionIds[0] == sessionIds[1] {
+		t.Errorf("Expected requests to be handled in separate sessions, got %v", sessionIds)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(server.Sessions()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sessions := server.Sessions(); len(sessions) > 0 {
+		t.Errorf("Expected no sessions to be left, got %d", len(sessions))
+	}
+
+	// Sessions speak the version the client negotiated, which it sends with every request
+	tools, err := client.ListTools(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range tools.Tools {
+		if tool.Name == "version" && tool.OutputSchema == nil {
+			t.Error("Expected the structured tool to have an output schema")
+		}
+	}
+	structured, err := client.CallTool(ctx, "version", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if structured.StructuredContent["version"] != result.ProtocolVersion {
+		t.Errorf("Expected structured content for version %s, got %v", result.ProtocolVersion, structured.StructuredContent)
+	}
+
+	_, err = client.protocol.Request(ctx, "resources/subscribe", map[string]interface{}{"uri": "file:///config"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-32601") {
+		t.Errorf("Expected subscribing to fail with method not found, got %v", err)
+	}
+	response, err := client.CallTool(ctx, "ask", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.IsError == nil || !*response.IsError || !strings.Contains(response.Content[0].TextContent.Text, "not supported by stateless servers") {
+		t.Errorf("Expected elicitation to fail, got %+v", response.Content[0])
+	}
+}
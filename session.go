@@ -0,0 +1,472 @@
+package mcp_golang
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/metoro-io/mcp-golang/internal/protocol"
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// Session is the server's connection with a single client.
+// Handlers get the session of the client that called them with SessionFromContext, and can use it to tailor their
+// behaviour to the client or to send it notifications.
+type Session struct {
+	server    *Server
+	protocol  *protocol.Protocol
+	transport transport.Transport
+	id        string
+
+	mu                 sync.RWMutex
+	protocolVersion    string
+	clientCapabilities ClientCapabilities
+	clientInfo         Implementation
+	logLevel           LoggingLevel
+	// The URIs of the resources the client subscribed to
+	subscriptions map[string]bool
+	// Values the application attached to the session
+	attributes map[string]interface{}
+	// Who the transport authenticated the client as
+	identity *transport.Identity
+}
+
+type sessionContextKey struct{}
+
+type requestContextKey struct{}
+
+func newSession(server *Server, pr *protocol.Protocol, tr transport.Transport) *Session {
+	session := &Session{
+		server:    server,
+		protocol:  pr,
+		transport: tr,
+	}
+	if identified, ok := tr.(transport.SessionTransport); ok && identified.SessionID() != "" {
+		session.id = identified.SessionID()
+	} else {
+		session.id = newSessionID()
+	}
+	pr.BaseContext = context.WithValue(context.Background(), sessionContextKey{}, session)
+	return session
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	// rand.Read never returns an error
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SessionFromContext returns the session of the client whose request is being handled, or nil if ctx doesn't belong to a request
+func SessionFromContext(ctx context.Context) *Session {
+	if ctx == nil {
+		return nil
+	}
+	session, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return session
+}
+
+// ID identifies the session, it is the session id of transports that have one and is random otherwise
+func (s *Session) ID() string {
+	return s.id
+}
+
+// ClientInfo is the name and version the client reported when it initialized the session
+func (s *Session) ClientInfo() Implementation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clientInfo
+}
+
+// ClientCapabilities are the capabilities the client reported when it initialized the session
+func (s *Session) ClientCapabilities() ClientCapabilities {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clientCapabilities
+}
+
+// ProtocolVersion is the version of the protocol agreed with the client, empty before the session is initialized
+func (s *Session) ProtocolVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.protocolVersion
+}
+
+// The protocol version agreed with the client during initialization.
+// Before initialization we assume the oldest version we support.
+func (s *Session) negotiatedProtocolVersion() string {
+	version := s.ProtocolVersion()
+	if version == "" {
+		return protocolVersion20241105
+	}
+	return version
+}
+
+// Stateless sessions only last for a request and never see the client's initialize request, they speak the version
+// the client sent with the request instead. Without one the spec has servers assume 2025-03-26, the version that
+// introduced the streamable HTTP transport.
+func (s *Session) useRequestProtocolVersion(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.protocolVersion != "" {
+		return
+	}
+	if !slices.Contains(supportedProtocolVersions, version) {
+		version = protocolVersion20250326
+	}
+	s.protocolVersion = version
+}
+
+func (s *Session) initialize(protocolVersion string, capabilities ClientCapabilities, clientInfo Implementation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.protocolVersion = protocolVersion
+	s.clientCapabilities = capabilities
+	s.clientInfo = clientInfo
+}
+
+// Notify sends a notification to the client of this session only
+func (s *Session) Notify(method string, params interface{}) error {
+	return s.protocol.Notification(method, params)
+}
+
+// Log sends a log message to the client of this session. logger names the part of the server the message comes from
+// and may be empty. Messages less severe than the level the client set with logging/setLevel are dropped.
+func (s *Session) Log(level LoggingLevel, logger string, data interface{}) error {
+	s.mu.RLock()
+	minimum := s.logLevel
+	s.mu.RUnlock()
+	if minimum != "" && loggingLevelSeverity[level] < loggingLevelSeverity[minimum] {
+		return nil
+	}
+	params := LoggingMessageNotificationParams{Data: data, Level: level}
+	if logger != "" {
+		params.Logger = &logger
+	}
+	return s.Notify("notifications/message", params)
+}
+
+// The syslog severities of the logging levels, from least to most severe
+var loggingLevelSeverity = map[LoggingLevel]int{
+	LoggingLevelDebug:     0,
+	LoggingLevelInfo:      1,
+	LoggingLevelNotice:    2,
+	LoggingLevelWarning:   3,
+	LoggingLevelError:     4,
+	LoggingLevelCritical:  5,
+	LoggingLevelAlert:     6,
+	LoggingLevelEmergency: 7,
+}
+
+// Stateless reports whether the session only lasts for the request being handled, because its transport doesn't keep
+// sessions between requests. Stateless sessions can't send requests to the client, and forget everything about it,
+// including its attributes, once the request is answered.
+func (s *Session) Stateless() bool {
+	stateless, ok := s.transport.(transport.StatelessTransport)
+	return ok && stateless.Stateless()
+}
+
+// Identity is who the transport authenticated the client as, e.g. from its access token, as of its latest request.
+// It is nil for transports that don't authenticate clients, such as stdio.
+func (s *Session) Identity() *transport.Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.identity
+}
+
+func (s *Session) setIdentity(identity *transport.Identity) {
+	if identity == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = identity
+}
+
+// SetAttribute attaches a value to the session, e.g. the customer tier of the client, for handlers and access policies to read
+func (s *Session) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]interface{}{}
+	}
+	s.attributes[key] = value
+}
+
+// Attribute returns the value attached to the session with SetAttribute, nil if there is none
+func (s *Session) Attribute(key string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.attributes[key]
+}
+
+func (s *Session) setLogLevel(level LoggingLevel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logLevel = level
+}
+
+func (s *Session) setSubscribed(uri string, subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions == nil {
+		s.subscriptions = map[string]bool{}
+	}
+	if subscribed {
+		s.subscriptions[uri] = true
+	} else {
+		delete(s.subscriptions, uri)
+	}
+}
+
+func (s *Session) subscribed(uri string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.subscriptions[uri]
+}
+
+// Sessions returns every session that is currently connected
+func (s *Server) Sessions() []*Session {
+	var sessions []*Session
+	s.sessions.Range(func(_ string, session *Session) bool {
+		sessions = append(sessions, session)
+		return true
+	})
+	return sessions
+}
+
+// Sends a notification to every connected session
+func (s *Server) broadcast(method string, params interface{}) error {
+	var errs []error
+	s.sessions.Range(func(id string, session *Session) bool {
+		err := session.Notify(method, params)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to notify session %s: %w", id, err))
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// NotifyResourceUpdated tells the clients that subscribed to the resource that it has changed, so they can read it again
+func (s *Server) NotifyResourceUpdated(uri string) error {
+	var errs []error
+	s.sessions.Range(func(id string, session *Session) bool {
+		if !session.subscribed(uri) {
+			return true
+		}
+		err := session.Notify("notifications/resources/updated", map[string]interface{}{"uri": uri})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to notify session %s: %w", id, err))
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// RequestContext describes the request a handler is serving, get it with RequestContextFromContext
+type RequestContext struct {
+	// The session of the client that sent the request
+	Session *Session
+	// The JSON-RPC id of the request
+	RequestId transport.RequestId
+	// The method of the request, e.g. "tools/call"
+	Method string
+	// The _meta the client sent with the request, nil if it sent none
+	Meta map[string]interface{}
+	// Metadata the transport attached to the request, e.g. the remote address of an HTTP request.
+	// nil for transports that have nothing to add, such as stdio.
+	TransportMetadata map[string]string
+	// Who the transport authenticated the sender of the request as, nil if it didn't
+	Identity *transport.Identity
+
+	progressToken json.RawMessage
+	// The requests sharing the deduplicated tool call this request started, which get its progress too
+	followers  func() []*RequestContext
+	mu         sync.Mutex
+	resultMeta map[string]interface{}
+}
+
+// RequestContextFromContext returns the request being handled, or nil if ctx doesn't belong to a request
+func RequestContextFromContext(ctx context.Context) *RequestContext {
+	if ctx == nil {
+		return nil
+	}
+	request, _ := ctx.Value(requestContextKey{}).(*RequestContext)
+	return request
+}
+
+// Notify sends a notification to the client that sent the request
+func (r *RequestContext) Notify(method string, params interface{}) error {
+	return r.Session.Notify(method, params)
+}
+
+// Log sends a log message to the client that sent the request, see Session.Log
+func (r *RequestContext) Log(level LoggingLevel, logger string, data interface{}) error {
+	return r.Session.Log(level, logger, data)
+}
+
+// SetResultMeta adds an entry to the _meta of the request's result.
+// This lets handlers that return a plain struct attach _meta too, entries in the _meta of a returned response take precedence.
+func (r *RequestContext) SetResultMeta(key string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resultMeta == nil {
+		r.resultMeta = map[string]interface{}{}
+	}
+	r.resultMeta[key] = value
+}
+
+// The _meta to send with the result, the entries set on the request context merged with those of the response
+func (r *RequestContext) resultMetaWith(meta map[string]interface{}) map[string]interface{} {
+	if r == nil {
+		return meta
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.resultMeta) == 0 {
+		return meta
+	}
+	merged := maps.Clone(r.resultMeta)
+	maps.Copy(merged, meta)
+	return merged
+}
+
+// ReportProgress tells the client how far along the request is. total is ignored if it is 0 or less, and message
+// is only sent if it isn't empty.
+// Nothing is sent if the client didn't ask for progress notifications by sending a progress token with the request.
+func (r *RequestContext) ReportProgress(progress float64, total float64, message string) error {
+	if r.followers != nil {
+		for _, follower := range r.followers() {
+			_ = follower.ReportProgress(progress, total, message)
+		}
+	}
+	if r.progressToken == nil {
+		return nil
+	}
+	params := map[string]interface{}{
+		"progressToken": r.progressToken,
+		"progress":      progress,
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	if message != "" {
+		params["message"] = message
+	}
+	return r.Notify("notifications/progress", params)
+}
+
+// The session a request belongs to, requests handled outside of a connection (e.g. in tests) belong to the server's own session
+func (s *Server) sessionFor(ctx context.Context) *Session {
+	if session := SessionFromContext(ctx); session != nil {
+		return session
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session
+}
+
+// closeHookTransport runs onClose once the transport of a session closes, after the protocol has handled it
+type closeHookTransport struct {
+	transport.Transport
+	onClose func()
+	once    sync.Once
+}
+
+func (t *closeHookTransport) SetCloseHandler(handler func()) {
+	t.Transport.SetCloseHandler(func() {
+		handler()
+		t.once.Do(t.onClose)
+	})
+}
+
+// The context passed to user handlers.
+// It is cancelled if the client cancels the request, and carries the RequestContext and Session of the request.
+func (s *Server) handlerContext(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) context.Context {
+	ctx := extra.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	session := s.sessionFor(ctx)
+	session.setIdentity(extra.Identity)
+	if session.Stateless() {
+		session.useRequestProtocolVersion(extra.Metadata["protocolVersion"])
+	}
+	requestContext := &RequestContext{
+		Session:           session,
+		RequestId:         extra.RequestId,
+		Method:            request.Method,
+		TransportMetadata: extra.Metadata,
+		Identity:          extra.Identity,
+	}
+	var params struct {
+		Meta map[string]json.RawMessage `json:"_meta"`
+	}
+	// Params that aren't an object are rejected by the handlers themselves
+	if len(request.Params) > 0 && json.Unmarshal(request.Params, &params) == nil && params.Meta != nil {
+		requestContext.progressToken = params.Meta["progressToken"]
+		requestContext.Meta = make(map[string]interface{}, len(params.Meta))
+		for key, raw := range params.Meta {
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err == nil {
+				requestContext.Meta[key] = value
+			}
+		}
+	}
+	ctx = context.WithValue(ctx, sessionContextKey{}, session)
+	return context.WithValue(ctx, requestContextKey{}, requestContext)
+}
+
+// Returned for requests that stateless sessions can't serve, such as resource subscriptions
+const errorCodeMethodNotFound = -32601
+
+var errStatelessSubscriptions = &protocol.RequestError{
+	Code:    errorCodeMethodNotFound,
+	Message: "resource subscriptions are not supported by stateless servers",
+}
+
+func (s *Server) handleSubscribe(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	if s.sessionFor(extra.Context).Stateless() {
+		return nil, errStatelessSubscriptions
+	}
+	var params SubscribeRequestParams
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+	if _, ok := s.resources.Load(params.Uri); !ok || !s.allowed(s.handlerContext(request, extra), FeatureKindResource, params.Uri) {
+		return nil, unknownResourceError(params.Uri)
+	}
+	s.sessionFor(extra.Context).setSubscribed(params.Uri, true)
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) handleUnsubscribe(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	if s.sessionFor(extra.Context).Stateless() {
+		return nil, errStatelessSubscriptions
+	}
+	var params UnsubscribeRequestParams
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+	s.sessionFor(extra.Context).setSubscribed(params.Uri, false)
+	return map[string]interface{}{}, nil
+}
+
+func (s *Server) handleSetLogLevel(request *transport.BaseJSONRPCRequest, extra protocol.RequestHandlerExtra) (transport.JsonRpcBody, error) {
+	var params SetLevelRequestParams
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal arguments: %w", err)
+	}
+	s.sessionFor(extra.Context).setLogLevel(params.Level)
+	return map[string]interface{}{}, nil
+}
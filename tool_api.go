@@ -7,6 +7,9 @@ import (
 
 // Definition for a tool the client can call.
 type Tool struct {
+	// Optional additional tool information.
+	Annotations *ToolAnnotations `json:"annotations,omitempty" yaml:"annotations,omitempty" mapstructure:"annotations,omitempty"`
+
 	// A human-readable description of the tool.
 	Description *string `json:"description,omitempty" yaml:"description,omitempty" mapstructure:"description,omitempty"`
 
@@ -15,6 +18,54 @@ type Tool struct {
 
 	// The name of the tool.
 	Name string `json:"name" yaml:"name" mapstructure:"name"`
+
+	// An optional JSON Schema object defining the structure of the tool's output
+	// returned in the structuredContent field of a CallToolResult.
+	OutputSchema map[string]interface{} `json:"outputSchema,omitempty" yaml:"outputSchema,omitempty" mapstructure:"outputSchema,omitempty"`
+
+	// A human-readable title for the tool, intended for display in UIs.
+	Title *string `json:"title,omitempty" yaml:"title,omitempty" mapstructure:"title,omitempty"`
+}
+
+// Additional properties describing a Tool to clients.
+//
+// NOTE: all properties in ToolAnnotations are **hints**. They are not guaranteed
+// to provide a faithful description of tool behavior (including descriptive
+// properties like `title`).
+//
+// Clients should never make tool use decisions based on ToolAnnotations received
+// from untrusted servers.
+type ToolAnnotations struct {
+	// If true, the tool may perform destructive updates to its environment. If
+	// false, the tool performs only additive updates.
+	//
+	// (This property is meaningful only when `readOnlyHint == false`)
+	//
+	// Default: true
+	DestructiveHint *bool `json:"destructiveHint,omitempty" yaml:"destructiveHint,omitempty" mapstructure:"destructiveHint,omitempty"`
+
+	// If true, calling the tool repeatedly with the same arguments will have no
+	// additional effect on its environment.
+	//
+	// (This property is meaningful only when `readOnlyHint == false`)
+	//
+	// Default: false
+	IdempotentHint *bool `json:"idempotentHint,omitempty" yaml:"idempotentHint,omitempty" mapstructure:"idempotentHint,omitempty"`
+
+	// If true, this tool may interact with an "open world" of external entities. If
+	// false, the tool's domain of interaction is closed. For example, the world of a
+	// web search tool is open, whereas that of a memory tool is not.
+	//
+	// Default: true
+	OpenWorldHint *bool `json:"openWorldHint,omitempty" yaml:"openWorldHint,omitempty" mapstructure:"openWorldHint,omitempty"`
+
+	// If true, the tool does not modify its environment.
+	//
+	// Default: false
+	ReadOnlyHint *bool `json:"readOnlyHint,omitempty" yaml:"readOnlyHint,omitempty" mapstructure:"readOnlyHint,omitempty"`
+
+	// A human-readable title for the tool.
+	Title *string `json:"title,omitempty" yaml:"title,omitempty" mapstructure:"title,omitempty"`
 }
 
 // A JSON Schema object defining the expected parameters for the tool.
@@ -115,6 +166,10 @@ func (j *Tool) UnmarshalJSON(b []byte) error {
 // We allow creation through constructors only to make sure that the ToolResponse is valid.
 type ToolResponse struct {
 	Content []*Content
+	// Whether the tool call failed. The content should then describe the error so the model can see it and recover.
+	IsError bool
+	// Sent to the client as the result's _meta, e.g. trace ids or hints for the host's UI
+	Meta CallToolResultMeta
 }
 
 func NewToolResponse(content ...*Content) *ToolResponse {
@@ -122,3 +177,70 @@ func NewToolResponse(content ...*Content) *ToolResponse {
 		Content: content,
 	}
 }
+
+// WithMeta sets the _meta of the result
+func (r *ToolResponse) WithMeta(meta map[string]interface{}) *ToolResponse {
+	r.Meta = meta
+	return r
+}
+
+// NewToolErrorResponse creates a ToolResponse for a failed tool call.
+// This is an alternative to returning an error, or a ToolError, from the handler.
+func NewToolErrorResponse(content ...*Content) *ToolResponse {
+	return &ToolResponse{
+		Content: content,
+		IsError: true,
+	}
+}
+
+type ToolOptions func(*tool)
+
+// WithToolTitle sets a human-readable title for the tool which hosts can show instead of its name.
+func WithToolTitle(title string) ToolOptions {
+	return func(t *tool) {
+		t.Title = &title
+	}
+}
+
+// WithToolAnnotations sets all of the behavioural hints for the tool at once, replacing any hints set previously.
+func WithToolAnnotations(annotations ToolAnnotations) ToolOptions {
+	return func(t *tool) {
+		t.Annotations = &annotations
+	}
+}
+
+// WithReadOnlyHint marks the tool as not modifying its environment.
+func WithReadOnlyHint(readOnly bool) ToolOptions {
+	return func(t *tool) {
+		t.annotations().ReadOnlyHint = &readOnly
+	}
+}
+
+// WithDestructiveHint marks the tool as possibly performing destructive updates.
+// Hosts use this to decide whether to ask the user for confirmation before calling the tool.
+func WithDestructiveHint(destructive bool) ToolOptions {
+	return func(t *tool) {
+		t.annotations().DestructiveHint = &destructive
+	}
+}
+
+// WithIdempotentHint marks repeated calls with the same arguments as having no additional effect.
+func WithIdempotentHint(idempotent bool) ToolOptions {
+	return func(t *tool) {
+		t.annotations().IdempotentHint = &idempotent
+	}
+}
+
+// WithOpenWorldHint marks the tool as interacting with an open world of external entities.
+func WithOpenWorldHint(openWorld bool) ToolOptions {
+	return func(t *tool) {
+		t.annotations().OpenWorldHint = &openWorld
+	}
+}
+
+// WithToolArgumentDecoding overrides the server's argument decoding mode for this tool.
+func WithToolArgumentDecoding(mode ArgumentDecodingMode) ToolOptions {
+	return func(t *tool) {
+		t.argumentDecoding = &mode
+	}
+}
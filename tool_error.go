@@ -0,0 +1,126 @@
+package mcp_golang
+
+import (
+	"encoding/json"
+)
+
+// ToolError is an error a tool handler can return to describe a failure in more detail than a plain error.
+// It is sent to the client as a tool result with isError set, so the model sees it and can decide how to recover.
+// The code and retryable flag are also sent in the result's _meta for clients that act on errors programmatically,
+// where they don't get in the way of the output schema of tools with structured output.
+type ToolError struct {
+	// A machine-readable code, e.g. "rate_limited" or "not_found"
+	Code string
+	// What went wrong, sent as text unless Content is set
+	Message string
+	// User-facing content describing the error, e.g. text with instructions or a screenshot of the failure
+	Content []*Content
+	// Whether calling the tool again with the same arguments may succeed
+	Retryable bool
+	// Results produced before the failure, sent after the error content
+	Partial []*Content
+	// The underlying cause, this is not sent to the client
+	Err error
+}
+
+// NewToolError creates a ToolError with a code and a message
+func NewToolError(code string, message string) *ToolError {
+	return &ToolError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// WithContent sets the content shown to the user in place of the message
+func (e *ToolError) WithContent(content ...*Content) *ToolError {
+	e.Content = content
+	return e
+}
+
+// WithRetryable marks whether calling the tool again with the same arguments may succeed
+func (e *ToolError) WithRetryable(retryable bool) *ToolError {
+	e.Retryable = retryable
+	return e
+}
+
+// WithPartialResults attaches results that were produced before the failure
+func (e *ToolError) WithPartialResults(content ...*Content) *ToolError {
+	e.Partial = content
+	return e
+}
+
+// WithCause records the underlying error, it can be retrieved with errors.Unwrap but is not sent to the client
+func (e *ToolError) WithCause(err error) *ToolError {
+	e.Err = err
+	return e
+}
+
+func (e *ToolError) Error() string {
+	message := e.Message
+	if e.Code != "" {
+		message = e.Code + ": " + message
+	}
+	if e.Err != nil {
+		message = message + ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+// The key of the details of a ToolError in the _meta of its result
+const toolErrorMetaKey = "toolError"
+
+// The details of an error result
+type toolErrorDetails struct {
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Partial   bool   `json:"partial,omitempty"`
+}
+
+func (e *ToolError) toolResponseSent() *toolResponseSent {
+	content := e.Content
+	if len(content) == 0 {
+		content = []*Content{NewTextContent(e.Message)}
+	}
+	content = append(append([]*Content{}, content...), e.Partial...)
+	response := NewToolErrorResponse(content...).WithMeta(map[string]interface{}{
+		toolErrorMetaKey: toolErrorDetails{
+			Code:      e.Code,
+			Message:   e.Message,
+			Retryable: e.Retryable,
+			Partial:   len(e.Partial) > 0,
+		},
+	})
+	return &toolResponseSent{
+		Response: response,
+	}
+}
+
+// ToolError returns the details of a failed tool call, or nil if the call succeeded.
+// Code and Retryable are only set if the server sent them in the result's _meta, as servers using ToolError do.
+func (r *CallToolResult) ToolError() *ToolError {
+	if r.IsError == nil || !*r.IsError {
+		return nil
+	}
+	toolError := &ToolError{Content: r.Content}
+	for _, content := range r.Content {
+		if content != nil && content.TextContent != nil {
+			toolError.Message = content.TextContent.Text
+			break
+		}
+	}
+	if details, ok := r.Meta[toolErrorMetaKey]; ok {
+		var parsed toolErrorDetails
+		b, err := json.Marshal(details)
+		if err == nil && json.Unmarshal(b, &parsed) == nil {
+			toolError.Code = parsed.Code
+			toolError.Message = parsed.Message
+			toolError.Retryable = parsed.Retryable
+		}
+	}
+	return toolError
+}
@@ -0,0 +1,225 @@
+package mcp_golang
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// WithToolTimeout fails calls to the tool that take longer than timeout.
+// The handler's context is cancelled when the timeout passes, and the client gets an error result straight away even
+// if the handler ignores its context and keeps running.
+func WithToolTimeout(timeout time.Duration) ToolOptions {
+	return func(t *tool) {
+		t.timeout = timeout
+	}
+}
+
+// WithToolMaxConcurrency limits how many calls to the tool can run at the same time.
+// Further calls wait for a running call to finish, or until they are cancelled.
+func WithToolMaxConcurrency(limit int) ToolOptions {
+	return func(t *tool) {
+		if limit > 0 {
+			t.concurrency = make(chan struct{}, limit)
+		}
+	}
+}
+
+// WithToolDeduplication makes concurrent calls to the tool with identical arguments from the same session share a
+// single invocation of the handler, every caller gets its result. This is useful for expensive, side effect free tools
+// that models like to call several times in parallel.
+// The handler runs on a context of its own, carrying the values of the call that started it, which is cancelled once
+// every caller has gone away, because they were cancelled or the server shut down. The other callers get the _meta it
+// sets on the result and the progress it reports. Calls from different sessions are never shared, as they may belong
+// to different users.
+func WithToolDeduplication() ToolOptions {
+	return func(t *tool) {
+		t.inflight = &toolCallGroup{calls: map[string]*toolCall{}}
+	}
+}
+
+// The cause of the cancellation of calls that take longer than their tool's timeout
+var errToolTimeout = errors.New("tool timed out")
+
+// Runs a tool handler with the tool's execution policies applied. A panicking handler is turned into an error result.
+func (s *Server) runTool(ctx context.Context, t *tool, params baseCallToolRequestParams) *toolResponseSent {
+	if t.inflight == nil {
+		return s.runToolWithLimits(ctx, t, params)
+	}
+	// The arguments have been through validation so they have been re-encoded with their keys in a consistent order.
+	// Results only go to callers of the same session, handlers may tailor them to the session's client or identity.
+	key := fmt.Sprintf("%s\x00%s\x00%s", s.sessionFor(ctx).ID(), t.Name, params.Arguments)
+	response, ok := t.inflight.do(ctx, key, func(ctx context.Context, call *toolCall) *toolResponseSent {
+		request := RequestContextFromContext(ctx)
+		if request != nil {
+			request.followers = func() []*RequestContext {
+				return t.inflight.followers(call)
+			}
+		}
+		response := s.runToolWithLimits(ctx, t, params)
+		// The _meta the handler set goes to every caller
+		if response != nil && response.Response != nil && request != nil {
+			shared := *response.Response
+			shared.Meta = request.resultMetaWith(shared.Meta)
+			response.Response = &shared
+		}
+		return response
+	})
+	if !ok {
+		return toolCancelled(t, ctx)
+	}
+	return response
+}
+
+func (s *Server) runToolWithLimits(ctx context.Context, t *tool, params baseCallToolRequestParams) *toolResponseSent {
+	if t.concurrency != nil {
+		select {
+		case t.concurrency <- struct{}{}:
+		case <-ctx.Done():
+			return toolCancelled(t, ctx)
+		}
+	}
+	// The slot is held until the handler returns, even if the call timed out before, so that handlers that hang can't
+	// take the tool over the limit
+	release := func() {
+		if t.concurrency != nil {
+			<-t.concurrency
+		}
+	}
+	if t.timeout <= 0 {
+		defer release()
+		return s.callToolHandler(ctx, t, params)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, t.timeout, errToolTimeout)
+	defer cancel()
+	done := make(chan *toolResponseSent, 1)
+	go func() {
+		defer release()
+		done <- s.callToolHandler(ctx, t, params)
+	}()
+	select {
+	case response := <-done:
+		return response
+	case <-ctx.Done():
+		if errors.Is(context.Cause(ctx), errToolTimeout) {
+			return newToolResponseSentError(NewToolError("timeout", fmt.Sprintf("tool %s timed out after %s", t.Name, t.timeout)).WithRetryable(true))
+		}
+		return toolCancelled(t, ctx)
+	}
+}
+
+// The result of a call that was cancelled by the client before it finished
+func toolCancelled(t *tool, ctx context.Context) *toolResponseSent {
+	return newToolResponseSentError(NewToolError("cancelled", fmt.Sprintf("call to tool %s was cancelled", t.Name)).WithCause(ctx.Err()))
+}
+
+func (s *Server) callToolHandler(ctx context.Context, t *tool, params baseCallToolRequestParams) (response *toolResponseSent) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("tool handler panicked", "tool", t.Name, "panic", r, "stack", string(debug.Stack()))
+			response = newToolResponseSentError(fmt.Errorf("tool %s failed unexpectedly", t.Name))
+		}
+	}()
+	return t.Handler(ctx, params)
+}
+
+// Runs a prompt or resource handler, turning a panic into an error
+func (s *Server) recoverHandler(kind string, name string, handler func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error(kind+" handler panicked", kind, name, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("%s %s failed unexpectedly", kind, name)
+		}
+	}()
+	handler()
+	return nil
+}
+
+// A minimal singleflight, calls with the same key that overlap share the result of the first
+type toolCallGroup struct {
+	mu    sync.Mutex
+	calls map[string]*toolCall
+}
+
+type toolCall struct {
+	done     chan struct{}
+	response *toolResponseSent
+	// Cancels the context the handler runs on
+	cancel context.CancelFunc
+	// How many callers are waiting for the call, including the one that started it, guarded by the group's lock
+	waiters int
+	// The requests waiting for the call that were made after the one that started it, guarded by the group's lock
+	followers []*RequestContext
+}
+
+// Runs fn, or waits for the call with the same key that is already running. fn runs in a goroutine of its own, on a
+// context with the values of ctx that is cancelled once every caller waiting for the call has been cancelled.
+// It returns false if ctx is cancelled before the call finishes.
+func (g *toolCallGroup) do(ctx context.Context, key string, fn func(ctx context.Context, call *toolCall) *toolResponseSent) (*toolResponseSent, bool) {
+	request := RequestContextFromContext(ctx)
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+		if request != nil {
+			call.followers = append(call.followers, request)
+		}
+	} else {
+		// Not tied to the caller that started it, which may go away while others still wait
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &toolCall{done: make(chan struct{}), cancel: cancel, waiters: 1}
+		g.calls[key] = call
+		go func() {
+			response := fn(callCtx, call)
+			g.mu.Lock()
+			call.response = response
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.copyResponse(), true
+	case <-ctx.Done():
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		call.waiters--
+		call.followers = slices.DeleteFunc(call.followers, func(follower *RequestContext) bool {
+			return follower == request
+		})
+		if call.waiters == 0 {
+			call.cancel()
+			// Later calls start afresh rather than joining one that is being cancelled
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		return nil, false
+	}
+}
+
+func (g *toolCallGroup) followers(call *toolCall) []*RequestContext {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(call.followers)
+}
+
+// Every caller gets its own copy as responses are adjusted for each client's protocol version before being sent
+func (c *toolCall) copyResponse() *toolResponseSent {
+	if c.response == nil {
+		return nil
+	}
+	response := *c.response
+	return &response
+}
@@ -9,6 +9,9 @@ import (
 // this schema, but this is not a closed set: any server can define its own,
 // additional capabilities.
 type serverCapabilities struct {
+	// Present if the server supports argument autocompletion suggestions.
+	Completions *serverCapabilitiesCompletions `json:"completions,omitempty" yaml:"completions,omitempty" mapstructure:"completions,omitempty"`
+
 	// Experimental, non-standard capabilities that the server supports.
 	Experimental serverCapabilitiesExperimental `json:"experimental,omitempty" yaml:"experimental,omitempty" mapstructure:"experimental,omitempty"`
 
@@ -25,6 +28,9 @@ type serverCapabilities struct {
 	Tools *serverCapabilitiesTools `json:"tools,omitempty" yaml:"tools,omitempty" mapstructure:"tools,omitempty"`
 }
 
+// Present if the server supports argument autocompletion suggestions.
+type serverCapabilitiesCompletions map[string]interface{}
+
 // Experimental, non-standard capabilities that the server supports.
 type serverCapabilitiesExperimental map[string]map[string]interface{}
 
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// Identity is who a transport authenticated the client as, e.g. from a bearer token or a client certificate
+type Identity struct {
+	// Subject identifies the client, e.g. the sub claim of an access token or the subject of a certificate
+	Subject string
+	// Scopes the client was granted
+	Scopes []string
+	// Claims holds everything else known about the client, e.g. the claims of its access token
+	Claims map[string]interface{}
+	// Certificate is the verified TLS client certificate the client authenticated with, if any
+	Certificate *x509.Certificate
+}
+
+// HasScope reports whether the client was granted scope
+func (i *Identity) HasScope(scope string) bool {
+	if i == nil {
+		return false
+	}
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type identityContextKey struct{}
+
+// ContextWithIdentity attaches an identity to ctx, e.g. by HTTP middleware that authenticated the request, for the
+// transport to attach to the messages the request carries
+func ContextWithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity attached to ctx with ContextWithIdentity, or nil
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityContextKey{}).(*Identity)
+	return identity
+}
+
+// IdentityFromCertificate describes the client that authenticated with cert, a verified TLS client certificate.
+// The subject is the certificate's distinguished name, or its first URI, DNS name or email address if the name is
+// empty, as is common for service certificates such as SPIFFE ones. The claims hold the names of the certificate:
+// "subject", "commonName", "issuer", "serialNumber", and its SANs in "uris", "dnsNames", "emailAddresses" and
+// "ipAddresses".
+func IdentityFromCertificate(cert *x509.Certificate) *Identity {
+	claims := map[string]interface{}{
+		"subject":      cert.Subject.String(),
+		"commonName":   cert.Subject.CommonName,
+		"issuer":       cert.Issuer.String(),
+		"serialNumber": cert.SerialNumber.String(),
+	}
+	var uris, ips []string
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	for name, sans := range map[string][]string{
+		"uris":           uris,
+		"dnsNames":       cert.DNSNames,
+		"emailAddresses": cert.EmailAddresses,
+		"ipAddresses":    ips,
+	} {
+		if len(sans) > 0 {
+			claims[name] = sans
+		}
+	}
+
+	subject := cert.Subject.String()
+	for _, sans := range [][]string{uris, cert.DNSNames, cert.EmailAddresses} {
+		if subject == "" && len(sans) > 0 {
+			subject = sans[0]
+		}
+	}
+	return &Identity{Subject: subject, Claims: claims, Certificate: cert}
+}
@@ -123,6 +123,13 @@ func (t *StdioServerTransport) readLoop(ctx context.Context) {
 				if err != io.EOF {
 					t.handleError(fmt.Errorf("read error: %w", err))
 				}
+				// The other end has gone away, e.g. the host exited, so the connection is over unless it was closed already
+				t.mu.Lock()
+				started := t.started
+				t.mu.Unlock()
+				if started {
+					t.Close()
+				}
 				return
 			}
 
@@ -0,0 +1,473 @@
+package streamablehttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// ClientTransport connects a client to a streamable HTTP server
+type ClientTransport struct {
+	url        string
+	httpClient *http.Client
+	tlsConfig  *tls.Config
+	headers    http.Header
+	// How many times in a row the client tries to reconnect to a stream, and how long it waits before doing so
+	reconnectAttempts int
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sessionId string
+	// The id of the initialize request while it is waiting for its response
+	initializeId *transport.RequestId
+	// The protocol version the server agreed to, sent with every request once known
+	protocolVersion string
+	closed          bool
+	onMessage       func(message *transport.BaseJsonRpcMessage)
+	onClose         func()
+	onError         func(error)
+}
+
+type ClientOptions func(*ClientTransport)
+
+// WithHTTPClient sets the client used to make requests, http.DefaultClient is used otherwise.
+// Pass auth.Authorizer.HTTPClient to connect to servers that require OAuth.
+func WithHTTPClient(client *http.Client) ClientOptions {
+	return func(t *ClientTransport) {
+		t.httpClient = client
+	}
+}
+
+// WithClientTLSConfig sets the TLS configuration used to connect to the server, e.g. the CAs to trust, or the client
+// certificate to present to servers that require mutual TLS. It applies to the transport of the client set with
+// WithHTTPClient if that is an *http.Transport, custom round trippers have to be configured themselves.
+func WithClientTLSConfig(config *tls.Config) ClientOptions {
+	return func(t *ClientTransport) {
+		t.tlsConfig = config
+	}
+}
+
+// WithHeader adds a header to every request made to the server
+func WithHeader(key string, value string) ClientOptions {
+	return func(t *ClientTransport) {
+		t.headers.Add(key, value)
+	}
+}
+
+// WithReconnect sets how the client reconnects to event streams that break: it makes up to attempts attempts in a
+// row, waiting delay before the first one and twice as long before each of the next, up to maxDelay, or as long as
+// the server asked. Streams of requests are resumed from the last event received, which servers only allow if they
+// keep an event store, and the stream opened with GET is reopened. By default the client makes 5 attempts, starting
+// after half a second. 0 attempts turns reconnecting off.
+func WithReconnect(attempts int, delay time.Duration, maxDelay time.Duration) ClientOptions {
+	return func(t *ClientTransport) {
+		t.reconnectAttempts = attempts
+		t.reconnectDelay = delay
+		t.maxReconnectDelay = maxDelay
+	}
+}
+
+// NewClientTransport creates a transport for the server at url, e.g. "http://localhost:8080/mcp"
+func NewClientTransport(url string, options ...ClientOptions) *ClientTransport {
+	t := &ClientTransport{
+		url:               url,
+		httpClient:        http.DefaultClient,
+		headers:           http.Header{},
+		reconnectAttempts: 5,
+		reconnectDelay:    500 * time.Millisecond,
+		maxReconnectDelay: 30 * time.Second,
+	}
+	for _, option := range options {
+		option(t)
+	}
+	if t.tlsConfig != nil {
+		t.httpClient = withTLSConfig(t.httpClient, t.tlsConfig)
+	}
+	return t
+}
+
+// Returns a copy of client that connects with config
+func withTLSConfig(client *http.Client, config *tls.Config) *http.Client {
+	var httpTransport *http.Transport
+	switch base := client.Transport.(type) {
+	case nil:
+		httpTransport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		httpTransport = base.Clone()
+	default:
+		return client
+	}
+	httpTransport.TLSClientConfig = config
+	withTLS := *client
+	withTLS.Transport = httpTransport
+	return &withTLS
+}
+
+// Start prepares the transport, the connection to the server is made when the first message is sent
+func (t *ClientTransport) Start(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx != nil {
+		return fmt.Errorf("transport already started")
+	}
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	return nil
+}
+
+// SessionID returns the id the server gave the session, empty until the server has answered the initialize request
+func (t *ClientTransport) SessionID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessionId
+}
+
+// Send posts a message to the server. Responses are handed to the message handler, either from the body of the POST
+// or from the event stream the server answers with.
+func (t *ClientTransport) Send(message *transport.BaseJsonRpcMessage) error {
+	ctx, sessionId, err := t.state()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if message.Type == transport.BaseMessageTypeJSONRPCRequestType && message.JsonRpcRequest.Method == "initialize" {
+		id := message.JsonRpcRequest.Id
+		t.mu.Lock()
+		t.initializeId = &id
+		t.mu.Unlock()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	t.setHeaders(req, sessionId)
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return responseError(resp)
+	}
+	if sessionId == "" {
+		if id := resp.Header.Get(sessionIdHeader); id != "" {
+			t.startSession(id)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		resp.Body.Close()
+	case mediaType == "text/event-stream":
+		var request *transport.RequestId
+		if message.Type == transport.BaseMessageTypeJSONRPCRequestType {
+			request = &message.JsonRpcRequest.Id
+		}
+		go t.readStream(ctx, resp.Body, request)
+	case mediaType == "application/json":
+		defer resp.Body.Close()
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		messages, _, err := parseMessages(data)
+		if err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		for _, message := range messages {
+			t.handleMessage(message)
+		}
+	default:
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Close ends the session on the server and stops every stream
+func (t *ClientTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	sessionId := t.sessionId
+	cancel := t.cancel
+	onClose := t.onClose
+	t.mu.Unlock()
+
+	var err error
+	if sessionId != "" {
+		err = t.deleteSession(sessionId)
+	}
+	if cancel != nil {
+		cancel()
+	}
+	if onClose != nil {
+		onClose()
+	}
+	return err
+}
+
+// SetCloseHandler sets the handler for when the transport is closed
+func (t *ClientTransport) SetCloseHandler(handler func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onClose = handler
+}
+
+// SetErrorHandler sets the handler for errors reading from the server
+func (t *ClientTransport) SetErrorHandler(handler func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onError = handler
+}
+
+// SetMessageHandler sets the handler for messages from the server
+func (t *ClientTransport) SetMessageHandler(handler func(message *transport.BaseJsonRpcMessage)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onMessage = handler
+}
+
+func (t *ClientTransport) state() (context.Context, string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx == nil {
+		return nil, "", fmt.Errorf("transport not started")
+	}
+	if t.closed {
+		return nil, "", fmt.Errorf("transport is closed")
+	}
+	return t.ctx, t.sessionId, nil
+}
+
+func (t *ClientTransport) setHeaders(req *http.Request, sessionId string) {
+	for key, values := range t.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if sessionId != "" {
+		req.Header.Set(sessionIdHeader, sessionId)
+	}
+	t.mu.Lock()
+	version := t.protocolVersion
+	t.mu.Unlock()
+	if version != "" {
+		req.Header.Set(protocolVersionHeader, version)
+	}
+}
+
+// Records the session id the server gave us and opens the stream for messages that aren't tied to a request
+func (t *ClientTransport) startSession(id string) {
+	t.mu.Lock()
+	if t.sessionId != "" {
+		t.mu.Unlock()
+		return
+	}
+	t.sessionId = id
+	ctx := t.ctx
+	t.mu.Unlock()
+	go t.listen(ctx)
+}
+
+func (t *ClientTransport) listen(ctx context.Context) {
+	t.readStream(ctx, nil, nil)
+}
+
+// Opens an event stream with GET, resuming the stream of lastEventID if it isn't empty. The body is nil if the server
+// doesn't offer streams. Errors tell whether trying again later could help.
+func (t *ClientTransport) openStream(ctx context.Context, lastEventID string) (io.ReadCloser, bool, error) {
+	_, sessionId, err := t.state()
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	t.setHeaders(req, sessionId)
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to open event stream: %w", err)
+	}
+	// Servers don't have to offer a stream, messages then only come in the responses to our requests
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		return nil, false, nil
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		// The server may be busy or restarting, or may not have noticed yet that the stream we reconnect to broke
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusTooManyRequests
+		return nil, retry, responseError(resp)
+	}
+	return resp.Body, false, nil
+}
+
+// Reads the messages of an event stream, opening it with GET if body is nil. Streams that break are reconnected to, as
+// set with WithReconnect: the stream of a POST, which answers request, is resumed from its last event until the
+// response arrives, and the stream opened with GET, whose request is nil, is reopened until the transport closes.
+func (t *ClientTransport) readStream(ctx context.Context, body io.ReadCloser, request *transport.RequestId) {
+	var lastEventID string
+	answered := false
+	baseDelay := t.reconnectDelay
+	delay := baseDelay
+	attempts := 0
+	var err error
+	for {
+		if body == nil {
+			// Only attempts after a failure wait
+			if err != nil {
+				if attempts >= t.reconnectAttempts {
+					t.handleError(fmt.Errorf("event stream failed, gave up reconnecting after %d attempts: %w", attempts, err))
+					return
+				}
+				attempts++
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				delay = min(delay*2, max(t.maxReconnectDelay, baseDelay))
+			}
+			var retry bool
+			body, retry, err = t.openStream(ctx, lastEventID)
+			if err == nil && body == nil {
+				return
+			}
+			if err != nil {
+				if ctx.Err() != nil || t.isClosed() {
+					return
+				}
+				if !retry {
+					t.handleError(err)
+					return
+				}
+				continue
+			}
+		}
+
+		received := false
+		err = readEvents(body, func(e event) {
+			received = true
+			if e.id != "" {
+				lastEventID = e.id
+			}
+			if e.retry > 0 {
+				baseDelay, delay = e.retry, e.retry
+			}
+			// Events without data only carry an id to resume from
+			if e.data == "" || e.name != "" && e.name != "message" {
+				return
+			}
+			message, err := parseMessage([]byte(e.data))
+			if err != nil {
+				t.handleError(fmt.Errorf("invalid message: %w", err))
+				return
+			}
+			if id, ok := responseId(message); ok && request != nil && id == *request {
+				answered = true
+			}
+			t.handleMessage(message)
+		})
+		body.Close()
+		body = nil
+		if answered || ctx.Err() != nil || t.isClosed() {
+			return
+		}
+		if err == nil {
+			err = errors.New("stream ended")
+		}
+		if request != nil && lastEventID == "" {
+			t.handleError(fmt.Errorf("event stream ended before the response to request %d, it can't be resumed: %w", *request, err))
+			return
+		}
+		if received {
+			attempts = 0
+			delay = baseDelay
+		}
+	}
+}
+
+func (t *ClientTransport) deleteSession(sessionId string) error {
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	t.setHeaders(req, sessionId)
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	defer resp.Body.Close()
+	// Servers that don't let clients end sessions answer 405
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotFound {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (t *ClientTransport) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+func (t *ClientTransport) handleMessage(message *transport.BaseJsonRpcMessage) {
+	t.mu.Lock()
+	// The server's answer to initialize tells which protocol version later requests speak
+	if t.initializeId != nil && message.Type == transport.BaseMessageTypeJSONRPCResponseType && message.JsonRpcResponse.Id == *t.initializeId {
+		var result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if json.Unmarshal(message.JsonRpcResponse.Result, &result) == nil {
+			t.protocolVersion = result.ProtocolVersion
+		}
+		t.initializeId = nil
+	}
+	handler := t.onMessage
+	t.mu.Unlock()
+	if handler != nil {
+		handler(message)
+	}
+}
+
+func (t *ClientTransport) handleError(err error) {
+	t.mu.Lock()
+	handler := t.onError
+	t.mu.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(body))
+}
@@ -0,0 +1,157 @@
+package streamablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// ErrEventNotFound is returned by event stores that don't have an event, because it never existed or was evicted
+var ErrEventNotFound = errors.New("event not found")
+
+// EventStore keeps the messages sent on event streams so that clients whose connection broke can resume a stream
+// where they left off, by reconnecting with the id of the last event they received in the Last-Event-ID header.
+// Streams are identified by ids that are unique across sessions, so one store can serve every session, and event ids
+// must be unique across streams.
+type EventStore interface {
+	// StoreEvent saves a message sent on a stream and returns the id of its event. message is nil for the event that
+	// opens a stream, which gives clients an id to resume from before any message was sent.
+	StoreEvent(ctx context.Context, streamID string, message *transport.BaseJsonRpcMessage) (string, error)
+	// StreamID returns the id of the stream an event was sent on
+	StreamID(ctx context.Context, eventID string) (string, error)
+	// ReplayEventsAfter calls send, in order, with the messages sent on the stream of eventID after it
+	ReplayEventsAfter(ctx context.Context, eventID string, send func(eventID string, message *transport.BaseJsonRpcMessage) error) error
+}
+
+// MemoryEventStore keeps events in memory, evicting the oldest ones once they take up too much space or get too old
+type MemoryEventStore struct {
+	maxSize int
+	maxAge  time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+	// Oldest first, with consecutive sequence numbers
+	events []storedEvent
+	next   uint64
+	size   int
+}
+
+type storedEvent struct {
+	seq      uint64
+	streamID string
+	// The marshalled message, nil for the event that opens a stream
+	data     []byte
+	storedAt time.Time
+}
+
+type MemoryEventStoreOptions func(*MemoryEventStore)
+
+// WithEventStoreMaxSize sets how many bytes of messages the store keeps, 16MB by default
+func WithEventStoreMaxSize(size int) MemoryEventStoreOptions {
+	return func(s *MemoryEventStore) {
+		s.maxSize = size
+	}
+}
+
+// WithEventStoreMaxAge sets how long events are kept for, 5 minutes by default
+func WithEventStoreMaxAge(age time.Duration) MemoryEventStoreOptions {
+	return func(s *MemoryEventStore) {
+		s.maxAge = age
+	}
+}
+
+// NewMemoryEventStore creates an empty memory event store
+func NewMemoryEventStore(options ...MemoryEventStoreOptions) *MemoryEventStore {
+	s := &MemoryEventStore{
+		maxSize: 16 << 20,
+		maxAge:  5 * time.Minute,
+		now:     time.Now,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// StoreEvent implements EventStore
+func (s *MemoryEventStore) StoreEvent(ctx context.Context, streamID string, message *transport.BaseJsonRpcMessage) (string, error) {
+	var data []byte
+	if message != nil {
+		var err error
+		data, err = json.Marshal(message)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal message: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	event := storedEvent{seq: s.next, streamID: streamID, data: data, storedAt: now}
+	s.next++
+	s.events = append(s.events, event)
+	s.size += len(streamID) + len(data)
+	for len(s.events) > 1 && (s.size > s.maxSize || now.Sub(s.events[0].storedAt) > s.maxAge) {
+		s.size -= len(s.events[0].streamID) + len(s.events[0].data)
+		// Lets the message be collected before the slice is reallocated
+		s.events[0] = storedEvent{}
+		s.events = s.events[1:]
+	}
+	return strconv.FormatUint(event.seq, 10), nil
+}
+
+// StreamID implements EventStore
+func (s *MemoryEventStore) StreamID(ctx context.Context, eventID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.find(eventID)
+	if err != nil {
+		return "", err
+	}
+	return s.events[i].streamID, nil
+}
+
+// ReplayEventsAfter implements EventStore
+func (s *MemoryEventStore) ReplayEventsAfter(ctx context.Context, eventID string, send func(eventID string, message *transport.BaseJsonRpcMessage) error) error {
+	// Collected first so that sending doesn't hold up other streams
+	s.mu.Lock()
+	i, err := s.find(eventID)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	var events []storedEvent
+	for _, event := range s.events[i+1:] {
+		if event.streamID == s.events[i].streamID && event.data != nil {
+			events = append(events, event)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, event := range events {
+		message, err := parseMessage(event.data)
+		if err != nil {
+			return fmt.Errorf("invalid stored message: %w", err)
+		}
+		err = send(strconv.FormatUint(event.seq, 10), message)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The index of an event, must be called with the lock held
+func (s *MemoryEventStore) find(eventID string) (int, error) {
+	seq, err := strconv.ParseUint(eventID, 10, 64)
+	if err != nil || len(s.events) == 0 || seq < s.events[0].seq || seq >= s.next {
+		return 0, fmt.Errorf("%w: %q", ErrEventNotFound, eventID)
+	}
+	return int(seq - s.events[0].seq), nil
+}
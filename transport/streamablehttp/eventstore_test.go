@@ -0,0 +1,96 @@
+package streamablehttp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func notification(method string) *transport.BaseJsonRpcMessage {
+	return transport.NewBaseMessageNotification(&transport.BaseJSONRPCNotification{Jsonrpc: "2.0", Method: method})
+}
+
+// Replays the stream of eventID and returns the methods of its notifications
+func replayed(t *testing.T, store EventStore, eventID string) ([]string, error) {
+	t.Helper()
+	var methods []string
+	err := store.ReplayEventsAfter(context.Background(), eventID, func(id string, message *transport.BaseJsonRpcMessage) error {
+		methods = append(methods, message.JsonRpcNotification.Method)
+		return nil
+	})
+	return methods, err
+}
+
+func TestMemoryEventStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("replays the events of a stream", func(t *testing.T) {
+		store := NewMemoryEventStore()
+		opened, err := store.StoreEvent(ctx, "a", nil)
+		assert.NoError(t, err)
+		first, err := store.StoreEvent(ctx, "a", notification("first"))
+		assert.NoError(t, err)
+		_, err = store.StoreEvent(ctx, "b", notification("other"))
+		assert.NoError(t, err)
+		_, err = store.StoreEvent(ctx, "a", notification("second"))
+		assert.NoError(t, err)
+
+		streamID, err := store.StreamID(ctx, first)
+		assert.NoError(t, err)
+		assert.Equal(t, "a", streamID)
+
+		methods, err := replayed(t, store, opened)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"first", "second"}, methods)
+		methods, err = replayed(t, store, first)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"second"}, methods)
+
+		_, err = store.StreamID(ctx, "42")
+		assert.ErrorIs(t, err, ErrEventNotFound)
+		_, err = replayed(t, store, "not an id")
+		assert.ErrorIs(t, err, ErrEventNotFound)
+	})
+
+	t.Run("evicts events beyond the size limit", func(t *testing.T) {
+		message := notification(strings.Repeat("x", 100))
+		store := NewMemoryEventStore(WithEventStoreMaxSize(500))
+		var ids []string
+		for range 10 {
+			id, err := store.StoreEvent(ctx, "a", message)
+			assert.NoError(t, err)
+			ids = append(ids, id)
+		}
+		_, err := store.StreamID(ctx, ids[0])
+		assert.ErrorIs(t, err, ErrEventNotFound)
+		_, err = store.StreamID(ctx, ids[9])
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, store.size, 500)
+	})
+
+	t.Run("evicts events that are too old", func(t *testing.T) {
+		now := time.Now()
+		store := NewMemoryEventStore(WithEventStoreMaxAge(time.Minute))
+		store.now = func() time.Time {
+			return now
+		}
+		old, err := store.StoreEvent(ctx, "a", notification("old"))
+		assert.NoError(t, err)
+		now = now.Add(30 * time.Second)
+		recent, err := store.StoreEvent(ctx, "a", notification("recent"))
+		assert.NoError(t, err)
+		now = now.Add(45 * time.Second)
+		_, err = store.StoreEvent(ctx, "a", notification("new"))
+		assert.NoError(t, err)
+
+		_, err = store.StreamID(ctx, old)
+		assert.ErrorIs(t, err, ErrEventNotFound)
+		methods, err := replayed(t, store, recent)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"new"}, methods)
+	})
+}
@@ -0,0 +1,148 @@
+package streamablehttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// Parses a single JSON-RPC message, or a batch of them if data is an array
+func parseMessages(data []byte) ([]*transport.BaseJsonRpcMessage, bool, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '[' {
+		message, err := parseMessage(data)
+		if err != nil {
+			return nil, false, err
+		}
+		return []*transport.BaseJsonRpcMessage{message}, false, nil
+	}
+
+	var raw []json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to unmarshal batch: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, true, errors.New("empty batch")
+	}
+	messages := make([]*transport.BaseJsonRpcMessage, 0, len(raw))
+	for _, r := range raw {
+		message, err := parseMessage(r)
+		if err != nil {
+			return nil, true, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, true, nil
+}
+
+// Works out the type of a JSON-RPC message the same way the stdio transport does
+func parseMessage(data []byte) (*transport.BaseJsonRpcMessage, error) {
+	var request transport.BaseJSONRPCRequest
+	if err := json.Unmarshal(data, &request); err == nil {
+		return transport.NewBaseMessageRequest(&request), nil
+	}
+
+	var notification transport.BaseJSONRPCNotification
+	if err := json.Unmarshal(data, &notification); err == nil {
+		return transport.NewBaseMessageNotification(&notification), nil
+	}
+
+	var response transport.BaseJSONRPCResponse
+	if err := json.Unmarshal(data, &response); err == nil {
+		return transport.NewBaseMessageResponse(&response), nil
+	}
+
+	var errorResponse transport.BaseJSONRPCError
+	if err := json.Unmarshal(data, &errorResponse); err == nil {
+		return transport.NewBaseMessageError(&errorResponse), nil
+	}
+
+	return nil, errors.New("failed to unmarshal JSON-RPC message, unrecognized type")
+}
+
+// Writes a message as a server-sent event and flushes it to the client. The event gets an id if id isn't empty, and
+// carries no message if message is nil, which is how a stream hands clients an id to resume it from before any
+// message was sent.
+func writeEvent(w io.Writer, id string, message *transport.BaseJsonRpcMessage) error {
+	var buf bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	if message == nil {
+		buf.WriteString("data: \n\n")
+	} else {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		fmt.Fprintf(&buf, "event: message\ndata: %s\n\n", data)
+	}
+	_, err := w.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if flusher, ok := w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// event is a server-sent event
+type event struct {
+	id   string
+	name string
+	data string
+	// How long the server asks clients to wait before reconnecting, zero if it didn't say
+	retry time.Duration
+}
+
+// Reads server-sent events from r, calling handle for each of them until the stream ends
+func readEvents(r io.Reader, handle func(event)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	var current event
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// Events without data still move the id clients resume from
+			if len(data) > 0 || current.id != "" {
+				current.data = strings.Join(data, "\n")
+				handle(current)
+			}
+			current = event{}
+			data = nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			current.name = value
+		case "data":
+			data = append(data, value)
+		case "id":
+			// Ids with a null character are ignored, as the spec of server-sent events asks
+			if !strings.Contains(value, "\x00") {
+				current.id = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				current.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,155 @@
+package streamablehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Browsers may send these headers cross origin, and read these from responses, once the origin is allowed
+const (
+	corsAllowMethods  = "GET, POST, DELETE"
+	corsAllowHeaders  = "Accept, Authorization, Content-Type, Last-Event-ID, Mcp-Protocol-Version, Mcp-Session-Id"
+	corsExposeHeaders = "Mcp-Session-Id, WWW-Authenticate"
+	// How long, in seconds, browsers may cache the answer to a preflight request
+	corsMaxAge = "600"
+)
+
+// WithAllowedOrigins sets the origins of the web pages that may make requests to the transport, e.g.
+// "https://app.example.com", and answers their CORS requests. "*" allows every origin.
+// Requests without an Origin header don't come from browsers and are always allowed. By default only pages served
+// from the transport's own host are allowed, as the MCP spec requires servers to check the Origin of requests, and
+// only when that host is a loopback host or one set with WithAllowedHosts: a page on a domain that was rebound to the
+// server's address is served from the host its requests are addressed to as well.
+func WithAllowedOrigins(origins ...string) ServerOptions {
+	return func(t *ServerTransport) {
+		t.allowedOrigins = origins
+	}
+}
+
+// WithAllowedHosts sets the host names requests may be addressed to, with or without a port, e.g. "mcp.example.com"
+// or "localhost:8080". "*" allows every host.
+// By default requests that reach the transport on a loopback address must be addressed to a loopback host such as
+// "localhost", which stops web pages from reaching local servers by rebinding their own domain to 127.0.0.1.
+// Requests on other addresses are allowed for every host.
+func WithAllowedHosts(hosts ...string) ServerOptions {
+	return func(t *ServerTransport) {
+		t.allowedHosts = hosts
+	}
+}
+
+type originCheckedKey struct{}
+
+// Middleware applies the transport's host and origin checks and CORS handling to next, for handlers in front of the
+// transport such as authentication middleware, which would otherwise answer requests before the transport checks
+// them:
+//
+//	http.Handle("/mcp", httpTransport.Middleware(authenticator.Handler(httpTransport)))
+func (t *ServerTransport) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.checkOrigin(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originCheckedKey{}, true)))
+	})
+}
+
+// Checks the Host and Origin of a request and adds CORS headers to the response. It returns false if the request was
+// answered, because it was rejected or was a CORS preflight request.
+func (t *ServerTransport) checkOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if checked, _ := r.Context().Value(originCheckedKey{}).(bool); checked {
+		return true
+	}
+	if !t.hostAllowed(r) {
+		writeError(w, http.StatusForbidden, "host not allowed")
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !t.originAllowed(origin, r) {
+		writeError(w, http.StatusForbidden, "origin not allowed")
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+	return true
+}
+
+func (t *ServerTransport) hostAllowed(r *http.Request) bool {
+	hostname := requestHostname(r)
+	if len(t.allowedHosts) == 0 {
+		return !onLoopback(r) || isLoopbackHost(hostname)
+	}
+	for _, allowed := range t.allowedHosts {
+		if allowed == "*" || strings.EqualFold(allowed, r.Host) || strings.EqualFold(allowed, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ServerTransport) originAllowed(origin string, r *http.Request) bool {
+	if slices.Contains(t.allowedOrigins, "*") {
+		return true
+	}
+	for _, allowed := range t.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	// Pages served by the transport's own host are same origin, as long as the host is known to be ours rather than
+	// taken from the request
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, r.Host) {
+		return false
+	}
+	hostname := requestHostname(r)
+	return isLoopbackHost(hostname) || slices.ContainsFunc(t.allowedHosts, func(allowed string) bool {
+		return strings.EqualFold(allowed, r.Host) || strings.EqualFold(allowed, hostname)
+	})
+}
+
+// The host a request is addressed to, without the port
+func requestHostname(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// Whether the request reached us on a loopback address
+func onLoopback(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isLoopbackHost(hostname string) bool {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	if hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(hostname, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
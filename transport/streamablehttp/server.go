@@ -0,0 +1,554 @@
+// Package streamablehttp implements the streamable HTTP transport of the MCP specification.
+//
+// Clients POST JSON-RPC messages to a single endpoint. Responses to requests are sent back in the body of the POST,
+// either as JSON or as a stream of server-sent events that can also carry the server's notifications and requests
+// while the request is being handled. Clients can also open a standalone event stream with GET to receive messages
+// that aren't tied to a request.
+//
+// A server transport carries many sessions, each client gets its own session when it sends an initialize request,
+// and the session id in the Mcp-Session-Id header routes its later messages to it.
+//
+// Event streams can be made resumable with WithEventStore, clients then reconnect to streams that broke and get the
+// messages they missed. The client transport does so on its own, see WithReconnect.
+//
+// Usage:
+//
+//	httpTransport := streamablehttp.NewServerTransport(streamablehttp.WithAddr("localhost:8080"))
+//	server := mcp_golang.NewServer(httpTransport)
+//	err := server.Serve()
+//
+// Or mount the transport on your own mux, it is an http.Handler:
+//
+//	httpTransport := streamablehttp.NewServerTransport()
+//	http.Handle("/mcp", httpTransport)
+//
+// The transport checks the Host and Origin headers of requests so that web pages can't use the browsers of its users
+// to reach it, see WithAllowedHosts and WithAllowedOrigins. Servers meant for local clients should listen on a
+// loopback address such as "localhost:8080" rather than on every interface.
+package streamablehttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+const (
+	sessionIdHeader = "Mcp-Session-Id"
+	// The protocol version the client negotiated, which clients send with every request after initialization
+	protocolVersionHeader = "Mcp-Protocol-Version"
+	// Bodies larger than this are rejected
+	maxMessageSize = 4 << 20
+	// How many messages are kept for a session while it has no stream to send them on
+	maxBacklog = 1000
+	// How long Close waits for streams to finish writing before dropping the connections
+	shutdownTimeout = 5 * time.Second
+	// How long sessions last without requests from their client by default
+	defaultSessionIdleTimeout = 30 * time.Minute
+)
+
+// ServerTransport serves the streamable HTTP transport. It is a transport.SessionListener, the server starts a
+// session for each client that initializes.
+type ServerTransport struct {
+	addr           string
+	tlsConfig      *tls.Config
+	stateless      bool
+	eventStore     EventStore
+	allowedOrigins []string
+	allowedHosts   []string
+	idleTimeout    time.Duration
+	httpServer     *http.Server
+	listener       net.Listener
+
+	mu        sync.Mutex
+	sessions  map[string]*sessionTransport
+	closed    bool
+	onSession func(transport.Transport)
+	onClose   func()
+	onError   func(error)
+}
+
+type ServerOptions func(*ServerTransport)
+
+// WithAddr makes Start listen on addr, e.g. "localhost:8080", and serve the transport on every path.
+// Without it the transport has to be mounted on an http.Server by the caller.
+func WithAddr(addr string) ServerOptions {
+	return func(t *ServerTransport) {
+		t.addr = addr
+	}
+}
+
+// WithTLSConfig makes Start serve HTTPS with config, which must hold the server's certificate.
+// For mutual TLS set config.ClientAuth to tls.RequireAndVerifyClientCert and config.ClientCAs to the CAs of the
+// clients. The verified certificate of a client becomes the identity of its session, unless middleware in front of
+// the transport authenticated the client otherwise, see transport.IdentityFromCertificate.
+func WithTLSConfig(config *tls.Config) ServerOptions {
+	return func(t *ServerTransport) {
+		t.tlsConfig = config
+	}
+}
+
+// WithStateless runs the transport without sessions, for servers running as many replicas behind a load balancer
+// without sticky sessions. Every POST is answered on its own, in a session that only lasts for the request, and no
+// session id is given to clients. Clients can't open a stream with GET, and the server can't send them requests, such
+// as elicitations, or notifications other than those about the request being handled. Sessions speak the protocol
+// version clients send in the Mcp-Protocol-Version header, as the client transport does once initialized.
+func WithStateless() ServerOptions {
+	return func(t *ServerTransport) {
+		t.stateless = true
+	}
+}
+
+// WithEventStore makes the transport's event streams resumable: their events get ids and their messages are kept in
+// store, so that clients whose connection broke can reconnect with the Last-Event-ID header and get the messages they
+// missed, including the responses to requests that were still being handled. Stateless transports ignore it.
+func WithEventStore(store EventStore) ServerOptions {
+	return func(t *ServerTransport) {
+		t.eventStore = store
+	}
+}
+
+// WithSessionIdleTimeout ends sessions whose client hasn't made a request for timeout, 30 minutes by default, as
+// clients that go away without deleting their session would otherwise keep it forever. A session doesn't expire while
+// one of its requests is in progress, such as an open stream. A timeout of 0 or less keeps sessions until they are
+// deleted.
+func WithSessionIdleTimeout(timeout time.Duration) ServerOptions {
+	return func(t *ServerTransport) {
+		t.idleTimeout = timeout
+	}
+}
+
+// NewServerTransport creates a streamable HTTP server transport
+func NewServerTransport(options ...ServerOptions) *ServerTransport {
+	t := &ServerTransport{
+		sessions:    map[string]*sessionTransport{},
+		idleTimeout: defaultSessionIdleTimeout,
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+// Start starts listening if an address was given with WithAddr, it returns once the listener is bound
+func (t *ServerTransport) Start(ctx context.Context) error {
+	if t.addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", t.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", t.addr, err)
+	}
+	t.mu.Lock()
+	t.listener = listener
+	t.httpServer = &http.Server{Handler: t, TLSConfig: t.tlsConfig}
+	httpServer := t.httpServer
+	t.mu.Unlock()
+	go func() {
+		var err error
+		if t.tlsConfig != nil {
+			err = httpServer.ServeTLS(listener, "", "")
+		} else {
+			err = httpServer.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.handleError(fmt.Errorf("http server stopped: %w", err))
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the transport is listening on, which is useful when listening on port 0.
+// It returns nil if the transport isn't listening itself.
+func (t *ServerTransport) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listener == nil {
+		return nil
+	}
+	return t.listener.Addr()
+}
+
+// Send is not supported, messages are sent through the transport of each session
+func (t *ServerTransport) Send(message *transport.BaseJsonRpcMessage) error {
+	return fmt.Errorf("messages must be sent through the transport of a session")
+}
+
+// Close ends every session and stops listening
+func (t *ServerTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	sessions := make([]*sessionTransport, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	httpServer := t.httpServer
+	onClose := t.onClose
+	t.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+	var err error
+	if httpServer != nil {
+		// The streams of the closed sessions end once their last messages are written
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = httpServer.Shutdown(ctx)
+		if err != nil {
+			err = httpServer.Close()
+		}
+	}
+	if onClose != nil {
+		onClose()
+	}
+	return err
+}
+
+// SetCloseHandler sets the handler for when the transport is closed
+func (t *ServerTransport) SetCloseHandler(handler func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onClose = handler
+}
+
+// SetErrorHandler sets the handler for errors that aren't tied to a session
+func (t *ServerTransport) SetErrorHandler(handler func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onError = handler
+}
+
+// SetMessageHandler does nothing, messages are handled by the transport of each session
+func (t *ServerTransport) SetMessageHandler(handler func(message *transport.BaseJsonRpcMessage)) {
+}
+
+// SetSessionHandler sets the handler for new sessions, it must be set before the transport starts serving requests
+func (t *ServerTransport) SetSessionHandler(handler func(session transport.Transport)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onSession = handler
+}
+
+func (t *ServerTransport) handleError(err error) {
+	t.mu.Lock()
+	handler := t.onError
+	t.mu.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (t *ServerTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !t.checkOrigin(w, r) {
+		return
+	}
+	if t.stateless && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed, the server is stateless")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		t.handlePost(w, r)
+	case http.MethodGet:
+		t.handleGet(w, r)
+	case http.MethodDelete:
+		t.handleDelete(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (t *ServerTransport) handlePost(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body")
+		return
+	}
+	if len(body) > maxMessageSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "message too large")
+		return
+	}
+	messages, batch, err := parseMessages(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid message: %s", err))
+		return
+	}
+
+	identity := requestIdentity(r)
+	var session *sessionTransport
+	if t.stateless {
+		// Session ids sent by clients that talked to a stateful server before are ignored
+		// The session only lasts for the request, so it has no owner to check later requests against
+		session, err = t.newSession("")
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		defer session.Close()
+	} else if r.Header.Get(sessionIdHeader) == "" {
+		if !containsInitialize(messages) {
+			writeError(w, http.StatusBadRequest, "missing session id")
+			return
+		}
+		owner, err := sessionOwner(identity)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		session, err = t.newSession(owner)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		defer session.endRequest()
+	} else {
+		session = t.authorizedSession(w, r)
+		if session == nil {
+			return
+		}
+		defer session.endRequest()
+	}
+	if !t.stateless {
+		w.Header().Set(sessionIdHeader, session.id)
+	}
+
+	metadata := requestMetadata(r)
+	var requestIds []transport.RequestId
+	for _, message := range messages {
+		message.Metadata = metadata
+		message.Identity = identity
+		if message.Type == transport.BaseMessageTypeJSONRPCRequestType {
+			requestIds = append(requestIds, message.JsonRpcRequest.Id)
+		}
+	}
+	// Responses and notifications from the client are only acknowledged
+	if len(requestIds) == 0 {
+		for _, message := range messages {
+			session.handleMessage(message)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	events := acceptsEventStream(r)
+	s := newStream(session.newStreamID(), events)
+	session.addStream(s, requestIds)
+	defer session.removeStream(s)
+	for _, message := range messages {
+		session.handleMessage(message)
+	}
+	if events {
+		if session.startEvents(w, s) != nil {
+			session.keepStoring(s, len(requestIds))
+			return
+		}
+		session.writeEvents(w, r, s, len(requestIds))
+		return
+	}
+	session.writeJSON(w, r, s, len(requestIds), batch)
+}
+
+func (t *ServerTransport) handleGet(w http.ResponseWriter, r *http.Request) {
+	if !acceptsEventStream(r) {
+		writeError(w, http.StatusNotAcceptable, "accept must include text/event-stream")
+		return
+	}
+	session := t.authorizedSession(w, r)
+	if session == nil {
+		return
+	}
+	defer session.endRequest()
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" && session.eventStore() != nil {
+		session.resume(w, r, lastEventID)
+		return
+	}
+	s := newStream(session.standaloneStreamID(), true)
+	if !session.setStandaloneStream(s) {
+		writeError(w, http.StatusConflict, "the session already has an open stream")
+		return
+	}
+	defer session.removeStream(s)
+	w.Header().Set(sessionIdHeader, session.id)
+	if session.startEvents(w, s) != nil {
+		return
+	}
+	session.writeEvents(w, r, s, -1)
+}
+
+func (t *ServerTransport) handleDelete(w http.ResponseWriter, r *http.Request) {
+	session := t.authorizedSession(w, r)
+	if session == nil {
+		return
+	}
+	defer session.endRequest()
+	session.Close()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (t *ServerTransport) newSession(owner string) (*sessionTransport, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("transport is closed")
+	}
+	handler := t.onSession
+	if handler == nil {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("transport is not accepting sessions")
+	}
+	session := newSessionTransport(uuid.NewString(), t, owner)
+	// The request starting the session is in progress
+	session.beginRequest()
+	t.sessions[session.id] = session
+	t.mu.Unlock()
+
+	handler(session)
+	return session, nil
+}
+
+func (t *ServerTransport) session(id string) *sessionTransport {
+	if id == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessions[id]
+}
+
+// Finds the session of a request, answering it with an error if there is none or if it belongs to another client.
+// The request then counts as in progress until endRequest is called.
+func (t *ServerTransport) authorizedSession(w http.ResponseWriter, r *http.Request) *sessionTransport {
+	session := t.session(r.Header.Get(sessionIdHeader))
+	if session == nil {
+		writeError(w, http.StatusNotFound, "session not found")
+		return nil
+	}
+	// Knowing a session id isn't enough to take over the session of another client
+	owner, err := sessionOwner(requestIdentity(r))
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return nil
+	}
+	if session.owner != owner {
+		writeError(w, http.StatusForbidden, "session belongs to another client")
+		return nil
+	}
+	// The session may have expired since it was found
+	if !session.beginRequest() {
+		writeError(w, http.StatusNotFound, "session not found")
+		return nil
+	}
+	return session
+}
+
+// Who the client of a request is, as told by authentication middleware in front of the transport or by the client's
+// TLS certificate. Nil if the client is anonymous.
+func requestIdentity(r *http.Request) *transport.Identity {
+	if identity := transport.IdentityFromContext(r.Context()); identity != nil {
+		return identity
+	}
+	// Only certificates that were verified against the configured CAs identify a client
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return transport.IdentityFromCertificate(r.TLS.VerifiedChains[0][0])
+	}
+	return nil
+}
+
+// The key binding a session to the client that started it, empty for anonymous clients. Authenticated clients are
+// bound to the issuer and subject of their identity, or else to their certificate or OAuth client, and are refused a
+// session when their identity names none of these, since they would otherwise share the key of anonymous clients.
+func sessionOwner(identity *transport.Identity) (string, error) {
+	if identity == nil {
+		return "", nil
+	}
+	issuer, _ := identity.Claims["iss"].(string)
+	if identity.Subject != "" {
+		return "subject:" + issuer + "\x00" + identity.Subject, nil
+	}
+	if identity.Certificate != nil {
+		fingerprint := sha256.Sum256(identity.Certificate.Raw)
+		return "certificate:" + hex.EncodeToString(fingerprint[:]), nil
+	}
+	if clientId, _ := identity.Claims["client_id"].(string); clientId != "" {
+		return "client:" + issuer + "\x00" + clientId, nil
+	}
+	return "", errors.New("identity has no subject to bind the session to")
+}
+
+func (t *ServerTransport) removeSession(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, id)
+}
+
+// Information about the HTTP request that carried a message, passed to handlers as transport metadata
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{
+		"remoteAddr": r.RemoteAddr,
+	}
+	if userAgent := r.UserAgent(); userAgent != "" {
+		metadata["userAgent"] = userAgent
+	}
+	// Stateless sessions never see the initialize request, this is how they learn the version
+	if version := r.Header.Get(protocolVersionHeader); version != "" {
+		metadata["protocolVersion"] = version
+	}
+	return metadata
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInitialize(messages []*transport.BaseJsonRpcMessage) bool {
+	for _, message := range messages {
+		if message.Type == transport.BaseMessageTypeJSONRPCRequestType && message.JsonRpcRequest.Method == "initialize" {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors that happen before a message reaches a session are reported as JSON-RPC errors without an id
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      nil,
+		"error": map[string]interface{}{
+			"code":    -32000,
+			"message": message,
+		},
+	})
+}
@@ -0,0 +1,738 @@
+package streamablehttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metoro-io/mcp-golang/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+const initializeRequest = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`
+
+// Starts a transport whose sessions answer every request with the method that was called
+func newEchoServer(t *testing.T, options ...ServerOptions) (*ServerTransport, *httptest.Server, chan transport.Transport) {
+	t.Helper()
+	tr := NewServerTransport(options...)
+	sessions := make(chan transport.Transport, 10)
+	tr.SetSessionHandler(func(session transport.Transport) {
+		session.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+			if message.Type != transport.BaseMessageTypeJSONRPCRequestType {
+				return
+			}
+			result, _ := json.Marshal(map[string]string{"method": message.JsonRpcRequest.Method})
+			go session.Send(transport.NewBaseMessageResponse(&transport.BaseJSONRPCResponse{
+				Jsonrpc: "2.0",
+				Id:      message.JsonRpcRequest.Id,
+				Result:  result,
+			}))
+		})
+		sessions <- session
+	})
+	httpServer := httptest.NewServer(tr)
+	t.Cleanup(func() {
+		tr.Close()
+		httpServer.Close()
+	})
+	return tr, httpServer, sessions
+}
+
+func post(t *testing.T, url string, sessionId string, accept string, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if sessionId != "" {
+		req.Header.Set(sessionIdHeader, sessionId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func TestServerTransport(t *testing.T) {
+	t.Run("requires initialize to start a session", func(t *testing.T) {
+		_, httpServer, _ := newEchoServer(t)
+		resp := post(t, httpServer.URL, "", "application/json", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = post(t, httpServer.URL, "unknown", "application/json", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("responds with JSON", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t)
+		resp := post(t, httpServer.URL, "", "application/json", initializeRequest)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		session := <-sessions
+		assert.Equal(t, session.(transport.SessionTransport).SessionID(), resp.Header.Get(sessionIdHeader))
+
+		var response transport.BaseJSONRPCResponse
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+		assert.Equal(t, transport.RequestId(1), response.Id)
+		assert.JSONEq(t, `{"method":"initialize"}`, string(response.Result))
+
+		batch := post(t, httpServer.URL, resp.Header.Get(sessionIdHeader), "application/json",
+			`[{"jsonrpc":"2.0","id":2,"method":"a"},{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","id":3,"method":"b"}]`)
+		defer batch.Body.Close()
+		var responses []transport.BaseJSONRPCResponse
+		assert.NoError(t, json.NewDecoder(batch.Body).Decode(&responses))
+		assert.Len(t, responses, 2)
+	})
+
+	t.Run("streams messages as events", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t)
+		resp := post(t, httpServer.URL, "", "application/json", initializeRequest)
+		resp.Body.Close()
+		sessionId := resp.Header.Get(sessionIdHeader)
+		session := <-sessions
+
+		// Sent before the client opens a stream, so it waits for one
+		err := session.Send(transport.NewBaseMessageNotification(&transport.BaseJSONRPCNotification{Jsonrpc: "2.0", Method: "early"}))
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set(sessionIdHeader, sessionId)
+		stream, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer stream.Body.Close()
+		assert.Equal(t, "text/event-stream", stream.Header.Get("Content-Type"))
+
+		second, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		second.Body.Close()
+		assert.Equal(t, http.StatusConflict, second.StatusCode)
+
+		err = session.Send(transport.NewBaseMessageNotification(&transport.BaseJSONRPCNotification{Jsonrpc: "2.0", Method: "late"}))
+		assert.NoError(t, err)
+
+		var methods []string
+		reader := bufio.NewReader(stream.Body)
+		for len(methods) < 2 {
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				message, err := parseMessage([]byte(data))
+				assert.NoError(t, err)
+				methods = append(methods, message.JsonRpcNotification.Method)
+			}
+		}
+		assert.Equal(t, []string{"early", "late"}, methods)
+	})
+
+	t.Run("resumes event streams from the last event", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t, WithEventStore(NewMemoryEventStore()))
+		resp := post(t, httpServer.URL, "", "application/json", initializeRequest)
+		resp.Body.Close()
+		sessionId := resp.Header.Get(sessionIdHeader)
+		session := <-sessions
+		requests := make(chan *transport.BaseJsonRpcMessage, 10)
+		session.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+			requests <- message
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpServer.URL, strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"slow"}`))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		req.Header.Set(sessionIdHeader, sessionId)
+		stream, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		// The stream opens with an event that only carries an id
+		line, err := bufio.NewReader(stream.Body).ReadString('\n')
+		assert.NoError(t, err)
+		lastEventID, ok := strings.CutPrefix(strings.TrimSpace(line), "id: ")
+		assert.True(t, ok)
+		<-requests
+		// The client goes away before the response is sent
+		cancel()
+		stream.Body.Close()
+		err = session.Send(transport.NewBaseMessageResponse(&transport.BaseJSONRPCResponse{Jsonrpc: "2.0", Id: 2, Result: json.RawMessage(`{}`)}))
+		assert.NoError(t, err)
+
+		resume := func(sessionId string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+			assert.NoError(t, err)
+			req.Header.Set("Accept", "text/event-stream")
+			req.Header.Set("Last-Event-ID", lastEventID)
+			req.Header.Set(sessionIdHeader, sessionId)
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			return resp
+		}
+		resumed := resume(sessionId)
+		defer resumed.Body.Close()
+		assert.Equal(t, http.StatusOK, resumed.StatusCode)
+		var eventID string
+		var response *transport.BaseJsonRpcMessage
+		err = readEvents(resumed.Body, func(e event) {
+			eventID = e.id
+			response, err = parseMessage([]byte(e.data))
+			assert.NoError(t, err)
+		})
+		assert.NoError(t, err)
+		if assert.NotNil(t, response) {
+			assert.Equal(t, transport.RequestId(2), response.JsonRpcResponse.Id)
+		}
+		assert.NotEmpty(t, eventID)
+		assert.NotEqual(t, lastEventID, eventID)
+
+		// Knowing an event id isn't enough to read the streams of another session
+		resp = post(t, httpServer.URL, "", "application/json", initializeRequest)
+		resp.Body.Close()
+		<-sessions
+		other := resume(resp.Header.Get(sessionIdHeader))
+		other.Body.Close()
+		assert.Equal(t, http.StatusNotFound, other.StatusCode)
+	})
+
+	t.Run("sessions belong to the identity that started them", func(t *testing.T) {
+		tr := NewServerTransport()
+		identities := make(chan *transport.Identity, 10)
+		tr.SetSessionHandler(func(session transport.Transport) {
+			session.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+				identities <- message.Identity
+			})
+		})
+		httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var identity *transport.Identity
+			switch user := r.Header.Get("X-User"); {
+			case user == "anonymous":
+				tr.ServeHTTP(w, r)
+				return
+			case strings.HasPrefix(user, "client:"):
+				identity = &transport.Identity{Claims: map[string]interface{}{"client_id": strings.TrimPrefix(user, "client:")}}
+			default:
+				identity = &transport.Identity{Subject: user}
+			}
+			tr.ServeHTTP(w, r.WithContext(transport.ContextWithIdentity(r.Context(), identity)))
+		}))
+		defer func() {
+			tr.Close()
+			httpServer.Close()
+		}()
+
+		postAs := func(user string, sessionId string, body string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, httpServer.URL, strings.NewReader(body))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json, text/event-stream")
+			req.Header.Set("X-User", user)
+			if sessionId != "" {
+				req.Header.Set(sessionIdHeader, sessionId)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			return resp
+		}
+		notification := `{"jsonrpc":"2.0","method":"notifications/initialized"}`
+		resp := postAs("alice", "", `{"jsonrpc":"2.0","method":"initialize","id":1,"params":{}}`)
+		sessionId := resp.Header.Get(sessionIdHeader)
+		assert.Equal(t, "alice", (<-identities).Subject)
+
+		resp = postAs("bob", sessionId, notification)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = postAs("alice", sessionId, notification)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		assert.Equal(t, "alice", (<-identities).Subject)
+
+		// Authenticated clients without a subject are bound to what else identifies them
+		resp = postAs("anonymous", "", `{"jsonrpc":"2.0","method":"initialize","id":1,"params":{}}`)
+		anonymousId := resp.Header.Get(sessionIdHeader)
+		<-identities
+		resp = postAs("client:web", anonymousId, notification)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = postAs("client:web", "", `{"jsonrpc":"2.0","method":"initialize","id":1,"params":{}}`)
+		clientId := resp.Header.Get(sessionIdHeader)
+		<-identities
+		resp = postAs("anonymous", clientId, notification)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = postAs("client:web", clientId, notification)
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		<-identities
+
+		// and refused a session if nothing does
+		resp = postAs("", "", `{"jsonrpc":"2.0","method":"initialize","id":1,"params":{}}`)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = postAs("", anonymousId, notification)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("stateless requests are answered without a session", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t, WithStateless())
+		for i := 0; i < 2; i++ {
+			resp := post(t, httpServer.URL, "", "application/json, text/event-stream", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get(sessionIdHeader))
+
+			session := <-sessions
+			assert.True(t, session.(transport.StatelessTransport).Stateless())
+			err := session.Send(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 1, Method: "roots/list"}))
+			assert.ErrorContains(t, err, "stateless")
+		}
+
+		req, err := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("delete ends the session", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t)
+		resp := post(t, httpServer.URL, "", "application/json", initializeRequest)
+		resp.Body.Close()
+		sessionId := resp.Header.Get(sessionIdHeader)
+		session := <-sessions
+		closed := make(chan struct{})
+		session.SetCloseHandler(func() {
+			close(closed)
+		})
+
+		req, err := http.NewRequest(http.MethodDelete, httpServer.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set(sessionIdHeader, sessionId)
+		deleted, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		deleted.Body.Close()
+		assert.Equal(t, http.StatusOK, deleted.StatusCode)
+
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("session was not closed")
+		}
+		resp = post(t, httpServer.URL, sessionId, "application/json", `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("idle sessions expire", func(t *testing.T) {
+		_, httpServer, sessions := newEchoServer(t, WithSessionIdleTimeout(100*time.Millisecond))
+		resp := post(t, httpServer.URL, "", "application/json", initializeRequest)
+		resp.Body.Close()
+		sessionId := resp.Header.Get(sessionIdHeader)
+		session := <-sessions
+		closed := make(chan struct{})
+		session.SetCloseHandler(func() {
+			close(closed)
+		})
+
+		// An open stream keeps the session alive
+		req, err := http.NewRequest(http.MethodGet, httpServer.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set(sessionIdHeader, sessionId)
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := http.DefaultClient.Do(req.WithContext(ctx))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, stream.StatusCode)
+		select {
+		case <-closed:
+			t.Fatal("session with an open stream expired")
+		case <-time.After(300 * time.Millisecond):
+		}
+		resp = post(t, httpServer.URL, sessionId, "application/json", `{"jsonrpc":"2.0","id":2,"method":"ping"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		cancel()
+		stream.Body.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("idle session was not closed")
+		}
+		resp = post(t, httpServer.URL, sessionId, "application/json", `{"jsonrpc":"2.0","id":3,"method":"ping"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestOriginValidation(t *testing.T) {
+	request := func(url string, method string, host string, origin string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader(initializeRequest))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		if host != "" {
+			req.Host = host
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("loopback servers only answer loopback hosts", func(t *testing.T) {
+		_, httpServer, _ := newEchoServer(t)
+		port := httpServer.URL[strings.LastIndex(httpServer.URL, ":"):]
+		// A page on a domain that was rebound to 127.0.0.1
+		resp := request(httpServer.URL, http.MethodPost, "rebound.example.com"+port, "http://rebound.example.com"+port)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = request(httpServer.URL, http.MethodPost, "rebound.example.com"+port, "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		for _, host := range []string{"localhost", "127.0.0.1", "[::1]"} {
+			resp = request(httpServer.URL, http.MethodPost, host+port, "")
+			assert.Equal(t, http.StatusOK, resp.StatusCode, host)
+		}
+	})
+
+	t.Run("only same origin pages are allowed by default", func(t *testing.T) {
+		_, httpServer, _ := newEchoServer(t)
+		resp := request(httpServer.URL, http.MethodPost, "", "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		resp = request(httpServer.URL, http.MethodOptions, "", "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp = request(httpServer.URL, http.MethodPost, "", httpServer.URL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, httpServer.URL, resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("same origin needs a host that is known to be ours", func(t *testing.T) {
+		tr, _, _ := newEchoServer(t)
+		// Served without a local address, as on a server listening on every interface
+		serve := func(host string, origin string) int {
+			req := httptest.NewRequest(http.MethodPost, "http://"+host, strings.NewReader(initializeRequest))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set("Origin", origin)
+			recorder := httptest.NewRecorder()
+			tr.ServeHTTP(recorder, req)
+			return recorder.Code
+		}
+		// A page on a domain that was rebound to the server's address
+		assert.Equal(t, http.StatusForbidden, serve("rebound.example.com:8080", "http://rebound.example.com:8080"))
+		assert.Equal(t, http.StatusOK, serve("localhost:8080", "http://localhost:8080"))
+
+		tr, _, _ = newEchoServer(t, WithAllowedHosts("mcp.example.com", "*"))
+		assert.Equal(t, http.StatusOK, serve("mcp.example.com", "https://mcp.example.com"))
+		assert.Equal(t, http.StatusForbidden, serve("rebound.example.com", "https://rebound.example.com"))
+	})
+
+	t.Run("allowed origins get CORS headers", func(t *testing.T) {
+		_, httpServer, _ := newEchoServer(t, WithAllowedOrigins("https://app.example.com"))
+		resp := request(httpServer.URL, http.MethodOptions, "", "https://app.example.com")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Methods"), http.MethodPost)
+		assert.Contains(t, resp.Header.Get("Access-Control-Allow-Headers"), sessionIdHeader)
+
+		resp = request(httpServer.URL, http.MethodPost, "", "https://app.example.com")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Contains(t, resp.Header.Get("Access-Control-Expose-Headers"), sessionIdHeader)
+
+		resp = request(httpServer.URL, http.MethodPost, "", "https://other.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("allowed hosts", func(t *testing.T) {
+		_, httpServer, _ := newEchoServer(t, WithAllowedHosts("mcp.example.com"))
+		resp := request(httpServer.URL, http.MethodPost, "mcp.example.com", "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = request(httpServer.URL, http.MethodPost, "localhost", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("middleware covers handlers in front of the transport", func(t *testing.T) {
+		tr := NewServerTransport(WithAllowedOrigins("https://app.example.com"))
+		unauthorized := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		httpServer := httptest.NewServer(tr.Middleware(unauthorized))
+		defer httpServer.Close()
+
+		resp := request(httpServer.URL, http.MethodOptions, "", "https://app.example.com")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		// Browsers can only read the challenge of a rejected request with CORS headers
+		resp = request(httpServer.URL, http.MethodPost, "", "https://app.example.com")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		resp = request(httpServer.URL, http.MethodPost, "", "https://evil.example.com")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestClientTransport(t *testing.T) {
+	_, httpServer, sessions := newEchoServer(t)
+	client := NewClientTransport(httpServer.URL)
+
+	var mu sync.Mutex
+	received := make(chan *transport.BaseJsonRpcMessage, 10)
+	client.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+		received <- message
+	})
+	client.SetErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		t.Errorf("unexpected error: %v", err)
+	})
+	assert.NoError(t, client.Start(context.Background()))
+
+	err := client.Send(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 1, Method: "initialize"}))
+	assert.NoError(t, err)
+	response := <-received
+	assert.Equal(t, transport.BaseMessageTypeJSONRPCResponseType, response.Type)
+	assert.Equal(t, transport.RequestId(1), response.JsonRpcResponse.Id)
+
+	session := <-sessions
+	assert.Equal(t, session.(transport.SessionTransport).SessionID(), client.SessionID())
+
+	// Reaches the client over the stream it opened with GET
+	err = session.Send(transport.NewBaseMessageNotification(&transport.BaseJSONRPCNotification{Jsonrpc: "2.0", Method: "hello"}))
+	assert.NoError(t, err)
+	select {
+	case message := <-received:
+		assert.Equal(t, "hello", message.JsonRpcNotification.Method)
+	case <-time.After(time.Second):
+		t.Fatal("notification was not received")
+	}
+
+	closed := make(chan struct{})
+	session.SetCloseHandler(func() {
+		close(closed)
+	})
+	mu.Lock()
+	client.SetErrorHandler(nil)
+	mu.Unlock()
+	assert.NoError(t, client.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing the client did not end the session")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Tells when the client starts reading a response
+type watchedBody struct {
+	io.ReadCloser
+	once sync.Once
+	read chan struct{}
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.once.Do(func() {
+			b.read <- struct{}{}
+		})
+	}
+	return n, err
+}
+
+func TestClientReconnect(t *testing.T) {
+	_, httpServer, sessions := newEchoServer(t, WithEventStore(NewMemoryEventStore()))
+	streamsRead := make(chan struct{}, 10)
+	httpClient := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err == nil && req.Method == http.MethodPost && resp.Header.Get("Content-Type") == "text/event-stream" {
+			resp.Body = &watchedBody{ReadCloser: resp.Body, read: streamsRead}
+		}
+		return resp, err
+	})}
+	client := NewClientTransport(httpServer.URL, WithHTTPClient(httpClient), WithReconnect(5, 10*time.Millisecond, 100*time.Millisecond))
+
+	var mu sync.Mutex
+	received := make(chan *transport.BaseJsonRpcMessage, 10)
+	client.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+		received <- message
+	})
+	client.SetErrorHandler(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		t.Errorf("unexpected error: %v", err)
+	})
+	assert.NoError(t, client.Start(context.Background()))
+	err := client.Send(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 1, Method: "initialize"}))
+	assert.NoError(t, err)
+	<-received
+	<-streamsRead
+	session := <-sessions
+	requests := make(chan *transport.BaseJsonRpcMessage, 10)
+	session.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+		requests <- message
+	})
+
+	err = client.Send(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 2, Method: "slow"}))
+	assert.NoError(t, err)
+	<-requests
+	// The client got the id of the stream's first event, then the connection breaks while the request is handled
+	<-streamsRead
+	httpServer.CloseClientConnections()
+	err = session.Send(transport.NewBaseMessageResponse(&transport.BaseJSONRPCResponse{Jsonrpc: "2.0", Id: 2, Result: json.RawMessage(`{}`)}))
+	assert.NoError(t, err)
+	select {
+	case response := <-received:
+		assert.Equal(t, transport.RequestId(2), response.JsonRpcResponse.Id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the response was not received after reconnecting")
+	}
+
+	// The stream opened with GET was reopened too
+	err = session.Send(transport.NewBaseMessageNotification(&transport.BaseJSONRPCNotification{Jsonrpc: "2.0", Method: "hello"}))
+	assert.NoError(t, err)
+	select {
+	case message := <-received:
+		assert.Equal(t, "hello", message.JsonRpcNotification.Method)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not received after reconnecting")
+	}
+
+	mu.Lock()
+	client.SetErrorHandler(nil)
+	mu.Unlock()
+	assert.NoError(t, client.Close())
+}
+
+// A certificate authority that issues certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mcp server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, x509.ExtKeyUsageServerAuth)
+	spiffeID, _ := url.Parse("spiffe://example.org/billing")
+	clientCert := ca.issue(t, &x509.Certificate{
+		URIs:     []*url.URL{spiffeID},
+		DNSNames: []string{"billing.internal"},
+	}, x509.ExtKeyUsageClientAuth)
+
+	tr := NewServerTransport(WithAddr("127.0.0.1:0"), WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	}))
+	identities := make(chan *transport.Identity, 10)
+	tr.SetSessionHandler(func(session transport.Transport) {
+		session.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {
+			identities <- message.Identity
+			result, _ := json.Marshal(map[string]string{})
+			go session.Send(transport.NewBaseMessageResponse(&transport.BaseJSONRPCResponse{
+				Jsonrpc: "2.0",
+				Id:      message.JsonRpcRequest.Id,
+				Result:  result,
+			}))
+		})
+	})
+	assert.NoError(t, tr.Start(context.Background()))
+	defer tr.Close()
+	serverURL := "https://" + tr.Addr().String()
+
+	connect := func(config *tls.Config) error {
+		client := NewClientTransport(serverURL, WithClientTLSConfig(config))
+		client.SetMessageHandler(func(message *transport.BaseJsonRpcMessage) {})
+		assert.NoError(t, client.Start(context.Background()))
+		defer client.Close()
+		return client.Send(transport.NewBaseMessageRequest(&transport.BaseJSONRPCRequest{Jsonrpc: "2.0", Id: 1, Method: "initialize"}))
+	}
+
+	t.Run("client certificates identify the session", func(t *testing.T) {
+		err := connect(&tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{clientCert}})
+		assert.NoError(t, err)
+		identity := <-identities
+		assert.Equal(t, "spiffe://example.org/billing", identity.Subject)
+		assert.Equal(t, []string{"billing.internal"}, identity.Claims["dnsNames"])
+		assert.Equal(t, "CN=test CA", identity.Claims["issuer"])
+		assert.NotNil(t, identity.Certificate)
+	})
+
+	t.Run("clients without a certificate are rejected", func(t *testing.T) {
+		err := connect(&tls.Config{RootCAs: ca.pool})
+		assert.Error(t, err)
+	})
+
+	t.Run("clients check the server's certificate", func(t *testing.T) {
+		err := connect(&tls.Config{Certificates: []tls.Certificate{clientCert}})
+		assert.ErrorContains(t, err, "certificate")
+	})
+}
@@ -0,0 +1,608 @@
+package streamablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/metoro-io/mcp-golang/transport"
+)
+
+// sessionTransport carries the messages of a single session. It is what the server's session handler is given.
+type sessionTransport struct {
+	id     string
+	server *ServerTransport
+	// Who started the session, see sessionOwner
+	owner    string
+	closedCh chan struct{}
+
+	mu        sync.Mutex
+	closed    bool
+	onMessage func(message *transport.BaseJsonRpcMessage)
+	onClose   func()
+	onError   func(error)
+	// The streams waiting for the responses to requests, by request id
+	pending map[transport.RequestId]*stream
+	// The stream the client opened with GET
+	standalone *stream
+	// The event streams of POST requests that are still open, the most recent last
+	active []*stream
+	// The event streams of POST requests that haven't ended, by stream id, for clients that resume them
+	streams map[string]*stream
+	// Messages waiting for a stream to be sent on
+	backlog []*transport.BaseJsonRpcMessage
+	// How many requests of the client are in progress, the session only expires when there are none
+	requests int
+	// Ends the session once it has been idle for the transport's idle timeout, nil while requests are in progress
+	idleTimer *time.Timer
+	// Incremented whenever the idle timer is stopped, so that a timer that fired meanwhile doesn't end the session
+	idleGeneration int
+}
+
+func newSessionTransport(id string, server *ServerTransport, owner string) *sessionTransport {
+	return &sessionTransport{
+		id:       id,
+		server:   server,
+		owner:    owner,
+		closedCh: make(chan struct{}),
+		pending:  map[transport.RequestId]*stream{},
+		streams:  map[string]*stream{},
+	}
+}
+
+// SessionID implements transport.SessionTransport
+func (s *sessionTransport) SessionID() string {
+	return s.id
+}
+
+// Stateless implements transport.StatelessTransport
+func (s *sessionTransport) Stateless() bool {
+	return s.server.stateless
+}
+
+// Start does nothing, the session receives messages as soon as the client sends them
+func (s *sessionTransport) Start(ctx context.Context) error {
+	return nil
+}
+
+// Send sends a response on the stream of the request it answers, and any other message on the stream opened with GET,
+// or on the most recent event stream of a POST if there is none. Messages are held back until the client opens a
+// stream if there is none at all.
+// Stateless sessions can't send requests, as the response would come in another POST, and drop messages there is no
+// stream for.
+func (s *sessionTransport) Send(message *transport.BaseJsonRpcMessage) error {
+	if s.server.stateless && message.Type == transport.BaseMessageTypeJSONRPCRequestType {
+		return fmt.Errorf("stateless transports can't send requests to the client")
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("session %s is closed", s.id)
+	}
+	if id, ok := responseId(message); ok {
+		target := s.pending[id]
+		delete(s.pending, id)
+		s.mu.Unlock()
+		if target == nil || !target.send(message) {
+			return fmt.Errorf("no request is waiting for the response to %d", id)
+		}
+		return nil
+	}
+	target := s.messageStream()
+	if target == nil {
+		if !s.server.stateless {
+			s.queue(message)
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	if !target.send(message) {
+		s.mu.Lock()
+		s.queue(message)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Close ends the session
+func (s *sessionTransport) Close() error {
+	s.mu.Lock()
+	s.finish()
+	return nil
+}
+
+// Ends the session, must be called with the lock held, which it releases
+func (s *sessionTransport) finish() {
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.closedCh)
+	s.stopIdleTimer()
+	onClose := s.onClose
+	s.mu.Unlock()
+
+	s.server.removeSession(s.id)
+	if onClose != nil {
+		onClose()
+	}
+}
+
+// Counts a request of the client as in progress, it returns false if the session has ended
+func (s *sessionTransport) beginRequest() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.requests++
+	s.stopIdleTimer()
+	return true
+}
+
+// Counts a request of the client as done, the session expires if no other request comes in before the idle timeout
+func (s *sessionTransport) endRequest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests--
+	timeout := s.server.idleTimeout
+	if s.requests > 0 || s.closed || timeout <= 0 {
+		return
+	}
+	s.stopIdleTimer()
+	generation := s.idleGeneration
+	s.idleTimer = time.AfterFunc(timeout, func() {
+		s.expire(generation)
+	})
+}
+
+// Must be called with the lock held
+func (s *sessionTransport) stopIdleTimer() {
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	s.idleGeneration++
+}
+
+func (s *sessionTransport) expire(generation int) {
+	s.mu.Lock()
+	if s.requests > 0 || s.idleGeneration != generation {
+		s.mu.Unlock()
+		return
+	}
+	s.finish()
+}
+
+// SetCloseHandler sets the handler for when the session ends
+func (s *sessionTransport) SetCloseHandler(handler func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = handler
+}
+
+// SetErrorHandler sets the handler for errors in the session
+func (s *sessionTransport) SetErrorHandler(handler func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = handler
+}
+
+// SetMessageHandler sets the handler for messages from the client
+func (s *sessionTransport) SetMessageHandler(handler func(message *transport.BaseJsonRpcMessage)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onMessage = handler
+}
+
+func (s *sessionTransport) handleMessage(message *transport.BaseJsonRpcMessage) {
+	s.mu.Lock()
+	handler := s.onMessage
+	s.mu.Unlock()
+	if handler != nil {
+		handler(message)
+	}
+}
+
+func (s *sessionTransport) handleError(err error) {
+	s.mu.Lock()
+	handler := s.onError
+	s.mu.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+// The stream to send messages that aren't responses on, must be called with the lock held
+func (s *sessionTransport) messageStream() *stream {
+	if s.standalone != nil {
+		return s.standalone
+	}
+	if len(s.active) > 0 {
+		return s.active[len(s.active)-1]
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (s *sessionTransport) queue(message *transport.BaseJsonRpcMessage) {
+	if len(s.backlog) >= maxBacklog {
+		s.backlog = s.backlog[1:]
+		go s.handleError(fmt.Errorf("session %s has no open stream, dropped a message", s.id))
+	}
+	s.backlog = append(s.backlog, message)
+}
+
+// Takes the messages that are waiting if st is the stream they should be sent on
+func (s *sessionTransport) takeBacklog(st *stream) []*transport.BaseJsonRpcMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messageStream() != st {
+		return nil
+	}
+	backlog := s.backlog
+	s.backlog = nil
+	return backlog
+}
+
+func (s *sessionTransport) addStream(st *stream, requestIds []transport.RequestId) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range requestIds {
+		s.pending[id] = st
+	}
+	if st.events {
+		s.active = append(s.active, st)
+		s.streams[st.id] = st
+	}
+}
+
+// The id of the stream the client opens with GET, which stays the same when the client reopens it
+func (s *sessionTransport) standaloneStreamID() string {
+	return s.id + "/standalone"
+}
+
+// The id of a new stream for the response to a POST
+func (s *sessionTransport) newStreamID() string {
+	return s.id + "/" + uuid.NewString()
+}
+
+// The event stream of a POST with the given id, nil if it has ended
+func (s *sessionTransport) stream(id string) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *sessionTransport) setStandaloneStream(st *stream) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.standalone != nil {
+		return false
+	}
+	s.standalone = st
+	return true
+}
+
+// Ends a stream once its HTTP request is over. Messages that were meant for it but weren't sent are kept for the next stream.
+func (s *sessionTransport) removeStream(st *stream) {
+	st.end()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, pending := range s.pending {
+		if pending == st {
+			delete(s.pending, id)
+		}
+	}
+	if s.standalone == st {
+		s.standalone = nil
+	}
+	if s.streams[st.id] == st {
+		delete(s.streams, st.id)
+	}
+	s.deactivate(st)
+	for _, message := range st.pending() {
+		if _, ok := responseId(message); !ok {
+			s.queue(message)
+		}
+	}
+}
+
+// Stops sending messages other than responses on a stream, must be called with the lock held
+func (s *sessionTransport) deactivate(st *stream) {
+	for i, active := range s.active {
+		if active == st {
+			s.active = append(s.active[:i], s.active[i+1:]...)
+			break
+		}
+	}
+}
+
+// The store the events of the session's streams are kept in, nil if streams can't be resumed
+func (s *sessionTransport) eventStore() EventStore {
+	// Stateless clients have no way to come back to their session
+	if s.server.stateless {
+		return nil
+	}
+	return s.server.eventStore
+}
+
+// Stores a message sent on a stream and returns the id of its event, empty if streams can't be resumed
+func (s *sessionTransport) storeEvent(st *stream, message *transport.BaseJsonRpcMessage) string {
+	store := s.eventStore()
+	if store == nil {
+		return ""
+	}
+	id, err := store.StoreEvent(context.Background(), st.id, message)
+	if err != nil {
+		s.handleError(fmt.Errorf("failed to store event: %w", err))
+		return ""
+	}
+	st.signalStored()
+	return id
+}
+
+// Starts answering a request with an event stream. With an event store, new streams open with an event without a
+// message, so that clients can resume them even if they break before the first message.
+func (s *sessionTransport) startEvents(w http.ResponseWriter, st *stream) error {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if st != nil {
+		if id := s.storeEvent(st, nil); id != "" {
+			return writeEvent(w, id, nil)
+		}
+	}
+	flush(w)
+	return nil
+}
+
+// Writes the messages of a stream as server-sent events until responses responses have been written, the client goes
+// away or the session ends. A negative number of responses keeps the stream open.
+func (s *sessionTransport) writeEvents(w http.ResponseWriter, r *http.Request, st *stream, responses int) {
+	for _, message := range s.takeBacklog(st) {
+		if err := writeEvent(w, s.storeEvent(st, message), message); err != nil {
+			s.keepStoring(st, responses)
+			return
+		}
+	}
+	for responses != 0 {
+		select {
+		case message := <-st.messages:
+			err := writeEvent(w, s.storeEvent(st, message), message)
+			if _, ok := responseId(message); ok {
+				responses--
+			}
+			if err != nil {
+				s.handleError(fmt.Errorf("failed to write event: %w", err))
+				s.keepStoring(st, responses)
+				return
+			}
+		case <-r.Context().Done():
+			s.keepStoring(st, responses)
+			return
+		case <-s.closedCh:
+			// Messages sent before the session closed still reach the client
+			for _, message := range st.pending() {
+				if err := writeEvent(w, s.storeEvent(st, message), message); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// Keeps storing the messages of the stream of a POST whose client went away until every response was sent, so that
+// the client can resume the stream to get them
+func (s *sessionTransport) keepStoring(st *stream, responses int) {
+	if s.eventStore() == nil || responses <= 0 {
+		return
+	}
+	// Other messages go to streams the client is still reading
+	s.mu.Lock()
+	s.deactivate(st)
+	s.mu.Unlock()
+	for responses > 0 {
+		select {
+		case message := <-st.messages:
+			s.storeEvent(st, message)
+			if _, ok := responseId(message); ok {
+				responses--
+			}
+		case <-s.closedCh:
+			return
+		}
+	}
+}
+
+// Resumes the stream an event was sent on, for a client that reconnected with the id of the last event it received.
+// The messages sent on the stream after that event are replayed, then the stream carries on: the stream of a POST
+// until every response was sent, the stream opened with GET until the client goes away.
+func (s *sessionTransport) resume(w http.ResponseWriter, r *http.Request, lastEventID string) {
+	store := s.eventStore()
+	streamID, err := store.StreamID(r.Context(), lastEventID)
+	// Streams of other sessions are as good as unknown
+	if errors.Is(err, ErrEventNotFound) || err == nil && !strings.HasPrefix(streamID, s.id+"/") {
+		writeError(w, http.StatusNotFound, "event not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to resume stream: %s", err))
+		return
+	}
+	replay := func() error {
+		err := store.ReplayEventsAfter(r.Context(), lastEventID, func(id string, message *transport.BaseJsonRpcMessage) error {
+			lastEventID = id
+			return writeEvent(w, id, message)
+		})
+		if err != nil && r.Context().Err() == nil {
+			s.handleError(fmt.Errorf("failed to replay events: %w", err))
+		}
+		return err
+	}
+	w.Header().Set(sessionIdHeader, s.id)
+
+	if streamID == s.standaloneStreamID() {
+		st := newStream(streamID, true)
+		if !s.setStandaloneStream(st) {
+			writeError(w, http.StatusConflict, "the session already has an open stream")
+			return
+		}
+		defer s.removeStream(st)
+		if s.startEvents(w, nil) != nil || replay() != nil {
+			return
+		}
+		s.writeEvents(w, r, st, -1)
+		return
+	}
+
+	if s.startEvents(w, nil) != nil {
+		return
+	}
+	// The stream is still being written to if the request it answers isn't done, follow the events stored for it
+	st := s.stream(streamID)
+	for {
+		var stored, done <-chan struct{}
+		if st != nil {
+			// Taken before replaying so that events stored meanwhile aren't missed
+			stored, done = st.storedSignal(), st.done
+		}
+		if replay() != nil || st == nil {
+			return
+		}
+		select {
+		case <-stored:
+		case <-done:
+			st = nil
+		case <-s.closedCh:
+			st = nil
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Writes the responses to the requests of a POST as a JSON body
+func (s *sessionTransport) writeJSON(w http.ResponseWriter, r *http.Request, st *stream, responses int, batch bool) {
+	var messages []*transport.BaseJsonRpcMessage
+	for len(messages) < responses {
+		select {
+		case message := <-st.messages:
+			messages = append(messages, message)
+		case <-r.Context().Done():
+			return
+		case <-s.closedCh:
+			messages = append(messages, st.pending()...)
+			if len(messages) < responses {
+				writeError(w, http.StatusNotFound, "session closed")
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	var body interface{} = messages
+	if !batch && len(messages) == 1 {
+		body = messages[0]
+	}
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		s.handleError(fmt.Errorf("failed to write response: %w", err))
+	}
+}
+
+// stream is the body of an HTTP response that messages are sent on
+type stream struct {
+	id string
+	// Whether the stream is an event stream, which can carry messages other than responses
+	events   bool
+	messages chan *transport.BaseJsonRpcMessage
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mu sync.Mutex
+	// Closed and replaced whenever an event of the stream is stored
+	stored chan struct{}
+}
+
+func newStream(id string, events bool) *stream {
+	return &stream{
+		id:       id,
+		events:   events,
+		messages: make(chan *transport.BaseJsonRpcMessage, 64),
+		done:     make(chan struct{}),
+		stored:   make(chan struct{}),
+	}
+}
+
+// Queues a message to be written, it returns false if the stream has ended
+func (st *stream) send(message *transport.BaseJsonRpcMessage) bool {
+	select {
+	case <-st.done:
+		return false
+	default:
+	}
+	select {
+	case st.messages <- message:
+		return true
+	case <-st.done:
+		return false
+	}
+}
+
+// Takes the messages that are queued and not written yet
+func (st *stream) pending() []*transport.BaseJsonRpcMessage {
+	var messages []*transport.BaseJsonRpcMessage
+	for {
+		select {
+		case message := <-st.messages:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+// A channel that is closed once the next event of the stream is stored
+func (st *stream) storedSignal() <-chan struct{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.stored
+}
+
+func (st *stream) signalStored() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	close(st.stored)
+	st.stored = make(chan struct{})
+}
+
+func (st *stream) end() {
+	st.doneOnce.Do(func() {
+		close(st.done)
+	})
+}
+
+func responseId(message *transport.BaseJsonRpcMessage) (transport.RequestId, bool) {
+	switch message.Type {
+	case transport.BaseMessageTypeJSONRPCResponseType:
+		return message.JsonRpcResponse.Id, true
+	case transport.BaseMessageTypeJSONRPCErrorType:
+		return message.JsonRpcError.Id, true
+	}
+	return 0, false
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -32,3 +32,28 @@ type Transport interface {
 	// Partially deserializes the messages to pass a BaseJsonRpcMessage
 	SetMessageHandler(handler func(message *BaseJsonRpcMessage))
 }
+
+// SessionTransport is implemented by transports that identify the session they carry themselves, e.g. with a session id
+// header. Servers use the id as the id of the session, other transports get a random one.
+type SessionTransport interface {
+	SessionID() string
+}
+
+// SessionListener is implemented by transports that carry many sessions, e.g. an HTTP server that every client connects
+// to separately. Rather than exchanging messages over the listener itself, servers start a session over each transport
+// the listener hands to the session handler, and close the listener to stop accepting sessions.
+type SessionListener interface {
+	Transport
+
+	// SetSessionHandler sets the callback for when a client starts a new session.
+	// The transport given to it carries the messages of that session only.
+	SetSessionHandler(handler func(session Transport))
+}
+
+// StatelessTransport is implemented by transports that may not keep sessions between requests, e.g. an HTTP server
+// running as one of many replicas behind a load balancer. Each request is answered on its own, so servers can't send
+// requests to the client or remember anything about it between requests, such as its resource subscriptions.
+type StatelessTransport interface {
+	// Stateless reports whether the transport runs without sessions
+	Stateless() bool
+}
@@ -98,9 +98,10 @@ type BaseJSONRPCNotification struct {
 // Requires a Jsonrpc and Method
 func (m *BaseJSONRPCNotification) UnmarshalJSON(data []byte) error {
 	required := struct {
-		Jsonrpc *string `json:"jsonrpc" yaml:"jsonrpc" mapstructure:"jsonrpc"`
-		Method  *string `json:"method" yaml:"method" mapstructure:"method"`
-		Id      *int64  `json:"id" yaml:"id" mapstructure:"id"`
+		Jsonrpc *string          `json:"jsonrpc" yaml:"jsonrpc" mapstructure:"jsonrpc"`
+		Method  *string          `json:"method" yaml:"method" mapstructure:"method"`
+		Id      *int64           `json:"id" yaml:"id" mapstructure:"id"`
+		Params  *json.RawMessage `json:"params" yaml:"params" mapstructure:"params"`
 	}{}
 	err := json.Unmarshal(data, &required)
 	if err != nil {
@@ -117,6 +118,9 @@ func (m *BaseJSONRPCNotification) UnmarshalJSON(data []byte) error {
 	}
 	m.Jsonrpc = *required.Jsonrpc
 	m.Method = *required.Method
+	if required.Params != nil {
+		m.Params = *required.Params
+	}
 	return nil
 }
 
@@ -176,6 +180,11 @@ type BaseJsonRpcMessage struct {
 	JsonRpcNotification *BaseJSONRPCNotification
 	JsonRpcResponse     *BaseJSONRPCResponse
 	JsonRpcError        *BaseJSONRPCError
+	// Metadata describes how the message was received, e.g. the remote address of an HTTP request.
+	// It is set by transports that have something to report and is never sent.
+	Metadata map[string]string
+	// Identity is who the transport authenticated the sender as, nil if it didn't. It is never sent.
+	Identity *Identity
 }
 
 func (m *BaseJsonRpcMessage) MarshalJSON() ([]byte, error) {
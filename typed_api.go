@@ -0,0 +1,151 @@
+package mcp_golang
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// The functions in this file are generic equivalents of the Server's Register methods.
+// Handler signatures are checked by the compiler rather than at registration or call time, and handlers are called
+// directly rather than through reflection. Reflection is only used to generate the schemas from the argument types and to allocate the arguments.
+// Both kinds of registration share the cores at the end of this file, so that arguments are decoded and results are
+// sent in the same way whichever one a tool or prompt was registered with.
+
+// RegisterTool registers a new tool with the server whose handler takes a struct of arguments and returns a *ToolResponse.
+// In must be a struct, its fields and jsonschema tags describe the tool's input schema.
+func RegisterTool[In any](s *Server, name string, description string, handler func(context.Context, In) (*ToolResponse, error), options ...ToolOptions) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	return s.registerTypedTool(name, description, reflect.TypeFor[In](), nil, func(ctx context.Context, arguments any) (any, error) {
+		return handler(ctx, *arguments.(*In))
+	}, options)
+}
+
+// RegisterStructuredTool registers a new tool with the server whose handler returns a struct, or a pointer to one.
+// The output is sent to the client as structured content alongside a JSON text fallback and an output schema is generated for it.
+func RegisterStructuredTool[In any, Out any](s *Server, name string, description string, handler func(context.Context, In) (Out, error), options ...ToolOptions) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	return s.registerTypedTool(name, description, reflect.TypeFor[In](), reflect.TypeFor[Out](), func(ctx context.Context, arguments any) (any, error) {
+		return handler(ctx, *arguments.(*In))
+	}, options)
+}
+
+// RegisterPrompt registers a new prompt with the server.
+// In must be a struct whose fields are strings, booleans or numbers, or pointers to them. They become the prompt's
+// arguments, named after their json tags, and are converted from the strings the client sends.
+func RegisterPrompt[In any](s *Server, name string, description string, handler func(context.Context, In) (*PromptResponse, error)) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	return s.registerTypedPrompt(name, description, reflect.TypeFor[In](), func(ctx context.Context, arguments any) (*PromptResponse, error) {
+		return handler(ctx, *arguments.(*In))
+	})
+}
+
+// RegisterResource registers a new resource with the server.
+// Resources take no arguments so this isn't generic, it exists so that all three kinds can be registered in the same way.
+func RegisterResource(s *Server, uri string, name string, description string, mimeType string, handler func(context.Context) (*ResourceResponse, error)) error {
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	return s.registerResource(&resource{
+		Name:        name,
+		Description: description,
+		Uri:         uri,
+		mimeType:    mimeType,
+		Handler: func(ctx context.Context) *resourceResponseSent {
+			response, err := handler(ctx)
+			if err != nil {
+				return newResourceResponseSentError(err)
+			}
+			if response == nil {
+				return newResourceResponseSentError(fmt.Errorf("handler returned a nil response"))
+			}
+			return newResourceResponseSent(response)
+		},
+	})
+}
+
+// Registers a tool whose arguments are decoded into a new struct of inputType, which call is given a pointer to.
+// call returns a *ToolResponse, or if outputType is set a value of that type, a struct or a pointer to one, which is
+// sent as structured content.
+func (s *Server) registerTypedTool(name string, description string, inputType reflect.Type, outputType reflect.Type, call func(ctx context.Context, arguments any) (any, error), options []ToolOptions) error {
+	if inputType.Kind() != reflect.Struct {
+		return fmt.Errorf("tool arguments must be a struct, got %s", inputType.Kind())
+	}
+	t := &tool{
+		Name:            name,
+		Description:     description,
+		ToolInputSchema: s.reflectSchema(inputType),
+	}
+	if outputType != nil {
+		if outputType.Kind() == reflect.Ptr {
+			outputType = outputType.Elem()
+		}
+		if outputType.Kind() != reflect.Struct {
+			return fmt.Errorf("tool output must be a struct or a pointer to a struct, got %s", outputType.Kind())
+		}
+		t.ToolOutputSchema = s.reflectSchema(outputType)
+	}
+	structured := outputType != nil
+	t.Handler = func(ctx context.Context, arguments baseCallToolRequestParams) *toolResponseSent {
+		in := reflect.New(inputType).Interface()
+		err := decodeToolArguments(arguments.Arguments, in)
+		if err != nil {
+			return newToolResponseSentError(fmt.Errorf("failed to unmarshal arguments: %w", err))
+		}
+		out, err := call(ctx, in)
+		if err != nil {
+			return newToolResponseSentError(err)
+		}
+		if !structured {
+			response, _ := out.(*ToolResponse)
+			if response == nil {
+				return newToolResponseSentError(fmt.Errorf("handler returned a nil response"))
+			}
+			return newToolResponseSent(response)
+		}
+		outValue := reflect.ValueOf(out)
+		if !outValue.IsValid() || (outValue.Kind() == reflect.Ptr && outValue.IsNil()) {
+			return newToolResponseSentError(fmt.Errorf("handler returned a nil result"))
+		}
+		return newStructuredToolResponseSent(out)
+	}
+	return s.registerTool(t, options)
+}
+
+// Registers a prompt whose arguments are converted into a new struct of inputType, which call is given a pointer to
+func (s *Server) registerTypedPrompt(name string, description string, inputType reflect.Type, call func(ctx context.Context, arguments any) (*PromptResponse, error)) error {
+	err := validatePromptArgumentType(inputType)
+	if err != nil {
+		return err
+	}
+	return s.registerPrompt(&prompt{
+		Name:        name,
+		Description: description,
+		Handler: func(ctx context.Context, arguments baseGetPromptRequestParamsArguments) *promptResponseSent {
+			values, err := decodePromptArguments(arguments.Arguments)
+			if err != nil {
+				return newPromptResponseSentError(err)
+			}
+			in := reflect.New(inputType)
+			err = bindPromptArguments(values, in)
+			if err != nil {
+				return newPromptResponseSentError(err)
+			}
+			response, err := call(ctx, in.Interface())
+			if err != nil {
+				return newPromptResponseSentError(err)
+			}
+			if response == nil {
+				return newPromptResponseSentError(fmt.Errorf("handler returned a nil response"))
+			}
+			return newPromptResponseSent(response)
+		},
+		PromptInputSchema: createPromptSchemaFromType(inputType),
+	})
+}